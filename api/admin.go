@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAuditLimit and maxAuditLimit bound how many audit entries are returned at once
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// UpdateDatapointRequest is the body accepted when correcting a datapoint's price
+type UpdateDatapointRequest struct {
+	Price *float64 `json:"price"`
+}
+
+// HideProductRequest is the optional body accepted when hiding a product
+type HideProductRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AdminChangeResponse is the body returned by admin endpoints that modify data
+type AdminChangeResponse struct {
+	Message  string `json:"message"`
+	Affected int64  `json:"affected"`
+}
+
+// HiddenProductInfo describes a hidden product
+type HiddenProductInfo struct {
+	ProductID string `json:"product_id"`
+	Reason    string `json:"reason"`
+	HiddenAt  string `json:"hidden_at"`
+}
+
+// HiddenProductsResponse is the body returned when listing hidden products
+type HiddenProductsResponse struct {
+	Products []HiddenProductInfo `json:"products"`
+}
+
+// AuditEntryInfo is a single audit log entry
+type AuditEntryInfo struct {
+	ID      int64           `json:"id"`
+	At      string          `json:"at"`
+	Actor   string          `json:"actor"`
+	Action  string          `json:"action"`
+	Target  string          `json:"target"`
+	Details json.RawMessage `json:"details"`
+}
+
+// AuditLogResponse is the body returned by the audit log endpoint
+type AuditLogResponse struct {
+	Entries []AuditEntryInfo `json:"entries"`
+}
+
+// parseTimeWindow turns a datapoint or scrape identifier into the [from, to) range it
+// covers: a YYYY-MM-DD date selects the whole day, an RFC 3339 timestamp that second
+func parseTimeWindow(v string) (time.Time, time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected a YYYY-MM-DD date or an RFC 3339 timestamp, got %q", v)
+	}
+	t = t.Truncate(time.Second)
+	return t, t.Add(time.Second), nil
+}
+
+// recomputeStats rebuilds a product's stats from its history, dropping them when no
+// datapoints remain
+func recomputeStats(productID string) error {
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return store.DeleteStats(productID)
+	}
+	return store.PutStats(productID, statsFromHistory(history))
+}
+
+// auditActor names whoever made the request for the audit log
+func auditActor(c *gin.Context) string {
+	if key, ok := requestAPIKey(c); ok {
+		return fmt.Sprintf("key:%s (%s)", key.ID, key.Name)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// recordAudit appends an admin change made over HTTP to the audit log
+func recordAudit(c *gin.Context, action, target string, details map[string]any) {
+	writeAudit(auditActor(c), action, target, details)
+}
+
+// writeAudit appends a change to the audit log. The change has already been applied,
+// so a failure is logged rather than returned.
+func writeAudit(actor, action, target string, details map[string]any) {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		detailsJSON = []byte("{}")
+	}
+	err = store.InsertAuditEntry(AuditEntry{
+		At:      time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Details: string(detailsJSON),
+	})
+	if err != nil {
+		fmt.Printf("WARNING: failed to record audit entry %s %s: %v\n", action, target, err)
+	}
+}
+
+// patchDatapoint corrects the price recorded for a product at a datetime
+func patchDatapoint(c *gin.Context) {
+	productID := c.Param("id")
+	from, to, err := parseTimeWindow(c.Param("datetime"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid datetime", "details": err.Error()})
+		return
+	}
+
+	var req UpdateDatapointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	if req.Price == nil || *req.Price <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price must be a positive number"})
+		return
+	}
+
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product datapoints"})
+		return
+	}
+	previous := []map[string]any{}
+	for _, r := range history {
+		if !r.Datetime.Before(from) && r.Datetime.Before(to) {
+			previous = append(previous, map[string]any{"datetime": formatTimestamp(r.Datetime), "price": r.Price})
+		}
+	}
+
+	n, err := store.UpdateDatapoints(productID, from, to, *req.Price)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update datapoint"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Datapoint not found"})
+		return
+	}
+	if err := recomputeStats(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint updated but failed to recompute stats", "details": err.Error()})
+		return
+	}
+	if err := recomputeLifecycles(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint updated but failed to recompute lifecycle", "details": err.Error()})
+		return
+	}
+	if err := rebuildPriceChanges(from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint updated but failed to rebuild price changes", "details": err.Error()})
+		return
+	}
+
+	recordAudit(c, "datapoint.update", productID+"@"+c.Param("datetime"), map[string]any{"price": *req.Price, "affected": n, "previous": previous})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Datapoint updated", Affected: n})
+}
+
+// deleteDatapoint removes the price recorded for a product at a datetime
+func deleteDatapoint(c *gin.Context) {
+	productID := c.Param("id")
+	from, to, err := parseTimeWindow(c.Param("datetime"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid datetime", "details": err.Error()})
+		return
+	}
+
+	n, err := store.DeleteDatapoints(productID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete datapoint"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Datapoint not found"})
+		return
+	}
+	if err := recomputeStats(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint deleted but failed to recompute stats", "details": err.Error()})
+		return
+	}
+	if err := recomputeLifecycles(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint deleted but failed to recompute lifecycle", "details": err.Error()})
+		return
+	}
+	if err := rebuildPriceChanges(from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Datapoint deleted but failed to rebuild price changes", "details": err.Error()})
+		return
+	}
+
+	recordAudit(c, "datapoint.delete", productID+"@"+c.Param("datetime"), map[string]any{"affected": n})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Datapoint deleted", Affected: n})
+}
+
+// deleteScrape removes every datapoint recorded by a scrape and recomputes the stats
+// of the products it touched and the price changes of the run that followed it
+func deleteScrape(c *gin.Context) {
+	from, to, err := parseTimeWindow(c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scrape date", "details": err.Error()})
+		return
+	}
+
+	productIDs, err := store.DeleteScrape(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete scrape"})
+		return
+	}
+	if len(productIDs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No datapoints found for that scrape"})
+		return
+	}
+	for _, id := range productIDs {
+		if err := recomputeStats(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Scrape deleted but failed to recompute stats", "details": err.Error()})
+			return
+		}
+	}
+
+	if err := recomputeLifecycles(productIDs...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Scrape deleted but failed to recompute lifecycles", "details": err.Error()})
+		return
+	}
+	if err := rebuildPriceChanges(from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Scrape deleted but failed to rebuild price changes", "details": err.Error()})
+		return
+	}
+
+	recordAudit(c, "scrape.delete", c.Param("date"), map[string]any{"products": len(productIDs)})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Scrape deleted", Affected: int64(len(productIDs))})
+}
+
+// hideProduct withholds a product from the public listings and detail endpoints
+func hideProduct(c *gin.Context) {
+	productID := c.Param("id")
+	var req HideProductRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+	}
+
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product datapoints"})
+		return
+	}
+	if len(history) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if err := store.HideProduct(HiddenProduct{ProductID: productID, Reason: req.Reason, HiddenAt: time.Now().UTC()}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hide product"})
+		return
+	}
+
+	recordAudit(c, "product.hide", productID, map[string]any{"reason": req.Reason})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Product hidden", Affected: 1})
+}
+
+// unhideProduct makes a hidden product public again
+func unhideProduct(c *gin.Context) {
+	productID := c.Param("id")
+	err := store.UnhideProduct(productID)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product is not hidden"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unhide product"})
+		return
+	}
+
+	recordAudit(c, "product.unhide", productID, map[string]any{})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Product unhidden", Affected: 1})
+}
+
+// listHiddenProducts returns every hidden product
+func listHiddenProducts(c *gin.Context) {
+	hidden, err := store.HiddenProducts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list hidden products"})
+		return
+	}
+	products := make([]HiddenProductInfo, 0, len(hidden))
+	for _, p := range hidden {
+		products = append(products, HiddenProductInfo{
+			ProductID: p.ProductID,
+			Reason:    p.Reason,
+			HiddenAt:  formatTimestamp(p.HiddenAt),
+		})
+	}
+	c.JSON(http.StatusOK, HiddenProductsResponse{Products: products})
+}
+
+// postRecomputeProductStats rebuilds one product's stats from its history
+func postRecomputeProductStats(c *gin.Context) {
+	productID := c.Param("id")
+	if err := recomputeStats(productID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute stats", "details": err.Error()})
+		return
+	}
+
+	recordAudit(c, "stats.recompute", productID, map[string]any{})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Stats recomputed", Affected: 1})
+}
+
+// postRecomputeAllStats rebuilds every product's stats from its history
+func postRecomputeAllStats(c *gin.Context) {
+	productIDs, err := store.ProductIDs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list products"})
+		return
+	}
+	for _, id := range productIDs {
+		if err := recomputeStats(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute stats", "details": err.Error()})
+			return
+		}
+	}
+
+	recordAudit(c, "stats.recompute", "*", map[string]any{"products": len(productIDs)})
+	invalidateCaches()
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Stats recomputed", Affected: int64(len(productIDs))})
+}
+
+// getAuditLog returns the most recent admin changes, newest first
+func getAuditLog(c *gin.Context) {
+	limit := defaultAuditLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	entries, err := store.AuditLog(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit log"})
+		return
+	}
+	infos := make([]AuditEntryInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, AuditEntryInfo{
+			ID:      e.ID,
+			At:      formatTimestamp(e.At),
+			Actor:   e.Actor,
+			Action:  e.Action,
+			Target:  e.Target,
+			Details: json.RawMessage(e.Details),
+		})
+	}
+	c.JSON(http.StatusOK, AuditLogResponse{Entries: infos})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seedHistory records one datapoint per day starting 2025-01-01 for each product
+func seedHistory(t *testing.T, prices map[string][]float64) {
+	t.Helper()
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for id, history := range prices {
+		for i, price := range history {
+			at := start.AddDate(0, 0, i)
+			record := ProductRecord{ProductID: id, Name: id, Price: price, URL: "https://example.com/" + id, Categories: []string{"men/tops"}, Datetime: at}
+			if err := store.InsertProduct(record); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.UpdateStats(id, price, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestAdminCorrectsDatapoints(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		seedHistory(t, map[string][]float64{"E100": {29.90, 2.99, 29.90, 19.90}})
+
+		if rec := do(t, router, http.MethodGet, "/api/v1/admin/audit", newTestKey(t, ScopeIngest), nil); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected admin scope to be required, got %d", rec.Code)
+		}
+
+		// The 2.99 scrape is a typo for 29.90; correcting it moves the lowest price back up
+		rec := do(t, router, http.MethodPatch, "/api/v1/admin/products/E100/datapoints/2025-01-02", adminKey, map[string]any{"price": 29.90})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+		}
+		var detail ProductDetailResponse
+		get(t, router, "/api/v1/product/E100", &detail)
+		if detail.LowestPrice.LowestPrice != 19.90 || detail.RegularPrice != 29.90 {
+			t.Errorf("stats not recomputed after update: low %v regular %v", detail.LowestPrice.LowestPrice, detail.RegularPrice)
+		}
+
+		rec = do(t, router, http.MethodDelete, "/api/v1/admin/products/E100/datapoints/2025-01-04T00:00:00Z", adminKey, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("delete: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/product/E100", &detail)
+		if len(detail.Datapoints) != 3 || detail.LowestPrice.LowestPrice != 29.90 {
+			t.Errorf("expected 3 datapoints at 29.90 after delete, got %d with low %v", len(detail.Datapoints), detail.LowestPrice.LowestPrice)
+		}
+
+		if rec := do(t, router, http.MethodDelete, "/api/v1/admin/products/E100/datapoints/2024-12-31", adminKey, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("missing datapoint: expected 404, got %d", rec.Code)
+		}
+		if rec := do(t, router, http.MethodDelete, "/api/v1/admin/products/E100/datapoints/yesterday", adminKey, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("bad datetime: expected 400, got %d", rec.Code)
+		}
+		if rec := do(t, router, http.MethodPatch, "/api/v1/admin/products/E100/datapoints/2025-01-01", adminKey, map[string]any{"price": -1}); rec.Code != http.StatusBadRequest {
+			t.Errorf("negative price: expected 400, got %d", rec.Code)
+		}
+
+		var audit AuditLogResponse
+		rec = do(t, router, http.MethodGet, "/api/v1/admin/audit", adminKey, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &audit); err != nil {
+			t.Fatal(err)
+		}
+		if len(audit.Entries) != 2 || audit.Entries[0].Action != "datapoint.delete" || audit.Entries[1].Action != "datapoint.update" {
+			t.Fatalf("unexpected audit log: %+v", audit.Entries)
+		}
+		if audit.Entries[1].Target != "E100@2025-01-02" {
+			t.Errorf("unexpected audit target %q", audit.Entries[1].Target)
+		}
+		var details struct {
+			Previous []struct {
+				Datetime string  `json:"datetime"`
+				Price    float64 `json:"price"`
+			} `json:"previous"`
+		}
+		if err := json.Unmarshal(audit.Entries[1].Details, &details); err != nil {
+			t.Fatal(err)
+		}
+		if len(details.Previous) != 1 || details.Previous[0].Price != 2.99 || details.Previous[0].Datetime != "2025-01-02T00:00:00Z" {
+			t.Errorf("expected the update to record the price it replaced, got %+v", details.Previous)
+		}
+	})
+}
+
+func TestAdminDeletesScrape(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		seedHistory(t, map[string][]float64{"E100": {29.90, 9.90}, "E200": {14.90}})
+
+		rec := do(t, router, http.MethodDelete, "/api/v1/admin/scrapes/2025-01-02", adminKey, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("delete scrape: %d %s", rec.Code, rec.Body.String())
+		}
+
+		stats, err := store.GetStats("E100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LowestPrice != 29.90 {
+			t.Errorf("expected lowest price to drop the deleted scrape, got %v", stats.LowestPrice)
+		}
+
+		// The first scrape is now the latest
+		var list ProductsListResponse
+		get(t, router, "/api/v1/products", &list)
+		if list.Count != 2 {
+			t.Errorf("expected both products in the remaining scrape, got %d", list.Count)
+		}
+	})
+}
+
+func TestAdminHidesProducts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		seedHistory(t, map[string][]float64{"E100": {29.90}, "E200": {14.90}})
+
+		if rec := do(t, router, http.MethodPut, "/api/v1/admin/products/E200/hidden", adminKey, HideProductRequest{Reason: "not a Uniqlo product"}); rec.Code != http.StatusOK {
+			t.Fatalf("hide: %d %s", rec.Code, rec.Body.String())
+		}
+
+		var list ProductsListResponse
+		get(t, router, "/api/v1/products", &list)
+		if list.Count != 1 || list.Products[0].ProductID != "E100" {
+			t.Errorf("expected hidden product to be left out, got %+v", list.Products)
+		}
+		if rec := get(t, router, "/api/v1/product/E200", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("hidden product detail: expected 404, got %d", rec.Code)
+		}
+
+		var hidden HiddenProductsResponse
+		rec := do(t, router, http.MethodGet, "/api/v1/admin/hidden", adminKey, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &hidden); err != nil {
+			t.Fatal(err)
+		}
+		if len(hidden.Products) != 1 || hidden.Products[0].Reason != "not a Uniqlo product" {
+			t.Errorf("unexpected hidden products: %+v", hidden.Products)
+		}
+
+		if rec := do(t, router, http.MethodDelete, "/api/v1/admin/products/E200/hidden", adminKey, nil); rec.Code != http.StatusOK {
+			t.Fatalf("unhide: %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/product/E200", nil); rec.Code != http.StatusOK {
+			t.Errorf("unhidden product detail: expected 200, got %d", rec.Code)
+		}
+		if rec := do(t, router, http.MethodPut, "/api/v1/admin/products/E999/hidden", adminKey, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unknown product: expected 404, got %d", rec.Code)
+		}
+	})
+}
+
+func TestAdminRecomputesStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		seedHistory(t, map[string][]float64{"E100": {29.90, 19.90}, "E200": {14.90}})
+
+		// Simulate stats that drifted from the history
+		if err := store.PutStats("E100", ProductStats{LowestPrice: 0.01, HighestPrice: 999, RegularPrice: 5}); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := do(t, router, http.MethodPost, "/api/v1/admin/recompute-stats", adminKey, nil)
+		var change AdminChangeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &change); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK || change.Affected != 2 {
+			t.Fatalf("recompute: %d %+v", rec.Code, change)
+		}
+
+		stats, err := store.GetStats("E100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)
+		if stats.LowestPrice != 19.90 || stats.HighestPrice != 29.90 || !stats.LowestPriceDatetime.Equal(want) {
+			t.Errorf("unexpected recomputed stats: %+v", stats)
+		}
+
+		if rec := do(t, router, http.MethodPost, "/api/v1/admin/products/E200/recompute-stats", adminKey, nil); rec.Code != http.StatusOK {
+			t.Errorf("recompute one: expected 200, got %d", rec.Code)
+		}
+	})
+}
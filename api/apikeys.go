@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API key scopes
+const (
+	// ScopeIngest allows uploading scraper output
+	ScopeIngest = "ingest"
+	// ScopeAdmin allows managing keys and data, and implies every other scope
+	ScopeAdmin = "admin"
+	// ScopeReadHighRate raises the public rate limits for the key
+	ScopeReadHighRate = "read-high-rate"
+)
+
+var validScopes = []string{ScopeIngest, ScopeAdmin, ScopeReadHighRate}
+
+// apiKeyPrefix marks tokens as Uniqlo Price Tracker keys, which makes leaked keys easy to grep for
+const apiKeyPrefix = "upt_"
+
+// apiKeyContextKey is where the authenticated key is stored on the gin context
+const apiKeyContextKey = "api_key"
+
+// lastUsedInterval limits how often a key's last-used time is written back
+const lastUsedInterval = time.Minute
+
+// ErrInvalidAPIKey is returned for unknown, expired or revoked keys
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyInfo describes a key without its secret
+type APIKeyInfo struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	RevokedAt  *string  `json:"revoked_at"`
+}
+
+// APIKeysResponse is the body returned when listing keys
+type APIKeysResponse struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+// CreateAPIKeyRequest is the body accepted when creating a key
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt *string  `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse returns a new key. The secret is only ever shown here.
+type CreateAPIKeyResponse struct {
+	Key    string     `json:"key"`
+	APIKey APIKeyInfo `json:"api_key"`
+}
+
+func newAPIKeyInfo(k APIKey) APIKeyInfo {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return APIKeyInfo{
+		ID:         k.ID,
+		Name:       k.Name,
+		Scopes:     scopes,
+		CreatedAt:  formatTimestamp(k.CreatedAt),
+		ExpiresAt:  formatDatetime(k.ExpiresAt),
+		LastUsedAt: formatDatetime(k.LastUsedAt),
+		RevokedAt:  formatDatetime(k.RevokedAt),
+	}
+}
+
+// hashAPIKey returns the hex SHA-256 of a key. Keys carry 256 bits of randomness,
+// so a fast hash is enough to make a leaked database useless.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasScope reports whether a key grants scope, either directly or through admin
+func (k APIKey) hasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// active reports whether a key can still be used
+func (k APIKey) active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// validateScopes rejects unknown scopes and empty scope lists
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required (%s)", strings.Join(validScopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(validScopes, scope) {
+			return fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(validScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// validateAPIKey checks the name and scopes of a key about to be created
+func validateAPIKey(name string, scopes []string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("a key name is required")
+	}
+	return validateScopes(scopes)
+}
+
+// apiKeyAuditDetails describes a created key in the audit log, without its secret
+func apiKeyAuditDetails(key APIKey) map[string]any {
+	details := map[string]any{"name": key.Name, "scopes": key.Scopes}
+	if !key.ExpiresAt.IsZero() {
+		details["expires_at"] = formatTimestamp(key.ExpiresAt)
+	}
+	return details
+}
+
+// createAPIKey generates and stores a new key, returning the plaintext secret
+func createAPIKey(name string, scopes []string, expiresAt time.Time) (string, APIKey, error) {
+	if err := validateAPIKey(name, scopes); err != nil {
+		return "", APIKey{}, err
+	}
+
+	idBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", APIKey{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", APIKey{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	id := hex.EncodeToString(idBytes)
+	secret := apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	key := APIKey{
+		ID:        id,
+		Name:      name,
+		Hash:      hashAPIKey(secret),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	if err := store.CreateAPIKey(key); err != nil {
+		return "", APIKey{}, err
+	}
+	return secret, key, nil
+}
+
+// lastUsedWrites remembers when each key's last-used time was written, so busy
+// keys don't cost a database write per request
+var lastUsedWrites = struct {
+	mu sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// authenticateAPIKey looks up a presented key and records its use
+func authenticateAPIKey(secret string) (APIKey, error) {
+	key, err := store.APIKeyByHash(hashAPIKey(secret))
+	if err == ErrNotFound {
+		return key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, err
+	}
+	now := time.Now()
+	if !key.active(now) {
+		return key, ErrInvalidAPIKey
+	}
+
+	lastUsedWrites.mu.Lock()
+	due := now.Sub(lastUsedWrites.at[key.ID]) >= lastUsedInterval
+	if due {
+		lastUsedWrites.at[key.ID] = now
+	}
+	lastUsedWrites.mu.Unlock()
+	if due {
+		if err := store.TouchAPIKey(key.ID, now.UTC()); err != nil {
+			fmt.Printf("WARNING: %v\n", err)
+		}
+	}
+	return key, nil
+}
+
+// presentedAPIKey returns the key sent as "Authorization: Bearer <key>" or X-API-Key
+func presentedAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// optionalAPIKey authenticates a key when one is presented, so public routes can
+// apply per-key rate limits. Requests without a key pass through anonymously. Each
+// invalid key costs the client IP a token from failures, and IPs that run out are
+// refused before the key is looked up, so keys can't be guessed at the database's pace.
+func optionalAPIKey(failures *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := presentedAPIKey(c)
+		if secret == "" {
+			c.Next()
+			return
+		}
+		identity := "ip:" + c.ClientIP()
+		if ok, wait := failures.peek(identity, time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid API keys, try again later"})
+			return
+		}
+		key, err := authenticateAPIKey(secret)
+		if err == ErrInvalidAPIKey {
+			failures.take(identity, time.Now())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+			return
+		}
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// requestAPIKey returns the key authenticated for this request, if any
+func requestAPIKey(c *gin.Context) (APIKey, bool) {
+	v, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return APIKey{}, false
+	}
+	key, ok := v.(APIKey)
+	return key, ok
+}
+
+// requireScope rejects requests whose API key lacks scope. legacyAccounts, when
+// non-empty, still accepts the old AUTH_USER/AUTH_PASS BasicAuth for the ingest
+// scope so scrapers can be moved to keys without a coordinated deploy.
+func requireScope(scope string, legacyAccounts gin.Accounts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := requestAPIKey(c); ok {
+			if !key.hasScope(scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key lacks the %s scope", scope)})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if scope == ScopeIngest && len(legacyAccounts) > 0 {
+			if user, pass, ok := c.Request.BasicAuth(); ok {
+				if expected, found := legacyAccounts[user]; found && subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) == 1 {
+					c.Set(gin.AuthUserKey, user)
+					c.Next()
+					return
+				}
+			}
+		}
+
+		c.Header("WWW-Authenticate", `Bearer realm="api"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid API key is required"})
+	}
+}
+
+// listAPIKeys returns every key without secrets
+func listAPIKeys(c *gin.Context) {
+	keys, err := store.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+	infos := make([]APIKeyInfo, 0, len(keys))
+	for _, k := range keys {
+		infos = append(infos, newAPIKeyInfo(k))
+	}
+	c.JSON(http.StatusOK, APIKeysResponse{Keys: infos})
+}
+
+// postAPIKey creates a key and returns its secret once
+func postAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		t, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be an RFC 3339 timestamp"})
+			return
+		}
+		expiresAt = t.UTC()
+	}
+
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	if err := validateAPIKey(req.Name, req.Scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key", "details": err.Error()})
+		return
+	}
+
+	secret, key, err := createAPIKey(req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key", "details": err.Error()})
+		return
+	}
+	recordAudit(c, "key.create", key.ID, apiKeyAuditDetails(key))
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{Key: secret, APIKey: newAPIKeyInfo(key)})
+}
+
+// deleteAPIKey revokes a key
+func deleteAPIKey(c *gin.Context) {
+	err := store.RevokeAPIKey(c.Param("id"), time.Now().UTC())
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	recordAudit(c, "key.revoke", c.Param("id"), map[string]any{})
+	c.Status(http.StatusNoContent)
+}
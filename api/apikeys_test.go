@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestWithKey uploads an empty scrape using an API key
+func ingestWithKey(t *testing.T, router *gin.Engine, key string) int {
+	t.Helper()
+	output := scrapeOutput("2025-01-01T03:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}})
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "output.zip")
+	fw.Write(buildScrapeZip(t, output, nil))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-API-Key", key)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		ingestKey := newTestKey(t, ScopeIngest)
+		readKey := newTestKey(t, ScopeReadHighRate)
+		adminKey := newTestKey(t, ScopeAdmin)
+
+		if code := ingestWithKey(t, router, ingestKey); code != http.StatusOK {
+			t.Errorf("ingest key: expected 200, got %d", code)
+		}
+		if code := ingestWithKey(t, router, readKey); code != http.StatusForbidden {
+			t.Errorf("read key: expected 403, got %d", code)
+		}
+		if code := ingestWithKey(t, router, adminKey); code != http.StatusOK {
+			t.Errorf("admin key should imply ingest, got %d", code)
+		}
+		if code := ingestWithKey(t, router, "upt_bogus"); code != http.StatusUnauthorized {
+			t.Errorf("unknown key: expected 401, got %d", code)
+		}
+		if rec := do(t, router, http.MethodGet, "/api/v1/admin/keys", ingestKey, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("ingest key must not manage keys, got %d", rec.Code)
+		}
+
+		rec := do(t, router, http.MethodGet, "/api/v1/products", readKey, nil)
+		if rec.Header().Get("RateLimit-Limit") != "1200" {
+			t.Errorf("expected read-high-rate key to get 10x the public limit, got %s", rec.Header().Get("RateLimit-Limit"))
+		}
+	})
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+
+		rec := do(t, router, http.MethodPost, "/api/v1/admin/keys", adminKey, CreateAPIKeyRequest{Name: "scraper", Scopes: []string{"ingest"}})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create key: %d %s", rec.Code, rec.Body.String())
+		}
+		var created CreateAPIKeyResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		if code := ingestWithKey(t, router, created.Key); code != http.StatusOK {
+			t.Fatalf("new key should ingest, got %d", code)
+		}
+
+		var keys APIKeysResponse
+		rec = do(t, router, http.MethodGet, "/api/v1/admin/keys", adminKey, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys.Keys {
+			if k.ID == created.APIKey.ID && k.LastUsedAt == nil {
+				t.Errorf("expected last_used_at to be recorded")
+			}
+		}
+
+		if rec := do(t, router, http.MethodDelete, "/api/v1/admin/keys/"+created.APIKey.ID, adminKey, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("revoke: %d", rec.Code)
+		}
+		if code := ingestWithKey(t, router, created.Key); code != http.StatusUnauthorized {
+			t.Errorf("revoked key: expected 401, got %d", code)
+		}
+		if rec := do(t, router, http.MethodDelete, "/api/v1/admin/keys/nope", adminKey, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unknown key: expected 404, got %d", rec.Code)
+		}
+
+		rec = do(t, router, http.MethodPost, "/api/v1/admin/keys", adminKey, CreateAPIKeyRequest{Name: "bad", Scopes: []string{"root"}})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("unknown scope: expected 400, got %d", rec.Code)
+		}
+		past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		rec = do(t, router, http.MethodPost, "/api/v1/admin/keys", adminKey, CreateAPIKeyRequest{Name: "dead", Scopes: []string{"ingest"}, ExpiresAt: &past})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("past expiry: expected 400, got %d", rec.Code)
+		}
+
+		var audit AuditLogResponse
+		rec = do(t, router, http.MethodGet, "/api/v1/admin/audit", adminKey, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &audit); err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, e := range audit.Entries {
+			if e.Target == created.APIKey.ID {
+				actions = append(actions, e.Action)
+			}
+		}
+		if !slices.Contains(actions, "key.create") || !slices.Contains(actions, "key.revoke") {
+			t.Errorf("expected the key's creation and revocation to be audited, got %v", actions)
+		}
+
+		expired, _, err := createAPIKey("expired", []string{ScopeIngest}, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := ingestWithKey(t, router, expired); code != http.StatusUnauthorized {
+			t.Errorf("expired key: expected 401, got %d", code)
+		}
+	})
+}
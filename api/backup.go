@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// backupTimeFormat names backup archives so they sort chronologically
+const backupTimeFormat = "20060102T150405Z"
+
+// formatScrapedPrice renders a price the way the scraper reports it in prices.json
+func formatScrapedPrice(price float64) string {
+	return "CA $ " + formatPrice(price)
+}
+
+// writeBackup writes every recorded scrape to dir as a scraper-format archive
+// (prices.json plus images), one per scrape. Each product's image is stored once,
+// in the first archive the product appears in. It returns the archive paths written.
+func writeBackup(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	times, err := store.ScrapeTimes()
+	if err != nil {
+		return nil, err
+	}
+	allRuns, err := store.ScraperRuns()
+	if err != nil {
+		return nil, err
+	}
+	// A scrape's products and its scraper run are stamped with the same datetime
+	runs := make(map[int64]ScraperRun)
+	for _, run := range allRuns {
+		runs[run.Datetime.UnixMicro()] = run
+	}
+
+	seen := make(map[string]bool)
+	names := make(map[string]int)
+	var paths []string
+	for _, at := range times {
+		records, err := store.ScrapeProducts(at)
+		if err != nil {
+			return paths, err
+		}
+		run, ok := runs[at.UnixMicro()]
+		// Scrapes within the same second get a numbered suffix rather than overwriting each other
+		name := "scrape-" + at.UTC().Format(backupTimeFormat)
+		if names[name]++; names[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, names[name])
+		}
+		path := filepath.Join(dir, name+".zip")
+		if err := writeBackupArchive(path, at, records, run, ok, seen); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// writeBackupArchive writes a single scrape. Metadata comes from the matching
+// scraper run when there is one, otherwise it is rebuilt from the products.
+func writeBackupArchive(path string, at time.Time, records []ProductRecord, run ScraperRun, haveRun bool, seen map[string]bool) error {
+	output := ScraperOutput{
+		Metadata: ScraperMetadata{
+			Datetime:       at.UTC().Format(time.RFC3339Nano),
+			ScraperVersion: "backup",
+			TotalProducts:  len(records),
+		},
+		Products: make(map[string][]Product),
+	}
+	var images []string
+	for _, r := range records {
+		product := Product{
+			ProductID: r.ProductID,
+			Name:      r.Name,
+			Price:     formatScrapedPrice(r.Price),
+			URL:       r.URL,
+		}
+		if !seen[r.ProductID] {
+			seen[r.ProductID] = true
+			product.Image = "images/" + r.ProductID + ".jpg"
+			images = append(images, r.ProductID)
+		}
+		for _, category := range r.Categories {
+			output.Products[category] = append(output.Products[category], product)
+			if !haveRun && !slices.Contains(output.Metadata.Categories, category) {
+				output.Metadata.Categories = append(output.Metadata.Categories, category)
+			}
+		}
+	}
+	if haveRun {
+		output.Metadata.ScraperVersion = run.ScraperVersion
+		output.Metadata.TotalProducts = run.TotalProducts
+		output.Metadata.TotalFailed = run.TotalFailed
+		output.Metadata.CategoriesScraped = run.CategoriesScraped
+		output.Metadata.Categories = run.Categories
+		output.Metadata.CategoryCounts = run.CategoryCounts
+	} else {
+		sort.Strings(output.Metadata.Categories)
+		output.Metadata.CategoriesScraped = len(output.Metadata.Categories)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+
+	w, err := zw.Create("prices.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(output); err != nil {
+		return fmt.Errorf("failed to write prices.json: %w", err)
+	}
+
+	for _, id := range images {
+		image, err := store.GetImage(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create("images/" + id + ".jpg")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(image); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// archiveDatetime reads the scrape datetime from an archive's prices.json
+func archiveDatetime(path string) (time.Time, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid ZIP file: %w", path, err)
+	}
+	defer zr.Close()
+	f, err := zr.Open("prices.json")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: prices.json not found", path)
+	}
+	defer f.Close()
+
+	var output struct {
+		Metadata ScraperMetadata `json:"metadata"`
+	}
+	if err := json.NewDecoder(f).Decode(&output); err != nil {
+		return time.Time{}, fmt.Errorf("%s: failed to parse prices.json: %w", path, err)
+	}
+	datetime, err := time.Parse(time.RFC3339, output.Metadata.Datetime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid metadata datetime %q", path, output.Metadata.Datetime)
+	}
+	return datetime, nil
+}
+
+// restoreBackup replays archives into an empty database in scrape order, stamping
+// each with its own metadata datetime so products, stats, images, scraper runs and
+// categories are rebuilt as they were recorded
+func restoreBackup(paths []string, progress func(path string, result IngestResponse)) error {
+	existing, err := store.ScrapeTimes()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("database already holds %d scrapes, restore into an empty database", len(existing))
+	}
+
+	datetimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		datetime, err := archiveDatetime(path)
+		if err != nil {
+			return err
+		}
+		datetimes[path] = datetime
+	}
+	ordered := slices.Clone(paths)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return datetimes[ordered[i]].Before(datetimes[ordered[j]])
+	})
+
+	for _, path := range ordered {
+		archive, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Backups hold scrapes that were already accepted, so they skip the guard
+		result, err := ingestArchive(archive, false, time.Time{})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if progress != nil {
+			progress(path, result)
+		}
+	}
+	return nil
+}
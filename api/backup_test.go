@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBackupRestoresHistory(t *testing.T) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			store = open(t)
+
+			images := map[string][]byte{"E100.jpg": []byte("jpeg-100"), "E200.jpg": []byte("jpeg-200")}
+			first := scrapeOutput("2025-03-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "14.90"}})
+			second := scrapeOutput("2025-03-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}})
+			for _, output := range []map[string]any{first, second} {
+				if _, err := ingestArchive(buildScrapeZip(t, output, images), true, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wantHistory, err := store.ProductHistory("E100")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			paths, err := writeBackup(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != 2 {
+				t.Fatalf("expected one archive per scrape, got %d", len(paths))
+			}
+			if err := restoreBackup(paths, nil); err == nil {
+				t.Error("expected restore into a non-empty database to fail")
+			}
+
+			// Restore in reverse to check archives are replayed by their own datetime
+			store = open(t)
+			if err := restoreBackup([]string{paths[1], paths[0]}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			history, err := store.ProductHistory("E100")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != len(wantHistory) {
+				t.Fatalf("expected %d datapoints, got %d", len(wantHistory), len(history))
+			}
+			for i := range history {
+				if history[i].Price != wantHistory[i].Price || !history[i].Datetime.Equal(wantHistory[i].Datetime) {
+					t.Errorf("datapoint %d: got %v at %v, want %v at %v", i, history[i].Price, history[i].Datetime, wantHistory[i].Price, wantHistory[i].Datetime)
+				}
+			}
+
+			stats, err := store.GetStats("E100")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := time.Date(2025, time.March, 2, 6, 0, 0, 0, time.UTC)
+			if stats.LowestPrice != 19.90 || stats.HighestPrice != 29.90 || !stats.LowestPriceDatetime.Equal(want) {
+				t.Errorf("unexpected restored stats: %+v", stats)
+			}
+
+			image, err := store.GetImage("E200")
+			if err != nil || !bytes.Equal(image, images["E200.jpg"]) {
+				t.Errorf("image not restored: %q %v", image, err)
+			}
+			runs, err := store.ScraperRuns()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(runs) != 2 || runs[0].ScraperVersion != "test" {
+				t.Errorf("unexpected restored scraper runs: %+v", runs)
+			}
+		})
+	}
+}
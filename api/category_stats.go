@@ -0,0 +1,327 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CategoryStatsPoint summarizes a category in one scrape run. Discounts are measured
+// against each product's regular price and averaged over the products on sale. New
+// counts the run's products first seen in it, which is none in the first run, and
+// Discontinued products that have been missing ever since it.
+type CategoryStatsPoint struct {
+	Datetime        string  `json:"datetime"`
+	Products        int     `json:"products"`
+	OnSale          int     `json:"on_sale"`
+	OnSalePercent   float64 `json:"on_sale_percent"`
+	AverageDiscount float64 `json:"average_discount_percent"`
+	MedianDiscount  float64 `json:"median_discount_percent"`
+	New             int     `json:"new"`
+	Discontinued    int     `json:"discontinued"`
+	PriceIndex      float64 `json:"price_index"`
+}
+
+// CategoryStatsCurrent summarizes a category as it is listed now. New, Missing and
+// Discontinued count the category's products in each lifecycle status.
+type CategoryStatsCurrent struct {
+	Datetime        *string `json:"datetime"`
+	Stale           bool    `json:"stale"`
+	Products        int     `json:"products"`
+	OnSale          int     `json:"on_sale"`
+	OnSalePercent   float64 `json:"on_sale_percent"`
+	AverageDiscount float64 `json:"average_discount_percent"`
+	MedianDiscount  float64 `json:"median_discount_percent"`
+	PriceIndex      float64 `json:"price_index"`
+	New             int     `json:"new"`
+	Missing         int     `json:"missing"`
+	Discontinued    int     `json:"discontinued"`
+}
+
+// CategoryStatsResponse is the body returned by the category stats endpoint. Series
+// has a point for every scrape run that covered the category within Range, oldest
+// first.
+type CategoryStatsResponse struct {
+	Category string               `json:"category"`
+	Range    HistoryRange         `json:"range"`
+	Current  CategoryStatsCurrent `json:"current"`
+	Series   []CategoryStatsPoint `json:"series"`
+}
+
+// categoryStatsCache holds each category's full stats until the next ingest or
+// correction
+var categoryStatsCache = struct {
+	mu      sync.RWMutex
+	entries map[string]categoryStatsEntry
+}{entries: make(map[string]categoryStatsEntry)}
+
+type categoryStatsEntry struct {
+	data CategoryStatsResponse
+	// runs holds the time of each point of data.Series, to narrow it to a range
+	runs      []time.Time
+	expiresAt time.Time
+}
+
+// discountSummary counts the products on sale and their average and median discount
+// in percent
+type discountSummary struct {
+	onSale        int
+	onSalePercent float64
+	average       float64
+	median        float64
+}
+
+// summarizeDiscounts summarizes the discounts of products at the given prices against
+// their regular prices
+func summarizeDiscounts(prices, regular []float64) discountSummary {
+	var discounts []float64
+	var total float64
+	for i, price := range prices {
+		if regular[i] > 0 && price < regular[i] {
+			d := (regular[i] - price) / regular[i] * 100
+			discounts = append(discounts, d)
+			total += d
+		}
+	}
+	var s discountSummary
+	s.onSale = len(discounts)
+	if len(prices) > 0 {
+		s.onSalePercent = roundTo(float64(len(discounts))/float64(len(prices))*100, 2)
+	}
+	if len(discounts) > 0 {
+		s.average = roundTo(total/float64(len(discounts)), 2)
+		s.median = roundTo(medianOf(discounts), 2)
+	}
+	return s
+}
+
+// chainPriceIndex moves a price index from one run to the next by the geometric mean
+// of the price ratios of the products in both, so products coming and going don't
+// move it. The first run is 100.
+func chainPriceIndex(index float64, previous, current map[string]float64) float64 {
+	var logs float64
+	var n int
+	for id, price := range current {
+		if before, ok := previous[id]; ok && before > 0 && price > 0 {
+			logs += math.Log(price / before)
+			n++
+		}
+	}
+	if n == 0 {
+		return index
+	}
+	return index * math.Exp(logs/float64(n))
+}
+
+// categorySeries builds the per-run stats of a category from its history for every
+// run that scraped it, with the time of each run, and the price index of every run.
+// Runs are keyed by UnixMicro, as in coveredRuns.
+func categorySeries(category string, lifecycles []ProductLifecycle) ([]CategoryStatsPoint, []time.Time, map[int64]float64, error) {
+	type runPrices struct {
+		prices  map[string]float64
+		regular map[string]float64
+	}
+	byRun := make(map[int64]*runPrices)
+	err := store.ExportHistory(ExportFilter{Category: category}, func(row ExportRow) error {
+		run := byRun[row.Datetime.UnixMicro()]
+		if run == nil {
+			run = &runPrices{prices: make(map[string]float64), regular: make(map[string]float64)}
+			byRun[row.Datetime.UnixMicro()] = run
+		}
+		run.prices[row.ProductID] = row.Price
+		run.regular[row.ProductID] = row.RegularPrice
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	runs, err := coveredRuns()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	runs = slices.DeleteFunc(runs, func(r coveredRun) bool {
+		_, scraped := byRun[r.Datetime.UnixMicro()]
+		return !scraped && !r.covers([]string{category})
+	})
+
+	// A product is new in the run it was first seen in, if it was in this category
+	// then, and discontinued in the first run covering the category after it was last
+	// seen. As in lifecycleStatus, products from the very first run are never new.
+	firstSeen := make(map[string]int64, len(lifecycles))
+	discontinuedAt := make(map[int64]int)
+	for _, l := range lifecycles {
+		firstSeen[l.ProductID] = l.FirstSeen.UnixMicro()
+		if l.Status != LifecycleDiscontinued {
+			continue
+		}
+		for _, r := range runs {
+			if r.Datetime.After(l.LastSeen) {
+				discontinuedAt[r.Datetime.UnixMicro()]++
+				break
+			}
+		}
+	}
+
+	series := []CategoryStatsPoint{}
+	times := []time.Time{}
+	indexes := make(map[int64]float64, len(runs))
+	index := 100.0
+	var previous map[string]float64
+	for i, r := range runs {
+		key := r.Datetime.UnixMicro()
+		run := byRun[key]
+		if run == nil {
+			run = &runPrices{}
+		}
+		if i > 0 {
+			index = chainPriceIndex(index, previous, run.prices)
+		}
+		previous = run.prices
+		indexes[key] = index
+
+		added := 0
+		if i > 0 {
+			for id := range run.prices {
+				if firstSeen[id] == key {
+					added++
+				}
+			}
+		}
+		prices := make([]float64, 0, len(run.prices))
+		regular := make([]float64, 0, len(run.prices))
+		for id, price := range run.prices {
+			prices = append(prices, price)
+			regular = append(regular, run.regular[id])
+		}
+		discounts := summarizeDiscounts(prices, regular)
+		series = append(series, CategoryStatsPoint{
+			Datetime:        formatTimestamp(r.Datetime),
+			Products:        len(run.prices),
+			OnSale:          discounts.onSale,
+			OnSalePercent:   discounts.onSalePercent,
+			AverageDiscount: discounts.average,
+			MedianDiscount:  discounts.median,
+			New:             added,
+			Discontinued:    discontinuedAt[key],
+			PriceIndex:      roundTo(index, 2),
+		})
+		times = append(times, r.Datetime)
+	}
+	return series, times, indexes, nil
+}
+
+// categoryStats computes a category's stats over the whole history, returning the
+// time of each point of the series alongside
+func categoryStats(category string) (CategoryStatsResponse, []time.Time, error) {
+	response := CategoryStatsResponse{Category: category}
+	lifecycles, err := visibleLifecycles("", category)
+	if err != nil {
+		return response, nil, err
+	}
+	series, times, indexes, err := categorySeries(category, lifecycles)
+	if err != nil {
+		return response, nil, err
+	}
+	response.Series = series
+
+	snapshot, err := latestSnapshot(category)
+	if err != nil {
+		return response, nil, err
+	}
+	prices := make([]float64, 0, len(snapshot.Products))
+	regular := make([]float64, 0, len(snapshot.Products))
+	for _, p := range snapshot.Products {
+		prices = append(prices, p.Price)
+		regular = append(regular, p.RegularPrice)
+	}
+	discounts := summarizeDiscounts(prices, regular)
+	current := CategoryStatsCurrent{
+		Products:        len(snapshot.Products),
+		OnSale:          discounts.onSale,
+		OnSalePercent:   discounts.onSalePercent,
+		AverageDiscount: discounts.average,
+		MedianDiscount:  discounts.median,
+		PriceIndex:      100,
+	}
+	if len(snapshot.Categories) > 0 {
+		at := snapshot.Categories[0].Datetime
+		current.Datetime = formatDatetime(at)
+		current.Stale = snapshot.Categories[0].Stale
+		if index, ok := indexes[at.UnixMicro()]; ok {
+			current.PriceIndex = roundTo(index, 2)
+		}
+	}
+	for _, l := range lifecycles {
+		switch l.Status {
+		case LifecycleNew:
+			current.New++
+		case LifecycleMissing:
+			current.Missing++
+		case LifecycleDiscontinued:
+			current.Discontinued++
+		}
+	}
+	response.Current = current
+	return response, times, nil
+}
+
+// getCategoryStats returns a category's product count, sales, discounts, new and
+// discontinued products and price index for every scrape run, with its current totals.
+// The optional from and to query parameters narrow the series.
+func getCategoryStats(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	category, ok := strings.CutSuffix(path, "/stats")
+	if !ok || category == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	query, err := parseHistoryQuery(c.Query("from"), c.Query("to"), "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range", "details": err.Error()})
+		return
+	}
+	categories, err := store.Categories()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get categories"})
+		return
+	}
+	if !slices.Contains(categories, category) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	// Only the full series is cached, so the cache is bounded by the number of
+	// categories, and ranges are cut from it
+	categoryStatsCache.mu.RLock()
+	entry, ok := categoryStatsCache.entries[category]
+	categoryStatsCache.mu.RUnlock()
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		response, runs, err := categoryStats(category)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute category stats"})
+			return
+		}
+		entry = categoryStatsEntry{data: response, runs: runs, expiresAt: time.Now().Add(cacheDuration)}
+		categoryStatsCache.mu.Lock()
+		categoryStatsCache.entries[category] = entry
+		categoryStatsCache.mu.Unlock()
+	}
+
+	response := entry.data
+	if !query.isZero() {
+		response.Range = query.response()
+		response.Series = []CategoryStatsPoint{}
+		for i, at := range entry.runs {
+			if query.contains(at) {
+				response.Series = append(response.Series, entry.data.Series[i])
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCategoryStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// A goes half price for a run, C is new in the second run and B disappears after
+		// it. D is new in women/tops in the second run and joins men/tops in the third.
+		runs := []struct {
+			datetime string
+			products map[string]map[string]string
+		}{
+			{"2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "29.90", "B200": "19.90"}}},
+			{"2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "14.95", "B200": "19.90", "C300": "9.90"}, "women/tops": {"D400": "39.90"}}},
+			{"2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "29.90", "C300": "9.90", "D400": "39.90"}}},
+			{"2025-01-04T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "29.90", "C300": "9.90", "D400": "39.90"}}},
+			{"2025-01-05T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "29.90", "C300": "9.90", "D400": "39.90"}}},
+		}
+		for _, r := range runs {
+			output := scrapeOutput(r.datetime, r.products)
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var stats CategoryStatsResponse
+		if rec := get(t, router, "/api/v1/categories/men/tops/stats", &stats); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if stats.Category != "men/tops" || len(stats.Series) != 5 {
+			t.Fatalf("expected 5 runs of men/tops, got %+v", stats)
+		}
+		// Products of the first run aren't new, and D was new in a run it wasn't in
+		// men/tops in
+		want := []CategoryStatsPoint{
+			{Datetime: "2025-01-01T06:00:00Z", Products: 2, PriceIndex: 100},
+			{Datetime: "2025-01-02T06:00:00Z", Products: 3, OnSale: 1, OnSalePercent: 33.33, AverageDiscount: 50, MedianDiscount: 50, New: 1, PriceIndex: 70.71},
+			{Datetime: "2025-01-03T06:00:00Z", Products: 3, Discontinued: 1, PriceIndex: 100},
+		}
+		for i, w := range want {
+			if stats.Series[i] != w {
+				t.Errorf("run %d: expected %+v, got %+v", i, w, stats.Series[i])
+			}
+		}
+
+		current := stats.Current
+		if current.Datetime == nil || *current.Datetime != "2025-01-05T06:00:00Z" || current.Stale {
+			t.Errorf("expected the current totals from the newest run, got %+v", current)
+		}
+		if current.Products != 3 || current.OnSale != 0 || current.PriceIndex != 100 || current.Discontinued != 1 {
+			t.Errorf("unexpected current totals %+v", current)
+		}
+
+		// The range narrows the cached series but the index still starts at the first run
+		var ranged CategoryStatsResponse
+		get(t, router, "/api/v1/categories/men/tops/stats?from=2025-01-02&to=2025-01-03", &ranged)
+		if len(ranged.Series) != 2 || ranged.Series[0].PriceIndex != 70.71 || ranged.Series[1] != want[2] {
+			t.Errorf("expected 2 runs starting at index 70.71, got %+v", ranged.Series)
+		}
+		if ranged.Range.From == nil || ranged.Current.Products != current.Products {
+			t.Errorf("expected the range and the current totals, got %+v", ranged)
+		}
+		var full CategoryStatsResponse
+		get(t, router, "/api/v1/categories/men/tops/stats", &full)
+		if len(full.Series) != 5 || full.Range.From != nil {
+			t.Errorf("expected the full series after a ranged query, got %+v", full)
+		}
+
+		if rec := get(t, router, "/api/v1/categories/women/stats", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown category, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/categories/men/tops", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 without /stats, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/categories/men/tops/stats?from=soon", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an invalid range, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultChangesLimit and maxChangesLimit bound how many price changes are returned at once
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// PriceChangeInfo is a single price change event
+type PriceChangeInfo struct {
+	ID            int64    `json:"id"`
+	ProductID     string   `json:"product_id"`
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	Categories    []string `json:"categories"`
+	Type          string   `json:"type"`
+	OldPrice      *float64 `json:"old_price"`
+	NewPrice      *float64 `json:"new_price"`
+	PercentChange *float64 `json:"percent_change"`
+	Direction     string   `json:"direction,omitempty"`
+	AllTimeLow    bool     `json:"all_time_low"`
+	Datetime      string   `json:"datetime"`
+}
+
+// PriceChangesResponse is the body returned by the changes endpoint. NextOffset is
+// set when more events match.
+type PriceChangesResponse struct {
+	Count      int               `json:"count"`
+	Changes    []PriceChangeInfo `json:"changes"`
+	NextOffset *int              `json:"next_offset"`
+}
+
+// samePrice compares prices to the cent they are stored with
+func samePrice(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
+
+// percentChange returns the change from old to new as a percentage rounded to two
+// decimals, or nil when there is no old price to compare against
+func percentChange(old, new float64) *float64 {
+	if old == 0 {
+		return nil
+	}
+	p := math.Round((new-old)/old*10000) / 100
+	return &p
+}
+
+// diffRuns returns the events of a scrape run against the products known before it,
+// both ordered by product ID: a price event for every product whose price moved, a new
+// event for products not known before, and a removed event for known products missing
+// from a category the run covered. Products in categories the run skipped aren't
+// reported as removed.
+func diffRuns(previous, current []ProductRecord, run coveredRun) []PriceChange {
+	before := make(map[string]ProductRecord, len(previous))
+	for _, r := range previous {
+		before[r.ProductID] = r
+	}
+
+	var changes []PriceChange
+	seen := make(map[string]bool, len(current))
+	for _, r := range current {
+		seen[r.ProductID] = true
+		newPrice := r.Price
+		old, ok := before[r.ProductID]
+		if !ok {
+			changes = append(changes, PriceChange{ProductID: r.ProductID, Name: r.Name, URL: r.URL, Categories: r.Categories, Type: ChangeTypeNew, NewPrice: &newPrice})
+			continue
+		}
+		if samePrice(old.Price, r.Price) {
+			continue
+		}
+		oldPrice := old.Price
+		direction := "down"
+		if r.Price > old.Price {
+			direction = "up"
+		}
+		changes = append(changes, PriceChange{
+			ProductID:     r.ProductID,
+			Name:          r.Name,
+			URL:           r.URL,
+			Categories:    r.Categories,
+			Type:          ChangeTypePrice,
+			OldPrice:      &oldPrice,
+			NewPrice:      &newPrice,
+			PercentChange: percentChange(old.Price, r.Price),
+			Direction:     direction,
+		})
+	}
+	for _, r := range previous {
+		if seen[r.ProductID] || !run.covers(r.Categories) {
+			continue
+		}
+		oldPrice := r.Price
+		changes = append(changes, PriceChange{ProductID: r.ProductID, Name: r.Name, URL: r.URL, Categories: r.Categories, Type: ChangeTypeRemoved, OldPrice: &oldPrice})
+	}
+	return changes
+}
+
+// knownProducts returns the products known before runs[i] in the categories it
+// covered: every product of the run before it, plus the products of categories that
+// run skipped, taken from the newest earlier run that covered them, up to
+// staleCategoryLimit back. load returns the products of a run by its index.
+func knownProducts(runs []coveredRun, i int, current []ProductRecord, load func(int) ([]ProductRecord, error)) ([]ProductRecord, error) {
+	pending := make(map[string]bool)
+	if runs[i].Categories != nil {
+		for _, c := range runs[i].Categories {
+			pending[c] = true
+		}
+	} else {
+		for _, r := range current {
+			for _, c := range r.Categories {
+				pending[c] = true
+			}
+		}
+	}
+
+	var known []ProductRecord
+	seen := make(map[string]bool)
+	for j := i - 1; j >= 0 && len(pending) > 0; j-- {
+		if j < i-1 && runs[i].Datetime.Sub(runs[j].Datetime) > staleCategoryLimit {
+			break
+		}
+		if j < i-1 && !runs[j].covers(slices.Collect(maps.Keys(pending))) {
+			continue
+		}
+		records, err := load(j)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if seen[r.ProductID] || (j < i-1 && !slices.ContainsFunc(r.Categories, func(c string) bool { return pending[c] })) {
+				continue
+			}
+			seen[r.ProductID] = true
+			known = append(known, r)
+		}
+		if runs[j].Categories == nil {
+			break
+		}
+		for _, c := range runs[j].Categories {
+			delete(pending, c)
+		}
+	}
+	slices.SortFunc(known, func(a, b ProductRecord) int { return strings.Compare(a.ProductID, b.ProductID) })
+	return known, nil
+}
+
+// markAllTimeLows flags the price drops seen at datetime that went below every price
+// the product was recorded at before it
+func markAllTimeLows(changes []PriceChange, datetime time.Time) error {
+	if !slices.ContainsFunc(changes, func(c PriceChange) bool { return c.Type == ChangeTypePrice && c.Direction == "down" }) {
+		return nil
+	}
+	lowest, err := store.LowestPricesBefore(datetime)
+	if err != nil {
+		return err
+	}
+	for i, c := range changes {
+		if c.Type != ChangeTypePrice || c.Direction != "down" {
+			continue
+		}
+		before, ok := lowest[c.ProductID]
+		changes[i].AllTimeLow = !ok || *c.NewPrice < before
+	}
+	return nil
+}
+
+// rebuildPriceChanges recomputes the events of every scrape run in [from, to) and of
+// the runs after it whose known products may have changed: the next run, and any run
+// beyond it reaching back past a run that skipped some of its categories. The first
+// run ever recorded has nothing to compare against and gets no events.
+func rebuildPriceChanges(from, to time.Time) error {
+	runs, err := coveredRuns()
+	if err != nil {
+		return err
+	}
+
+	// Keep the products of the last few runs loaded, since consecutive runs share them
+	loaded := make(map[int][]ProductRecord)
+	load := func(i int) ([]ProductRecord, error) {
+		if records, ok := loaded[i]; ok {
+			return records, nil
+		}
+		records, err := store.ScrapeProducts(runs[i].Datetime)
+		if err != nil {
+			return nil, err
+		}
+		loaded[i] = records
+		return records, nil
+	}
+
+	for i, run := range runs {
+		if run.Datetime.Before(from) {
+			continue
+		}
+		current, err := load(i)
+		if err != nil {
+			return err
+		}
+		var changes []PriceChange
+		if i > 0 {
+			previous, err := knownProducts(runs, i, current, load)
+			if err != nil {
+				return err
+			}
+			changes = diffRuns(previous, current, run)
+			if err := markAllTimeLows(changes, run.Datetime); err != nil {
+				return err
+			}
+		}
+		if err := store.ReplacePriceChanges(run.Datetime, changes); err != nil {
+			return err
+		}
+		if !run.Datetime.Before(to) && (i+1 == len(runs) || run.coversAll(runs[i+1])) {
+			break
+		}
+		for j := range loaded {
+			if j < i-1 {
+				delete(loaded, j)
+			}
+		}
+	}
+	return nil
+}
+
+// runWindow returns the [from, to) range matching the scrape run at datetime. Postgres
+// keeps microseconds, so the run is matched at that precision.
+func runWindow(datetime time.Time) (time.Time, time.Time) {
+	from := datetime.Truncate(time.Microsecond)
+	return from, from.Add(time.Microsecond)
+}
+
+// rebuildRunChanges recomputes the events of the scrape run at datetime and the run after it
+func rebuildRunChanges(datetime time.Time) error {
+	return rebuildPriceChanges(runWindow(datetime))
+}
+
+// newPriceChangeInfo converts a stored event to its response form
+func newPriceChangeInfo(ch PriceChange) PriceChangeInfo {
+	return PriceChangeInfo{
+		ID:            ch.ID,
+		ProductID:     ch.ProductID,
+		Name:          ch.Name,
+		URL:           ch.URL,
+		Categories:    ch.Categories,
+		Type:          ch.Type,
+		OldPrice:      ch.OldPrice,
+		NewPrice:      ch.NewPrice,
+		PercentChange: ch.PercentChange,
+		Direction:     ch.Direction,
+		AllTimeLow:    ch.AllTimeLow,
+		Datetime:      formatTimestamp(ch.Datetime),
+	}
+}
+
+// parseSince accepts a YYYY-MM-DD date, meaning the start of that day in UTC, or an
+// RFC 3339 timestamp
+func parseSince(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// getChanges returns price change events, newest scrape run first, filtered by the
+// optional since, type and category query parameters and paged with limit and offset
+func getChanges(c *gin.Context) {
+	filter := PriceChangeFilter{Category: c.Query("category"), Limit: defaultChangesLimit}
+	if v := c.Query("since"); v != "" {
+		since, err := parseSince(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a YYYY-MM-DD date or an RFC 3339 timestamp"})
+			return
+		}
+		filter.Since = since
+	}
+	switch t := c.Query("type"); t {
+	case "", ChangeTypePrice, ChangeTypeNew, ChangeTypeRemoved:
+		filter.Type = t
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of price, new, removed"})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		filter.Limit = min(n, maxChangesLimit)
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		filter.Offset = n
+	}
+
+	// Ask for one extra event to learn whether there is another page
+	limit := filter.Limit
+	filter.Limit++
+	changes, err := store.PriceChanges(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query price changes"})
+		return
+	}
+
+	response := PriceChangesResponse{Changes: make([]PriceChangeInfo, 0, min(len(changes), limit))}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		next := filter.Offset + limit
+		response.NextOffset = &next
+	}
+	for _, ch := range changes {
+		response.Changes = append(response.Changes, newPriceChangeInfo(ch))
+	}
+	response.Count = len(response.Changes)
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPriceChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		runs := []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "49.90"}}),
+			scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90", "E200": "49.90"}, "women/tops": {"E300": "9.90"}}),
+			scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}, "women/tops": {"E300": "12.90"}}),
+		}
+		for _, output := range runs {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var all PriceChangesResponse
+		get(t, router, "/api/v1/changes", &all)
+		if all.Count != 4 || all.NextOffset != nil {
+			t.Fatalf("expected 4 events on one page, got %+v", all)
+		}
+		// Newest run first: E300 went up and E200 disappeared on the 3rd
+		if all.Changes[0].ProductID != "E300" || all.Changes[0].Direction != "up" || all.Changes[1].Type != ChangeTypeRemoved {
+			t.Errorf("unexpected events for the latest run: %+v", all.Changes[:2])
+		}
+
+		var drops PriceChangesResponse
+		get(t, router, "/api/v1/changes?type=price&category=men/tops", &drops)
+		if drops.Count != 1 {
+			t.Fatalf("expected one price event in men/tops, got %+v", drops.Changes)
+		}
+		drop := drops.Changes[0]
+		if *drop.OldPrice != 29.90 || *drop.NewPrice != 19.90 || *drop.PercentChange != -33.44 || drop.Direction != "down" {
+			t.Errorf("unexpected price drop: %+v", drop)
+		}
+		if drop.Datetime != "2025-01-02T06:00:00Z" {
+			t.Errorf("expected the drop to be dated from its run, got %q", drop.Datetime)
+		}
+
+		var page PriceChangesResponse
+		get(t, router, "/api/v1/changes?since=2025-01-02&limit=3", &page)
+		if page.Count != 3 || page.NextOffset == nil || *page.NextOffset != 3 {
+			t.Fatalf("expected a full first page with a next offset, got %+v", page)
+		}
+		get(t, router, "/api/v1/changes?since=2025-01-02&limit=3&offset=3", &page)
+		if page.Count != 1 || page.NextOffset != nil || page.Changes[0].Type != ChangeTypeNew {
+			t.Errorf("unexpected last page: %+v", page)
+		}
+		get(t, router, "/api/v1/changes?since=2025-01-03T00:00:00Z", &page)
+		if page.Count != 2 {
+			t.Errorf("expected 2 events since the 3rd, got %d", page.Count)
+		}
+
+		// Backfilling a run between the 1st and 2nd moves the drop to the backfilled run
+		backfill := scrapeOutput("2025-01-01T18:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90", "E200": "49.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, backfill, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("backfill failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/changes?type=price&category=men/tops", &drops)
+		if drops.Count != 1 || drops.Changes[0].Datetime != "2025-01-01T18:00:00Z" {
+			t.Errorf("expected the drop to move to the backfilled run, got %+v", drops.Changes)
+		}
+
+		// Only a drop below every earlier price is an all-time low; E300 returns to 9.90
+		if !drops.Changes[0].AllTimeLow {
+			t.Errorf("expected E100's first drop to be an all-time low: %+v", drops.Changes[0])
+		}
+		back := scrapeOutput("2025-01-04T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}, "women/tops": {"E300": "9.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, back, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/changes?since=2025-01-04&type=price", &page)
+		if page.Count != 1 || page.Changes[0].ProductID != "E300" || page.Changes[0].AllTimeLow {
+			t.Errorf("expected E300's return to an earlier price not to be an all-time low, got %+v", page.Changes)
+		}
+
+		for _, query := range []string{"type=sale", "since=yesterday", "limit=0", "offset=-1"} {
+			if rec := get(t, router, "/api/v1/changes?"+query, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", query, rec.Code)
+			}
+		}
+	})
+}
+
+func TestPriceChangesSkipUncoveredCategories(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// The 2nd run only covered men/tops, so innerwear comes back on the 3rd
+		for _, output := range []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}, "women/innerwear": {"E500": "9.90", "E600": "14.90"}}),
+			scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}}),
+			scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}, "women/innerwear": {"E500": "7.90"}}),
+		} {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var changes PriceChangesResponse
+		get(t, router, "/api/v1/changes", &changes)
+		if changes.Count != 3 {
+			t.Fatalf("expected 3 events, got %+v", changes.Changes)
+		}
+		// E500 is compared to the 1st run, and only E600 is gone
+		latest, drop := changes.Changes[:2], changes.Changes[2]
+		if latest[0].ProductID != "E500" || latest[0].Type != ChangeTypePrice || *latest[0].OldPrice != 9.90 {
+			t.Errorf("expected E500's drop against the last run covering it, got %+v", latest[0])
+		}
+		if latest[1].ProductID != "E600" || latest[1].Type != ChangeTypeRemoved {
+			t.Errorf("expected E600 to be removed, got %+v", latest[1])
+		}
+		if drop.ProductID != "E100" || drop.Datetime != "2025-01-02T06:00:00Z" {
+			t.Errorf("expected only E100's drop from the partial run, got %+v", drop)
+		}
+
+		// Backfilling before the partial run moves E500's drop against the backfilled price
+		backfill := scrapeOutput("2025-01-01T18:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}, "women/innerwear": {"E500": "8.90", "E600": "14.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, backfill, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("backfill failed: %d %s", rec.Code, rec.Body.String())
+		}
+		var drops PriceChangesResponse
+		get(t, router, "/api/v1/changes?category=women/innerwear&type=price", &drops)
+		if drops.Count != 2 || *drops.Changes[0].OldPrice != 8.90 {
+			t.Errorf("expected E500's latest drop to start from the backfilled price, got %+v", drops.Changes)
+		}
+	})
+}
+
+func TestPriceChangesFollowCorrections(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		for _, output := range []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}}),
+			scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "2.99"}}),
+			scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}}),
+		} {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var changes PriceChangesResponse
+		get(t, router, "/api/v1/changes?type=price", &changes)
+		if changes.Count != 2 {
+			t.Fatalf("expected the drop and the rebound, got %+v", changes.Changes)
+		}
+
+		// Deleting the last scrape drops its rebound event
+		rec := do(t, router, http.MethodDelete, "/api/v1/admin/scrapes/2025-01-03", adminKey, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("delete scrape: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/changes", &changes)
+		if changes.Count != 1 || changes.Changes[0].Direction != "down" {
+			t.Fatalf("expected only the drop, got %+v", changes.Changes)
+		}
+
+		// Fixing the typo removes the bogus drop
+		rec = do(t, router, http.MethodPatch, "/api/v1/admin/products/E100/datapoints/2025-01-02", adminKey, map[string]any{"price": 29.90})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/changes", &changes)
+		if changes.Count != 0 {
+			t.Errorf("expected no events after the correction, got %+v", changes.Changes)
+		}
+
+		// Hidden products are left out of the public feed
+		do(t, router, http.MethodPatch, "/api/v1/admin/products/E100/datapoints/2025-01-02", adminKey, map[string]any{"price": 19.90})
+		get(t, router, "/api/v1/changes", &changes)
+		if changes.Count != 1 {
+			t.Fatalf("expected the drop to return, got %+v", changes.Changes)
+		}
+		do(t, router, http.MethodPut, "/api/v1/admin/products/E100/hidden", adminKey, nil)
+		get(t, router, "/api/v1/changes", &changes)
+		if changes.Count != 0 {
+			t.Errorf("expected hidden product events to be left out, got %+v", changes.Changes)
+		}
+	})
+}
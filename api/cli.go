@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"api/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Exit codes for subcommands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usageText = `usage: api <command> [flags]
+
+commands:
+  serve             run the HTTP server (the default when no command is given)
+  ingest FILE.zip   load a scraper archive into the database
+  migrate           apply pending schema migrations
+  recompute-stats   rebuild product stats from the price history
+  rebuild-changes   rebuild price change events from the price history
+  export            write the price history as CSV, NDJSON or Parquet
+  backup DIR        write every scrape to DIR as scraper-format archives
+  restore DIR|ZIP.. rebuild an empty database from backup archives
+  cache-warm        prime a running server's response caches
+  keys              create, list and revoke API keys
+
+Run "api <command> -h" for a command's flags.`
+
+// run dispatches to a subcommand and returns the process exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "ingest":
+		return runIngest(args[1:])
+	case "migrate":
+		return runMigrate(args[1:])
+	case "recompute-stats":
+		return runRecomputeStats(args[1:])
+	case "rebuild-changes":
+		return runRebuildChanges(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "backup":
+		return runBackup(args[1:])
+	case "restore":
+		return runRestore(args[1:])
+	case "cache-warm":
+		return runCacheWarm(args[1:])
+	case "keys":
+		return runKeysCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usageText)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", args[0], usageText)
+		return exitUsage
+	}
+}
+
+// newFlagSet returns a FlagSet for a subcommand that reports errors instead of exiting
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: api %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses a subcommand's flags, returning the exit code to stop with if
+// parsing failed or help was requested
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// openDB initializes the store for a subcommand, reporting failures on stderr
+func openDB() bool {
+	if err := initDB(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// runServe starts the HTTP server
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "serve [-port PORT]")
+	defaultPort := os.Getenv("PORT")
+	if defaultPort == "" {
+		defaultPort = "8080"
+	}
+	port := fs.String("port", defaultPort, "port to listen on")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	// AUTH_USER/AUTH_PASS predate API keys and are only honoured for ingest
+	legacyAccounts := gin.Accounts{}
+	if authUser, authPass := os.Getenv("AUTH_USER"), os.Getenv("AUTH_PASS"); authUser != "" && authPass != "" {
+		fmt.Println("WARNING: AUTH_USER/AUTH_PASS are deprecated, create an ingest API key with `keys create` instead")
+		legacyAccounts[authUser] = authPass
+	}
+	router := newRouter(legacyAccounts)
+
+	if err := router.Run("0.0.0.0:" + *port); err != nil {
+		fmt.Fprintf(os.Stderr, "Server stopped: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runIngest loads a scraper archive through the same pipeline as the ingest endpoint
+func runIngest(args []string) int {
+	fs := newFlagSet("ingest", "ingest [-force] FILE.zip")
+	force := fs.Bool("force", false, "ingest even if the scrape fails the anomaly guard")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	archive, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", fs.Arg(0), err)
+		return exitError
+	}
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	result, err := ingestArchive(archive, !*force, time.Time{})
+	var quarantined *QuarantinedError
+	if errors.As(err, &quarantined) {
+		fmt.Fprintf(os.Stderr, "Ingest held back, %v. Approve it with POST /api/v1/admin/quarantine/%d/approve or rerun with -force\n", err, quarantined.Scrape.ID)
+		return exitError
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ingest failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("Ingested %d products across %d categories\n", result.Count, result.Categories)
+	return exitOK
+}
+
+// runMigrate applies pending migrations, or lists them with -status
+func runMigrate(args []string) int {
+	fs := newFlagSet("migrate", "migrate [-status]")
+	status := fs.Bool("status", false, "list migrations and whether they are applied, without applying any")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		fmt.Fprintln(os.Stderr, "DATABASE_URL environment variable must be set")
+		return exitError
+	}
+	s, err := openStore(databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return exitError
+	}
+	defer s.Close()
+
+	if *status {
+		migrations, err := s.Migrations()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range migrations {
+			applied := "pending"
+			if !m.AppliedAt.IsZero() {
+				applied = cliTime(m.AppliedAt)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		w.Flush()
+		return exitOK
+	}
+
+	applied, err := s.Migrate()
+	for _, m := range applied {
+		fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return exitError
+	}
+	if len(applied) == 0 {
+		fmt.Println("Database is up to date")
+	}
+	return exitOK
+}
+
+// runRecomputeStats rebuilds stats from the price history for one or every product
+func runRecomputeStats(args []string) int {
+	fs := newFlagSet("recompute-stats", "recompute-stats [-product ID]")
+	productID := fs.String("product", "", "only recompute this product")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	productIDs := []string{*productID}
+	if *productID == "" {
+		ids, err := store.ProductIDs()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		productIDs = ids
+	}
+	for i, id := range productIDs {
+		if err := recomputeStats(id); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to recompute stats for %s: %v\n", id, err)
+			return exitError
+		}
+		if (i+1)%500 == 0 {
+			fmt.Printf("Recomputed %d/%d products\n", i+1, len(productIDs))
+		}
+	}
+
+	target := *productID
+	if target == "" {
+		target = "*"
+	}
+	writeAudit("cli", "stats.recompute", target, map[string]any{"products": len(productIDs)})
+	fmt.Printf("Recomputed stats for %d products\n", len(productIDs))
+	return exitOK
+}
+
+// runRebuildChanges recomputes the price change events of every scrape run, filling
+// them in for history ingested before events were recorded
+func runRebuildChanges(args []string) int {
+	fs := newFlagSet("rebuild-changes", "rebuild-changes")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	times, err := store.ScrapeTimes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if len(times) > 0 {
+		if err := rebuildPriceChanges(times[0], times[len(times)-1].Add(time.Microsecond)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rebuild price changes: %v\n", err)
+			return exitError
+		}
+	}
+	fmt.Printf("Rebuilt price changes for %d scrape runs\n", len(times))
+	return exitOK
+}
+
+// runExport writes the price history joined with stats as CSV, NDJSON or Parquet
+func runExport(args []string) int {
+	fs := newFlagSet("export", "export [-format csv|ndjson|parquet] [-o FILE] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-category CATEGORY]")
+	format := fs.String("format", "", "csv, ndjson or parquet (default taken from the -o extension, else csv)")
+	output := fs.String("o", "-", "file to write, - for stdout")
+	from := fs.String("from", "", "first day to include")
+	to := fs.String("to", "", "last day to include")
+	category := fs.String("category", "", "only export products in this category")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.TrimPrefix(filepath.Ext(*output), "."); exportFormats[ext] != "" {
+			*format = ext
+		}
+	}
+	if _, ok := exportFormats[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected one of %s\n", *format, exportFormatNames())
+		return exitUsage
+	}
+	filter, err := exportFilterFromDates(*from, *to, *category)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *output, err)
+			return exitError
+		}
+		defer f.Close()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+
+	// Progress messages go to stderr so stdout carries only the export
+	logOutput = os.Stderr
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	if err := writeExport(buffered, *format, filter); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return exitError
+	}
+	if err := buffered.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runBackup writes every recorded scrape out as an archive in the scraper's format
+func runBackup(args []string) int {
+	fs := newFlagSet("backup", "backup DIR")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	paths, err := writeBackup(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed after %d archives: %v\n", len(paths), err)
+		return exitError
+	}
+	fmt.Printf("Wrote %d archives to %s\n", len(paths), fs.Arg(0))
+	return exitOK
+}
+
+// runRestore replays backup archives, given as a directory or individual files,
+// into an empty database
+func runRestore(args []string) int {
+	fs := newFlagSet("restore", "restore DIR | FILE.zip...")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var paths []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.zip"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "No archives found")
+		return exitError
+	}
+
+	if !openDB() {
+		return exitError
+	}
+	defer store.Close()
+
+	restored := 0
+	err := restoreBackup(paths, func(path string, result IngestResponse) {
+		restored++
+		fmt.Printf("[%d/%d] Restored %s (%d products)\n", restored, len(paths), filepath.Base(path), result.Count)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+		return exitError
+	}
+	fmt.Printf("Restored %d archives\n", restored)
+	return exitOK
+}
+
+// runCacheWarm requests the listing, category and product pages from a running
+// server so its caches are populated before users arrive, e.g. after a deploy
+func runCacheWarm(args []string) int {
+	fs := newFlagSet("cache-warm", "cache-warm [-url URL] [-key KEY] [-products N] [-concurrency N]")
+	defaultURL := "http://localhost:8080"
+	if port := os.Getenv("PORT"); port != "" {
+		defaultURL = "http://localhost:" + port
+	}
+	baseURL := fs.String("url", defaultURL, "base URL of the running API")
+	key := fs.String("key", os.Getenv("API_KEY"), "API key sent with requests; a read-high-rate key avoids the public rate limit (default $API_KEY)")
+	maxProducts := fs.Int("products", 200, "product detail pages to warm, 0 for every product")
+	concurrency := fs.Int("concurrency", 4, "parallel requests")
+	timeout := fs.Duration("timeout", 5*time.Minute, "give up after this long")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *concurrency < 1 || *maxProducts < 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(*baseURL)
+	c.APIKey = *key
+
+	products, err := c.Products(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to warm products: %v\n", err)
+		return exitError
+	}
+	categories, err := c.Categories(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to warm categories: %v\n", err)
+		return exitError
+	}
+
+	ids := make([]string, 0, len(products.Products))
+	for _, p := range products.Products {
+		ids = append(ids, p.ProductID)
+	}
+	if *maxProducts > 0 && len(ids) > *maxProducts {
+		ids = ids[:*maxProducts]
+	}
+
+	var (
+		mu     sync.Mutex
+		failed int
+	)
+	jobs := make(chan func() error)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := job(); err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}()
+	}
+	for _, category := range categories {
+		jobs <- func() error {
+			_, err := c.ProductsByCategory(ctx, category)
+			return err
+		}
+	}
+	for _, id := range ids {
+		jobs <- func() error {
+			_, err := c.Product(ctx, id)
+			return err
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	fmt.Printf("Warmed products, %d categories and %d product pages (%d failed)\n", len(categories), len(ids), failed)
+	if failed > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// runKeysCommand implements `keys create|list|revoke`, managing API keys directly in
+// the database. It is how the first admin key is created.
+func runKeysCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage:
+  keys create -name NAME -scopes ingest,admin,read-high-rate [-expires 90d|2027-01-01]
+  keys list
+  keys revoke ID`)
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		name := fs.String("name", "", "human readable name, e.g. github-actions-scraper")
+		scopes := fs.String("scopes", "", "comma-separated scopes: "+strings.Join(validScopes, ", "))
+		expires := fs.String("expires", "", "expiry as a duration in days (90d) or a date (YYYY-MM-DD); never expires if empty")
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitUsage
+		}
+		expiresAt, err := parseExpiry(*expires, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		if err := initDB(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+			return exitError
+		}
+		defer store.Close()
+
+		secret, key, err := createAPIKey(*name, splitList(*scopes), expiresAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create API key: %v\n", err)
+			return exitError
+		}
+		writeAudit("cli", "key.create", key.ID, apiKeyAuditDetails(key))
+		fmt.Printf("Created key %s (%s) with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println("Store this key now, it cannot be shown again:")
+		fmt.Println(secret)
+		return exitOK
+
+	case "list":
+		if err := initDB(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+			return exitError
+		}
+		defer store.Close()
+
+		keys, err := store.ListAPIKeys()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tLAST USED\tREVOKED")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","),
+				cliTime(k.CreatedAt), cliTime(k.ExpiresAt), cliTime(k.LastUsedAt), cliTime(k.RevokedAt))
+		}
+		w.Flush()
+		return exitOK
+
+	case "revoke":
+		if len(args) != 2 {
+			usage()
+			return exitUsage
+		}
+		if err := initDB(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+			return exitError
+		}
+		defer store.Close()
+
+		if err := store.RevokeAPIKey(args[1], time.Now().UTC()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke key %s: %v\n", args[1], err)
+			return exitError
+		}
+		writeAudit("cli", "key.revoke", args[1], map[string]any{})
+		fmt.Printf("Revoked key %s\n", args[1])
+		return exitOK
+
+	default:
+		usage()
+		return exitUsage
+	}
+}
+
+// parseExpiry accepts "" (never), "<n>d" or a YYYY-MM-DD date after now
+func parseExpiry(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return time.Time{}, fmt.Errorf("invalid expiry %q", v)
+		}
+		return now.UTC().AddDate(0, 0, n), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, expected e.g. 90d or 2027-01-01", v)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("expiry %s is not in the future", v)
+	}
+	return t, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func cliTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
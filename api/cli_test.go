@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"help"}, exitOK},
+		{[]string{"bogus"}, exitUsage},
+		{[]string{"ingest"}, exitUsage},
+		{[]string{"export", "-from", "last week"}, exitUsage},
+		{[]string{"migrate", "-h"}, exitOK},
+	} {
+		if got := run(tc.args); got != tc.want {
+			t.Errorf("run(%q) = %d, want %d", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "tracker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	applied, err := s.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(sqliteDialect.migrations) {
+		t.Fatalf("expected every migration to run on a fresh database, got %d", len(applied))
+	}
+	if applied, err = s.Migrate(); err != nil || len(applied) != 0 {
+		t.Fatalf("expected nothing to run the second time, got %d (%v)", len(applied), err)
+	}
+	statuses, err := s.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range statuses {
+		if m.AppliedAt.IsZero() {
+			t.Errorf("migration %d still pending", m.Version)
+		}
+	}
+}
+
+func TestMigrationBackfillsScraperCounts(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "tracker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.appliedMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sqliteDialect.migrations[:7] {
+		if err := s.applyMigration(m, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A run from before migration 8 that found two men/tops products, one of them also
+	// in sale, and nothing in kids
+	at := time.Date(2025, 1, 1, 6, 0, 0, 0, time.UTC)
+	if _, err := s.db.Exec(
+		"INSERT INTO scraper (datetime, scraper_version, total_products, total_failed, categories_scraped, categories, duration_seconds) VALUES ($1, 'test', 2, 0, 2, 'men/tops,kids', 0)",
+		s.timeArg(at),
+	); err != nil {
+		t.Fatal(err)
+	}
+	for id, category := range map[string]string{"A100": `["men/tops"]`, "B200": `["men/tops","sale"]`} {
+		if _, err := s.db.Exec(
+			"INSERT INTO products (product_id, name, price, url, category, datetime) VALUES ($1, $1, 29.90, '', $2, $3)",
+			id, category, s.timeArg(at),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := s.ScraperRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]CategoryCount{"men/tops": {Products: 2}, "sale": {Products: 1}, "kids": {}}
+	if len(runs) != 1 || len(runs[0].CategoryCounts) != len(want) {
+		t.Fatalf("expected the counts of one run, got %+v", runs)
+	}
+	for category, count := range want {
+		if got, ok := runs[0].CategoryCounts[category]; !ok || got != count {
+			t.Errorf("%s: expected %+v, got %+v", category, count, got)
+		}
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "tracker.db"))
+	if code := run([]string{"migrate"}); code != exitOK {
+		t.Fatalf("migrate exited with %d", code)
+	}
+	s, err := newSQLiteStore(filepath.Join(dir, "tracker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	seedHistory(t, map[string][]float64{"E100": {29.90, 19.90, 29.90}})
+	s.Close()
+
+	output := filepath.Join(dir, "prices.csv")
+	if code := run([]string{"export", "-from", "2025-01-02", "-to", "2025-01-03", "-category", "men/tops", "-o", output}); code != exitOK {
+		t.Fatalf("export exited with %d", code)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d", len(records))
+	}
+	if got := records[1]; got[0] != "E100" || got[2] != "19.90" || got[5] != "2025-01-02T00:00:00Z" || got[6] != "19.90" || got[8] != "29.90" {
+		t.Errorf("unexpected row %q", got)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDealsLimit and maxDealsLimit bound how many deals are returned at once
+const (
+	defaultDealsLimit = 50
+	maxDealsLimit     = 500
+)
+
+// Weights of the deal score components. A product at its maximum in every component
+// scores 100.
+const (
+	dealDiscountWeight = 50
+	dealDropWeight     = 30
+	dealRarityWeight   = 20
+)
+
+// dealScoring explains the deal score in every deals response
+const dealScoring = "score = 50 × discount from regular_price + 30 × drop since the previous scrape + 20 × rarity, " +
+	"where each factor is a fraction from 0 to 1 and rarity is the share of recorded prices above the current one"
+
+// dealSorts maps each sort option to the value deals are ranked by, highest first
+var dealSorts = map[string]func(DealInfo) float64{
+	"score":    func(d DealInfo) float64 { return d.Score },
+	"discount": func(d DealInfo) float64 { return d.DiscountPercent },
+	"drop":     func(d DealInfo) float64 { return d.DropPercent },
+	"rarity":   func(d DealInfo) float64 { return d.Rarity },
+}
+
+// DealScore is how many points each factor contributed to a deal's score
+type DealScore struct {
+	Discount float64 `json:"discount"`
+	Drop     float64 `json:"drop"`
+	Rarity   float64 `json:"rarity"`
+}
+
+// DealInfo is a product from the latest snapshot ranked as a deal. PreviousPrice is set
+// when the price moved since the previous scrape.
+type DealInfo struct {
+	ProductID       string    `json:"product_id"`
+	Name            string    `json:"name"`
+	URL             string    `json:"url"`
+	Categories      []string  `json:"categories"`
+	Price           float64   `json:"price"`
+	RegularPrice    float64   `json:"regular_price"`
+	LowestPrice     float64   `json:"lowest_price"`
+	PreviousPrice   *float64  `json:"previous_price"`
+	DiscountPercent float64   `json:"discount_percent"`
+	DropPercent     float64   `json:"drop_percent"`
+	Rarity          float64   `json:"rarity"`
+	IsAllTimeLow    bool      `json:"is_all_time_low"`
+	Stale           bool      `json:"stale"`
+	Score           float64   `json:"score"`
+	ScoreBreakdown  DealScore `json:"score_breakdown"`
+}
+
+// DealsResponse is the body returned by the deals endpoint
+type DealsResponse struct {
+	Datetime *string    `json:"datetime"`
+	Category string     `json:"category,omitempty"`
+	Sort     string     `json:"sort"`
+	Scoring  string     `json:"scoring"`
+	Count    int        `json:"count"`
+	Deals    []DealInfo `json:"deals"`
+}
+
+// roundTo rounds v to the given number of decimals
+func roundTo(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
+
+// newDeal scores a product from the latest scrape. previous is its price in the
+// scrape before, or nil if it didn't change; rank places its price in its history.
+func newDeal(p ProductResponse, previous *float64, rank PriceRank) DealInfo {
+	var discount, drop, rarity float64
+	if p.RegularPrice > 0 && p.Price < p.RegularPrice {
+		discount = (p.RegularPrice - p.Price) / p.RegularPrice
+	}
+	if previous != nil && *previous > 0 && p.Price < *previous {
+		drop = (*previous - p.Price) / *previous
+	}
+	if rank.Datapoints > 0 {
+		rarity = 1 - float64(rank.AtOrBelow)/float64(rank.Datapoints)
+	}
+
+	breakdown := DealScore{
+		Discount: roundTo(dealDiscountWeight*discount, 2),
+		Drop:     roundTo(dealDropWeight*drop, 2),
+		Rarity:   roundTo(dealRarityWeight*rarity, 2),
+	}
+	return DealInfo{
+		ProductID:       p.ProductID,
+		Name:            p.Name,
+		URL:             p.URL,
+		Categories:      p.Categories,
+		Price:           p.Price,
+		RegularPrice:    p.RegularPrice,
+		LowestPrice:     p.LowestPrice,
+		PreviousPrice:   previous,
+		DiscountPercent: roundTo(discount*100, 2),
+		DropPercent:     roundTo(drop*100, 2),
+		Rarity:          roundTo(rarity, 4),
+		IsAllTimeLow:    p.IsAllTimeLow,
+		Stale:           p.Stale,
+		Score:           roundTo(dealDiscountWeight*discount+dealDropWeight*drop+dealRarityWeight*rarity, 2),
+		ScoreBreakdown:  breakdown,
+	}
+}
+
+// dealsCache holds the scored deals of each category, "" for all of them, until the
+// next ingest or correction. Sorting and the limit are applied per request.
+var dealsCache = struct {
+	mu      sync.RWMutex
+	entries map[string]dealsEntry
+}{entries: make(map[string]dealsEntry)}
+
+type dealsEntry struct {
+	datetime  time.Time
+	deals     []DealInfo
+	expiresAt time.Time
+}
+
+// scoreDeals scores the products of the latest snapshot, optionally within one
+// category, keeping those with a positive score. It returns the snapshot's datetime,
+// which is zero before the first ingest.
+func scoreDeals(category string) (time.Time, []DealInfo, error) {
+	snapshot, err := latestSnapshot(category)
+	if err != nil || snapshot.Datetime.IsZero() {
+		return snapshot.Datetime, nil, err
+	}
+
+	// Stale categories come from older runs, so each product is compared within the run
+	// it was taken from. Both maps are keyed by product ID and run datetime.
+	previous := make(map[string]*float64)
+	ranks := make(map[string]PriceRank)
+	for _, run := range snapshot.Runs() {
+		// The price change log already holds each product's move since the previous scrape
+		from, to := runWindow(run)
+		changes, err := store.PriceChanges(PriceChangeFilter{Since: from, Until: to, Type: ChangeTypePrice, Category: category})
+		if err != nil {
+			return snapshot.Datetime, nil, err
+		}
+		for _, ch := range changes {
+			previous[ch.ProductID+"@"+formatTimestamp(run)] = ch.OldPrice
+		}
+		runRanks, err := store.PriceRanks(run)
+		if err != nil {
+			return snapshot.Datetime, nil, err
+		}
+		for id, rank := range runRanks {
+			ranks[id+"@"+formatTimestamp(run)] = rank
+		}
+	}
+
+	var deals []DealInfo
+	for _, p := range snapshot.Products {
+		key := p.ProductID + "@" + p.Datetime
+		deal := newDeal(p, previous[key], ranks[key])
+		if deal.Score > 0 {
+			deals = append(deals, deal)
+		}
+	}
+	return snapshot.Datetime, deals, nil
+}
+
+// getDeals ranks the discounted products of the latest scrape by deal score, or by
+// one of its factors with sort, optionally within one category
+func getDeals(c *gin.Context) {
+	category := c.Query("category")
+	sortBy := c.DefaultQuery("sort", "score")
+	rankBy, ok := dealSorts[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of score, discount, drop, rarity"})
+		return
+	}
+	limit := defaultDealsLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxDealsLimit)
+	}
+
+	dealsCache.mu.RLock()
+	entry, ok := dealsCache.entries[category]
+	dealsCache.mu.RUnlock()
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		datetime, deals, err := scoreDeals(category)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to score deals"})
+			return
+		}
+		entry = dealsEntry{datetime: datetime, deals: deals, expiresAt: time.Now().Add(cacheDuration)}
+		// Unknown categories have no deals and aren't cached, so the cache stays bounded
+		// by the number of categories
+		if len(deals) > 0 {
+			dealsCache.mu.Lock()
+			dealsCache.entries[category] = entry
+			dealsCache.mu.Unlock()
+		}
+	}
+
+	deals := slices.Clone(entry.deals)
+	slices.SortStableFunc(deals, func(a, b DealInfo) int {
+		if c := cmp.Compare(rankBy(b), rankBy(a)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ProductID, b.ProductID)
+	})
+	if len(deals) > limit {
+		deals = deals[:limit]
+	}
+	if deals == nil {
+		deals = []DealInfo{}
+	}
+	c.JSON(http.StatusOK, DealsResponse{
+		Datetime: formatDatetime(entry.datetime),
+		Category: category,
+		Sort:     sortBy,
+		Scoring:  dealScoring,
+		Count:    len(deals),
+		Deals:    deals,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeals(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		var empty DealsResponse
+		get(t, router, "/api/v1/deals", &empty)
+		if empty.Datetime != nil || empty.Count != 0 || empty.Deals == nil {
+			t.Fatalf("expected no deals before any ingest, got %+v", empty)
+		}
+
+		runs := []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "49.90"}, "women/tops": {"E300": "9.90"}}),
+			scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "39.90"}, "women/tops": {"E300": "9.90"}}),
+			scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90", "E200": "39.90"}, "women/tops": {"E300": "9.90"}}),
+		}
+		for _, output := range runs {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		// E100 is a third off its regular price, dropped today and is at its lowest
+		// ever; E200 only scores for being cheaper than it was; E300 never moved
+		var deals DealsResponse
+		get(t, router, "/api/v1/deals", &deals)
+		if deals.Count != 2 || deals.Deals[0].ProductID != "E100" || deals.Deals[1].ProductID != "E200" {
+			t.Fatalf("unexpected deals: %+v", deals.Deals)
+		}
+		if deals.Sort != "score" || deals.Scoring == "" || *deals.Datetime != "2025-01-03T06:00:00Z" {
+			t.Errorf("unexpected response metadata: %+v", deals)
+		}
+		top := deals.Deals[0]
+		if top.PreviousPrice == nil || *top.PreviousPrice != 29.90 || top.DiscountPercent != 33.44 || top.DropPercent != 33.44 || !top.IsAllTimeLow {
+			t.Errorf("unexpected top deal: %+v", top)
+		}
+		want := DealScore{Discount: 16.72, Drop: 10.03, Rarity: 13.33}
+		if top.ScoreBreakdown != want || top.Score != 40.09 {
+			t.Errorf("expected score 40.09 from %+v, got %v from %+v", want, top.Score, top.ScoreBreakdown)
+		}
+		if second := deals.Deals[1]; second.PreviousPrice != nil || second.DiscountPercent != 0 || second.ScoreBreakdown.Rarity != 6.67 {
+			t.Errorf("unexpected second deal: %+v", second)
+		}
+
+		get(t, router, "/api/v1/deals?sort=rarity&limit=1", &deals)
+		if deals.Count != 1 || deals.Deals[0].ProductID != "E100" {
+			t.Errorf("expected E100 alone when limited to one, got %+v", deals.Deals)
+		}
+		get(t, router, "/api/v1/deals?category=women/tops", &deals)
+		if deals.Count != 0 || deals.Category != "women/tops" {
+			t.Errorf("expected no deals in women/tops, got %+v", deals)
+		}
+
+		// Deals are cached until the next ingest, which brings E300 down
+		get(t, router, "/api/v1/deals", &deals)
+		if deals.Count != 2 || deals.Deals[0].ProductID != "E100" {
+			t.Errorf("expected the cached deals unchanged by an earlier limit, got %+v", deals.Deals)
+		}
+		output := scrapeOutput("2025-01-04T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90", "E200": "39.90"}, "women/tops": {"E300": "4.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/deals?category=women/tops", &deals)
+		if deals.Count != 1 || deals.Deals[0].ProductID != "E300" {
+			t.Errorf("expected E300 after the next ingest, got %+v", deals)
+		}
+
+		for _, query := range []string{"sort=price", "limit=0", "limit=many"} {
+			if rec := get(t, router, "/api/v1/deals?"+query, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", query, rec.Code)
+			}
+		}
+	})
+}
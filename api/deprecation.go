@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacyDeprecatedAt is when the unversioned /api routes were superseded by /api/v1
+var legacyDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// defaultLegacySunset is when the unversioned routes may be removed, unless LEGACY_API_SUNSET overrides it
+var defaultLegacySunset = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+
+// AliasUsage counts requests served by a deprecated route alias
+type AliasUsage struct {
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	Successor string  `json:"successor"`
+	Hits      int64   `json:"hits"`
+	LastUsed  *string `json:"last_used"`
+
+	lastUsed time.Time
+}
+
+// DeprecationsResponse is the body returned by the deprecations endpoint
+type DeprecationsResponse struct {
+	Deprecation string       `json:"deprecation"`
+	Sunset      string       `json:"sunset"`
+	Aliases     []AliasUsage `json:"aliases"`
+}
+
+// AliasMetrics tracks usage of deprecated aliases since startup
+type AliasMetrics struct {
+	mu      sync.Mutex
+	aliases map[string]*AliasUsage
+}
+
+var aliasMetrics = &AliasMetrics{aliases: make(map[string]*AliasUsage)}
+
+// register records an alias so it is reported even before its first hit
+func (m *AliasMetrics) register(method, route, successor string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aliases[method+" "+route] = &AliasUsage{Method: method, Route: route, Successor: successor}
+}
+
+func (m *AliasMetrics) hit(method, route string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if usage, ok := m.aliases[method+" "+route]; ok {
+		usage.Hits++
+		usage.lastUsed = time.Now()
+	}
+}
+
+func (m *AliasMetrics) snapshot() []AliasUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usages := make([]AliasUsage, 0, len(m.aliases))
+	for _, usage := range m.aliases {
+		u := *usage
+		u.LastUsed = formatDatetime(u.lastUsed)
+		usages = append(usages, u)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Route < usages[j].Route
+	})
+	return usages
+}
+
+// legacySunset returns the sunset date for the unversioned routes
+func legacySunset() time.Time {
+	if v := os.Getenv("LEGACY_API_SUNSET"); v != "" {
+		if t, err := time.Parse(time.DateOnly, v); err == nil {
+			return t
+		}
+		fmt.Printf("WARNING: ignoring invalid LEGACY_API_SUNSET %q, expected YYYY-MM-DD\n", v)
+	}
+	return defaultLegacySunset
+}
+
+// legacyAlias registers a deprecated route on the /api group that serves the same
+// handlers as its /api/v1 successor, adding Deprecation, Sunset and Link headers
+// (RFC 9745 / RFC 8594) and counting hits so the alias can be retired safely
+func legacyAlias(group *gin.RouterGroup, method, path, successor string, handlers ...gin.HandlerFunc) {
+	route := group.BasePath() + path
+	aliasMetrics.register(method, route, successor)
+
+	deprecation := fmt.Sprintf("@%d", legacyDeprecatedAt.Unix())
+	sunset := legacySunset().Format(http.TimeFormat)
+
+	mark := func(c *gin.Context) {
+		aliasMetrics.hit(method, route)
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunset)
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successorURL(c, successor)))
+		c.Next()
+	}
+	group.Handle(method, path, append([]gin.HandlerFunc{mark}, handlers...)...)
+}
+
+// successorURL fills the successor route's parameters from the current request
+func successorURL(c *gin.Context, successor string) string {
+	url := successor
+	for _, p := range c.Params {
+		value := strings.TrimPrefix(p.Value, "/")
+		url = strings.Replace(url, ":"+p.Key, value, 1)
+		url = strings.Replace(url, "*"+p.Key, value, 1)
+	}
+	return url
+}
+
+// getDeprecations reports the deprecated aliases and how often they are still used
+func getDeprecations(c *gin.Context) {
+	c.JSON(http.StatusOK, DeprecationsResponse{
+		Deprecation: legacyDeprecatedAt.Format(time.DateOnly),
+		Sunset:      legacySunset().Format(time.DateOnly),
+		Aliases:     aliasMetrics.snapshot(),
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Server-sent event types
+const (
+	EventIngestStarted     = "ingest.started"
+	EventIngestProgress    = "ingest.progress"
+	EventIngestCompleted   = "ingest.completed"
+	EventIngestFailed      = "ingest.failed"
+	EventIngestQuarantined = "ingest.quarantined"
+	EventPriceChange       = "price_change"
+	EventPriceChanges      = "price_changes"
+)
+
+const (
+	// eventReplaySize is how many recent events are kept for clients reconnecting with Last-Event-ID
+	eventReplaySize = 500
+	// eventClientBuffer is how many events may queue for a client before it is dropped
+	eventClientBuffer = 256
+	// maxEventClients caps concurrent event streams
+	maxEventClients = 500
+	// eventHeartbeat keeps idle streams open through proxies that time out quiet connections
+	eventHeartbeat = 25 * time.Second
+	// ingestProgressEvery is how many products are ingested between progress events
+	ingestProgressEvery = 100
+	// maxRunChangeEvents is how many price_change events a run may send before they are
+	// summarized in one price_changes event, well within eventClientBuffer
+	maxRunChangeEvents = 100
+)
+
+// IngestEvent is the data of the ingest.* events. Datetime is the scrape being
+// ingested; Count and Total track progress through its products.
+type IngestEvent struct {
+	Datetime   string `json:"datetime,omitempty"`
+	Count      int    `json:"count"`
+	Total      int    `json:"total"`
+	Categories int    `json:"categories,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// PriceChangesEvent is the data of the price_changes event, sent in place of the
+// individual price_change events of a run with too many to stream. URL lists them.
+type PriceChangesEvent struct {
+	Datetime string `json:"datetime"`
+	Count    int    `json:"count"`
+	URL      string `json:"url"`
+}
+
+// serverEvent is a published event, already encoded for the wire
+type serverEvent struct {
+	id        uint64
+	eventType string
+	data      []byte
+}
+
+// eventHub fans published events out to every connected stream and keeps the most
+// recent ones so reconnecting clients can catch up
+type eventHub struct {
+	mu      sync.Mutex
+	nextID  uint64
+	recent  []serverEvent
+	clients map[chan serverEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: make(map[chan serverEvent]struct{})}
+}
+
+// liveEvents carries ingest progress and price changes to /api/v1/events
+var liveEvents = newEventHub()
+
+// publish encodes data and sends it to every client. A client whose buffer is full is
+// disconnected rather than slowing ingest down; it can reconnect and replay.
+func (h *eventHub) publish(eventType string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("WARNING: failed to encode %s event: %v\n", eventType, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	e := serverEvent{id: h.nextID, eventType: eventType, data: encoded}
+	h.recent = append(h.recent, e)
+	if len(h.recent) > eventReplaySize {
+		h.recent = h.recent[len(h.recent)-eventReplaySize:]
+	}
+	for ch := range h.clients {
+		select {
+		case ch <- e:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a client, returning the buffered events after lastID to replay
+// first. It fails when maxEventClients streams are already open.
+func (h *eventHub) subscribe(lastID uint64) (chan serverEvent, []serverEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) >= maxEventClients {
+		return nil, nil, false
+	}
+	var replay []serverEvent
+	if lastID > 0 {
+		for _, e := range h.recent {
+			if e.id > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+	ch := make(chan serverEvent, eventClientBuffer)
+	h.clients[ch] = struct{}{}
+	return ch, replay, true
+}
+
+// unsubscribe removes a client, unless publish already dropped it
+func (h *eventHub) unsubscribe(ch chan serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// publishRunChanges sends the price changes recorded for the scrape run at datetime.
+// A run with more than maxRunChangeEvents, e.g. on a sale day, is sent as a single
+// price_changes event linking to them instead, so slow clients aren't dropped.
+func publishRunChanges(datetime time.Time) {
+	from, to := runWindow(datetime)
+	changes, err := store.PriceChanges(PriceChangeFilter{Since: from, Until: to})
+	if err != nil {
+		fmt.Printf("WARNING: failed to load price changes for events: %v\n", err)
+		return
+	}
+	if len(changes) > maxRunChangeEvents {
+		scraped := formatTimestamp(datetime)
+		liveEvents.publish(EventPriceChanges, PriceChangesEvent{
+			Datetime: scraped,
+			Count:    len(changes),
+			URL:      "/api/v1/changes?since=" + url.QueryEscape(scraped),
+		})
+		return
+	}
+	for _, ch := range changes {
+		liveEvents.publish(EventPriceChange, newPriceChangeInfo(ch))
+	}
+}
+
+// writeServerEvent writes one event in the text/event-stream format
+func writeServerEvent(c *gin.Context, e serverEvent) error {
+	_, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.eventType, e.data)
+	return err
+}
+
+// getEvents streams ingest progress and price changes as server-sent events until the
+// client disconnects. Clients reconnecting with Last-Event-ID first receive the events
+// they missed, as far back as the replay buffer goes.
+func getEvents(c *gin.Context) {
+	var lastID uint64
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+	ch, replay, ok := liveEvents.subscribe(lastID)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many event streams open, try again later"})
+		return
+	}
+	defer liveEvents.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, e := range replay {
+		if writeServerEvent(c, e) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if writeServerEvent(c, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type streamedEvent struct {
+	id        string
+	eventType string
+	data      string
+}
+
+// openEventStream connects to /api/v1/events on a live server
+func openEventStream(t *testing.T, url, lastEventID string) io.ReadCloser {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/api/v1/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected event stream response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return resp.Body
+}
+
+// readEvents reads events from a stream until one of the given type arrives
+func readEvents(t *testing.T, body io.Reader, until string) []streamedEvent {
+	t.Helper()
+	var events []streamedEvent
+	var current streamedEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.eventType != "" {
+				events = append(events, current)
+				if current.eventType == until {
+					return events
+				}
+			}
+			current = streamedEvent{}
+		case strings.HasPrefix(line, "id: "):
+			current.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			current.eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("stream ended before a %s event, got %+v (%v)", until, events, scanner.Err())
+	return nil
+}
+
+func TestEventStream(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		srv := httptest.NewServer(router)
+		defer srv.Close()
+
+		first := scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, first, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		stream := openEventStream(t, srv.URL, "")
+		second := scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"E100": "19.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, second, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		events := readEvents(t, stream, EventPriceChange)
+		var types []string
+		for _, e := range events {
+			types = append(types, e.eventType)
+		}
+		if strings.Join(types, ",") != "ingest.started,ingest.completed,price_change" {
+			t.Fatalf("unexpected event sequence %v", types)
+		}
+		var completed IngestEvent
+		if err := json.Unmarshal([]byte(events[1].data), &completed); err != nil {
+			t.Fatal(err)
+		}
+		if completed.Datetime != "2025-01-02T06:00:00Z" || completed.Count != 1 || completed.Total != 1 {
+			t.Errorf("unexpected completed event: %+v", completed)
+		}
+		var change PriceChangeInfo
+		if err := json.Unmarshal([]byte(events[2].data), &change); err != nil {
+			t.Fatal(err)
+		}
+		if change.ProductID != "E100" || change.Direction != "down" || !change.AllTimeLow {
+			t.Errorf("unexpected price change event: %+v", change)
+		}
+
+		// A client reconnecting after the started event replays what it missed
+		replay := readEvents(t, openEventStream(t, srv.URL, events[0].id), EventPriceChange)
+		if len(replay) != 2 || replay[0].id != events[1].id {
+			t.Errorf("unexpected replay: %+v", replay)
+		}
+
+		// Failures are broadcast too
+		stream = openEventStream(t, srv.URL, "")
+		ingest(t, router, []byte("not a zip"))
+		failed := readEvents(t, stream, EventIngestFailed)
+		if !strings.Contains(failed[len(failed)-1].data, "Invalid ZIP file") {
+			t.Errorf("unexpected failure event: %+v", failed)
+		}
+	})
+}
+
+func TestEventStreamSummarizesLargeRuns(t *testing.T) {
+	// Every price changing would otherwise be quarantined
+	defer func(saved AnomalyThresholds) { anomalyThresholds = saved }(anomalyThresholds)
+	anomalyThresholds.Enabled = false
+
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// Closed after the stream, which would otherwise hold it open until it times out
+		srv := httptest.NewServer(router)
+		t.Cleanup(srv.Close)
+
+		// More changes than a client's buffer holds, as on a sale day
+		first := scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": pricedProducts(0, 300, "29.90")})
+		if rec := ingest(t, router, buildScrapeZip(t, first, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+		stream := openEventStream(t, srv.URL, "")
+		second := scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": pricedProducts(0, 300, "19.90")})
+		if rec := ingest(t, router, buildScrapeZip(t, second, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		events := readEvents(t, stream, EventPriceChanges)
+		for _, e := range events {
+			if e.eventType == EventPriceChange {
+				t.Fatalf("expected the run's changes to be summarized, got %+v", e)
+			}
+		}
+		var summary PriceChangesEvent
+		if err := json.Unmarshal([]byte(events[len(events)-1].data), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Count != 300 || summary.Datetime != "2025-01-02T06:00:00Z" {
+			t.Fatalf("unexpected summary event: %+v", summary)
+		}
+		var changes PriceChangesResponse
+		if rec := get(t, router, summary.URL+"&limit=1000", &changes); rec.Code != http.StatusOK || changes.Count != 300 {
+			t.Errorf("expected the summary's link to list the 300 changes, got %d with %d", rec.Code, changes.Count)
+		}
+	})
+}
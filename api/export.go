@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportFormats maps each export format to its content type
+var exportFormats = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"ndjson":  "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportRowGroupSize bounds how many rows the Parquet writer buffers before
+// flushing a row group, which keeps exports streaming
+const exportRowGroupSize = 10000
+
+// exportColumns is the header of every export, one column per ExportRecord field
+var exportColumns = []string{
+	"product_id", "name", "price", "url", "categories", "datetime",
+	"lowest_price", "highest_price", "regular_price",
+}
+
+// ExportRecord is a single exported datapoint, as written to NDJSON and Parquet
+type ExportRecord struct {
+	ProductID    string    `json:"product_id" parquet:"product_id"`
+	Name         string    `json:"name" parquet:"name"`
+	Price        float64   `json:"price" parquet:"price"`
+	URL          string    `json:"url" parquet:"url"`
+	Categories   []string  `json:"categories" parquet:"categories,list"`
+	Datetime     time.Time `json:"datetime" parquet:"datetime,timestamp(millisecond)"`
+	LowestPrice  float64   `json:"lowest_price" parquet:"lowest_price"`
+	HighestPrice float64   `json:"highest_price" parquet:"highest_price"`
+	RegularPrice float64   `json:"regular_price" parquet:"regular_price"`
+}
+
+func newExportRecord(row ExportRow) ExportRecord {
+	categories := row.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+	return ExportRecord{
+		ProductID:    row.ProductID,
+		Name:         row.Name,
+		Price:        row.Price,
+		URL:          row.URL,
+		Categories:   categories,
+		Datetime:     row.Datetime.UTC(),
+		LowestPrice:  row.LowestPrice,
+		HighestPrice: row.HighestPrice,
+		RegularPrice: row.RegularPrice,
+	}
+}
+
+// formatPrice writes prices with the two decimals they are stored with
+func formatPrice(p float64) string {
+	return strconv.FormatFloat(p, 'f', 2, 64)
+}
+
+// writeExport streams the price history matching filter in the given format
+func writeExport(w io.Writer, format string, filter ExportFilter) error {
+	switch format {
+	case "csv":
+		return writeExportCSV(w, filter)
+	case "ndjson":
+		return writeExportNDJSON(w, filter)
+	case "parquet":
+		return writeExportParquet(w, filter)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// writeExportCSV streams the price history matching filter as CSV. Categories are
+// joined with ";" so each datapoint stays on one row.
+func writeExportCSV(w io.Writer, filter ExportFilter) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+	err := store.ExportHistory(filter, func(row ExportRow) error {
+		return cw.Write([]string{
+			row.ProductID,
+			row.Name,
+			formatPrice(row.Price),
+			row.URL,
+			strings.Join(row.Categories, ";"),
+			row.Datetime.UTC().Format(time.RFC3339),
+			formatPrice(row.LowestPrice),
+			formatPrice(row.HighestPrice),
+			formatPrice(row.RegularPrice),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeExportNDJSON streams the price history matching filter as one JSON object per line
+func writeExportNDJSON(w io.Writer, filter ExportFilter) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := store.ExportHistory(filter, func(row ExportRow) error {
+		return enc.Encode(newExportRecord(row))
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeExportParquet streams the price history matching filter as a Parquet file,
+// flushing a row group every exportRowGroupSize rows
+func writeExportParquet(w io.Writer, filter ExportFilter) error {
+	pw := parquet.NewGenericWriter[ExportRecord](w,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(exportRowGroupSize),
+	)
+	batch := make([]ExportRecord, 0, 256)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := pw.Write(batch)
+		batch = batch[:0]
+		return err
+	}
+	err := store.ExportHistory(filter, func(row ExportRow) error {
+		batch = append(batch, newExportRecord(row))
+		if len(batch) == cap(batch) {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// exportFilterFromDates builds an ExportFilter from inclusive YYYY-MM-DD bounds
+func exportFilterFromDates(from, to, category string) (ExportFilter, error) {
+	filter := ExportFilter{Category: category}
+	if from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+		}
+		filter.From = t
+	}
+	if to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		filter.To = t.AddDate(0, 0, 1)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from date must not be after to date")
+	}
+	return filter, nil
+}
+
+// exportFormatNames lists the supported formats for error messages
+func exportFormatNames() string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// getExport streams the full price history joined with stats, filtered by the
+// optional from, to and category query parameters
+func getExport(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := exportFormats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported export format", "details": "expected one of " + exportFormatNames()})
+		return
+	}
+	filter, err := exportFilterFromDates(c.Query("from"), c.Query("to"), c.Query("category"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export filter", "details": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="prices-%s.%s"`, time.Now().UTC().Format(time.DateOnly), format))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure part way through can only be logged and
+	// the response cut short
+	if err := writeExport(c.Writer, format, filter); err != nil {
+		fmt.Printf("WARNING: export failed: %v\n", err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExportCSV(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		seedHistory(t, map[string][]float64{"E100": {29.90, 19.90, 29.90}})
+
+		rec := get(t, router, "/api/v1/export?from=2025-01-02&to=2025-01-03&category=men/tops", nil)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+			t.Fatalf("export: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("expected a header and 2 rows, got %d", len(records))
+		}
+		if got := records[1]; got[0] != "E100" || got[2] != "19.90" || got[5] != "2025-01-02T00:00:00Z" || got[6] != "19.90" || got[8] != "29.90" {
+			t.Errorf("unexpected row %q", got)
+		}
+	})
+}
+
+func TestExportNDJSON(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		seedHistory(t, map[string][]float64{"E100": {29.90, 19.90}, "E200": {9.90}})
+		if err := store.HideProduct(HiddenProduct{ProductID: "E200", HiddenAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := get(t, router, "/api/v1/export?format=ndjson", nil)
+		var rows []ExportRecord
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var row ExportRecord
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) != 2 {
+			t.Fatalf("expected 2 rows without the hidden product, got %d", len(rows))
+		}
+		if rows[0].Price != 29.90 || rows[1].Price != 19.90 || rows[1].LowestPrice != 19.90 || rows[0].Categories[0] != "men/tops" {
+			t.Errorf("unexpected rows %+v", rows)
+		}
+	})
+}
+
+func TestExportParquet(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		seedHistory(t, map[string][]float64{"E100": {29.90, 19.90}, "E200": {9.90}})
+
+		rec := get(t, router, "/api/v1/export?format=parquet", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export: %d", rec.Code)
+		}
+		rows, err := parquet.Read[ExportRecord](bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 {
+			t.Fatalf("expected 3 rows, got %d", len(rows))
+		}
+		want := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+		if !rows[0].Datetime.Equal(want) || rows[2].ProductID != "E100" || rows[2].Price != 19.90 {
+			t.Errorf("unexpected rows %+v", rows)
+		}
+	})
+}
+
+func TestSQLiteExportLeavesWriterFree(t *testing.T) {
+	store = testBackends["sqlite"](t)
+	seedHistory(t, map[string][]float64{"E100": {29.90, 19.90}})
+
+	// Hold an export open part way through, as a slow download would
+	started, release := make(chan struct{}), make(chan struct{})
+	exported := make(chan error, 1)
+	go func() {
+		first := true
+		exported <- store.ExportHistory(ExportFilter{}, func(ExportRow) error {
+			if first {
+				first = false
+				close(started)
+				<-release
+			}
+			return nil
+		})
+	}()
+	<-started
+	defer func() {
+		close(release)
+		if err := <-exported; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	inserted := make(chan error, 1)
+	go func() {
+		inserted <- store.InsertProduct(ProductRecord{ProductID: "E200", Price: 9.90, Categories: []string{"men/tops"}, Datetime: time.Now()})
+	}()
+	select {
+	case err := <-inserted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected writes to proceed while an export is open")
+	}
+}
+
+func TestExportRejectsBadFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for _, path := range []string{
+			"/api/v1/export?format=xlsx",
+			"/api/v1/export?from=01/02/2025",
+			"/api/v1/export?from=2025-02-01&to=2025-01-01",
+		} {
+			if rec := get(t, router, path, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", path, rec.Code)
+			}
+		}
+	})
+}
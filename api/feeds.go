@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedEntries is how many of the newest events each feed carries
+const feedEntries = 50
+
+// feedKind is one of the feeds built from the price change log
+type feedKind struct {
+	title  string
+	filter PriceChangeFilter
+}
+
+// feedKinds maps each feed name to the events it carries
+var feedKinds = map[string]feedKind{
+	"drops": {title: "Price drops", filter: PriceChangeFilter{Type: ChangeTypePrice, Direction: "down"}},
+	"atl":   {title: "New all-time lows", filter: PriceChangeFilter{Type: ChangeTypePrice, Direction: "down", AllTimeLow: true}},
+}
+
+// AtomFeed is an Atom 1.0 feed document
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Author  AtomAuthor  `xml:"author"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// AtomLink is an Atom link element
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// AtomAuthor names a feed's author
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// AtomEntry is a single price change in an Atom feed
+type AtomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Links      []AtomLink     `xml:"link"`
+	Categories []AtomCategory `xml:"category"`
+	Content    AtomContent    `xml:"content"`
+}
+
+// AtomCategory tags an entry with a product category
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// AtomContent is an entry's HTML body
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// JSONFeed is a JSON Feed 1.1 document
+type JSONFeed struct {
+	Version string         `json:"version"`
+	Title   string         `json:"title"`
+	FeedURL string         `json:"feed_url"`
+	Items   []JSONFeedItem `json:"items"`
+}
+
+// JSONFeedItem is a single price change in a JSON Feed
+type JSONFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Image         string   `json:"image"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags"`
+}
+
+// feedItem is the format-independent content of a feed entry
+type feedItem struct {
+	id         string
+	title      string
+	url        string
+	image      string
+	html       string
+	categories []string
+	datetime   string
+}
+
+// publicBaseURL returns the absolute URL the API is served from, for links that leave
+// the API such as feed images. It is PUBLIC_BASE_URL when set, and otherwise built from
+// the request. X-Forwarded-Proto is only believed from a trusted proxy, which gin has
+// already recognized when it takes the client IP from X-Forwarded-For.
+func publicBaseURL(c *gin.Context) string {
+	if base := os.Getenv("PUBLIC_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || (c.ClientIP() != c.RemoteIP() && c.GetHeader("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// newFeedItem renders a price drop. The ID is derived from the product and run rather
+// than the event ID, so readers don't see duplicates when events are rebuilt.
+func newFeedItem(base string, ch PriceChange) feedItem {
+	datetime := formatTimestamp(ch.Datetime)
+	image := base + "/api/v1/product/" + url.PathEscape(ch.ProductID) + "/image"
+	var discount float64
+	if ch.PercentChange != nil {
+		discount = math.Round(-*ch.PercentChange)
+	}
+	verb := "dropped to"
+	if ch.AllTimeLow {
+		verb = "hit an all-time low of"
+	}
+	title := fmt.Sprintf("%s %s $%s (was $%s, %.0f%% off)", ch.Name, verb, formatPrice(*ch.NewPrice), formatPrice(*ch.OldPrice), discount)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, `<p><img src="%s" alt="%s"></p>`, html.EscapeString(image), html.EscapeString(ch.Name))
+	fmt.Fprintf(&body, `<p>Now <strong>$%s</strong>, was $%s (%.0f%% off).</p>`, formatPrice(*ch.NewPrice), formatPrice(*ch.OldPrice), discount)
+	if len(ch.Categories) > 0 {
+		fmt.Fprintf(&body, `<p>Categories: %s</p>`, html.EscapeString(strings.Join(ch.Categories, ", ")))
+	}
+
+	return feedItem{
+		id:         fmt.Sprintf("tag:uniqlotracker.com,2025:price-change/%s/%s", ch.ProductID, datetime),
+		title:      title,
+		url:        ch.URL,
+		image:      image,
+		html:       body.String(),
+		categories: ch.Categories,
+		datetime:   datetime,
+	}
+}
+
+// getFeed serves the drops and all-time low feeds as Atom or JSON Feed, optionally
+// limited to one category
+func getFeed(c *gin.Context) {
+	name, format, _ := strings.Cut(c.Param("feed"), ".")
+	kind, ok := feedKinds[name]
+	if !ok || (format != "atom" && format != "json") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found", "details": "expected drops or atl with an .atom or .json extension"})
+		return
+	}
+
+	filter := kind.filter
+	filter.Category = c.Query("category")
+	filter.Limit = feedEntries
+	changes, err := store.PriceChanges(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query price changes"})
+		return
+	}
+
+	base := publicBaseURL(c)
+	title := "Uniqlo Price Tracker: " + kind.title
+	if filter.Category != "" {
+		title += " in " + filter.Category
+	}
+	self := base + c.Request.URL.RequestURI()
+	items := make([]feedItem, 0, len(changes))
+	for _, ch := range changes {
+		items = append(items, newFeedItem(base, ch))
+	}
+
+	if format == "json" {
+		c.Header("Content-Type", "application/feed+json; charset=utf-8")
+		c.JSON(http.StatusOK, newJSONFeed(title, self, items))
+		return
+	}
+	body, err := xml.MarshalIndent(newAtomFeed(title, self, items), "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render feed"})
+		return
+	}
+	c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// newAtomFeed builds an Atom document. The feed is as recent as its newest entry.
+func newAtomFeed(title, self string, items []feedItem) AtomFeed {
+	feed := AtomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: formatTimestamp(time.Now()),
+		Links:   []AtomLink{{Href: self, Rel: "self", Type: "application/atom+xml"}},
+		Author:  AtomAuthor{Name: "Uniqlo Price Tracker"},
+		Entries: make([]AtomEntry, 0, len(items)),
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].datetime
+	}
+	for _, item := range items {
+		entry := AtomEntry{
+			ID:      item.id,
+			Title:   item.title,
+			Updated: item.datetime,
+			Links: []AtomLink{
+				{Href: item.url, Rel: "alternate", Type: "text/html"},
+				{Href: item.image, Rel: "enclosure", Type: "image/jpeg"},
+			},
+			Content: AtomContent{Type: "html", Body: item.html},
+		}
+		for _, category := range item.categories {
+			entry.Categories = append(entry.Categories, AtomCategory{Term: category})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// newJSONFeed builds a JSON Feed document
+func newJSONFeed(title, self string, items []feedItem) JSONFeed {
+	feed := JSONFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   title,
+		FeedURL: self,
+		Items:   make([]JSONFeedItem, 0, len(items)),
+	}
+	for _, item := range items {
+		tags := item.categories
+		if tags == nil {
+			tags = []string{}
+		}
+		feed.Items = append(feed.Items, JSONFeedItem{
+			ID:            item.id,
+			URL:           item.url,
+			Title:         item.title,
+			ContentHTML:   item.html,
+			Image:         item.image,
+			DatePublished: item.datetime,
+			Tags:          tags,
+		})
+	}
+	return feed
+}
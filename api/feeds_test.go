@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFeeds(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.0/24")
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for i, prices := range [][2]string{{"29.90", "49.90"}, {"19.90", "49.90"}, {"24.90", "39.90"}, {"22.90", "39.90"}} {
+			datetime := []string{"2025-01-01T06:00:00Z", "2025-01-02T06:00:00Z", "2025-01-03T06:00:00Z", "2025-01-04T06:00:00Z"}[i]
+			output := scrapeOutput(datetime, map[string]map[string]string{"men/tops": {"E100": prices[0]}, "women/tops": {"E200": prices[1]}})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var drops JSONFeed
+		rec := get(t, router, "/api/v1/feeds/drops.json", &drops)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/feed+json") {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		if len(drops.Items) != 3 {
+			t.Fatalf("expected 3 drops, got %+v", drops.Items)
+		}
+		latest := drops.Items[0]
+		if latest.Title != "Product E100 dropped to $22.90 (was $24.90, 8% off)" {
+			t.Errorf("unexpected title %q", latest.Title)
+		}
+		if latest.URL != "https://www.uniqlo.com/ca/en/products/E100" || !strings.HasSuffix(latest.Image, "/api/v1/product/E100/image") {
+			t.Errorf("unexpected links: %q %q", latest.URL, latest.Image)
+		}
+
+		// Without PUBLIC_BASE_URL links are built from the request, believing
+		// X-Forwarded-Proto only from a trusted proxy
+		feedBody := func(remote string) string {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/feeds/drops.json", nil)
+			req.RemoteAddr = remote
+			req.Header.Set("X-Forwarded-For", "198.51.100.7")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Body.String()
+		}
+		if body := feedBody("203.0.113.9:1234"); !strings.Contains(body, `"http://example.com/api/v1/product/E100/image"`) {
+			t.Errorf("expected absolute links ignoring an untrusted X-Forwarded-Proto, got %s", body)
+		}
+		if body := feedBody("192.0.2.1:1234"); !strings.Contains(body, `"https://example.com/api/v1/product/E100/image"`) {
+			t.Errorf("expected https links behind a trusted proxy, got %s", body)
+		}
+		t.Setenv("PUBLIC_BASE_URL", "https://api.uniqlotracker.com/")
+		if body := feedBody("192.0.2.1:1234"); !strings.Contains(body, `"https://api.uniqlotracker.com/api/v1/product/E100/image"`) {
+			t.Errorf("expected links from PUBLIC_BASE_URL, got %s", body)
+		}
+
+		var atl JSONFeed
+		get(t, router, "/api/v1/feeds/atl.json", &atl)
+		if len(atl.Items) != 2 || !strings.Contains(atl.Items[0].Title, "all-time low of $39.90") {
+			t.Errorf("expected the two record lows, got %+v", atl.Items)
+		}
+		get(t, router, "/api/v1/feeds/atl.json?category=women/tops", &atl)
+		if len(atl.Items) != 1 || atl.Items[0].Tags[0] != "women/tops" {
+			t.Errorf("expected one record low in women/tops, got %+v", atl.Items)
+		}
+
+		rec = get(t, router, "/api/v1/feeds/drops.atom?category=men/tops", nil)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		var feed AtomFeed
+		if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		if len(feed.Entries) != 2 || feed.Updated != "2025-01-04T06:00:00Z" {
+			t.Fatalf("unexpected atom feed: %d entries updated %s", len(feed.Entries), feed.Updated)
+		}
+		if feed.Entries[0].ID == feed.Entries[1].ID || !strings.Contains(feed.Entries[0].Content.Body, "was $24.90") {
+			t.Errorf("unexpected atom entries: %+v", feed.Entries)
+		}
+
+		for _, path := range []string{"/api/v1/feeds/rises.atom", "/api/v1/feeds/drops.rss"} {
+			if rec := get(t, router, path, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("%s: expected 404, got %d", path, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Forecast recommendations
+const (
+	RecommendBuyNow = "buy_now"
+	RecommendWait   = "wait"
+)
+
+// Forecast confidence levels
+const (
+	ConfidenceLow    = "low"
+	ConfidenceMedium = "medium"
+	ConfidenceHigh   = "high"
+)
+
+// categorySaleMinDrops and categorySaleShare decide when a day's price drops in a
+// category count as a category-wide sale: at least this many products, and at least
+// this share of the category
+const (
+	categorySaleMinDrops = 3
+	categorySaleShare    = 0.2
+)
+
+// nearLowTolerance is how far above its lowest recorded price a product still counts
+// as being at its low
+const nearLowTolerance = 0.05
+
+// forecastWaitWindow is how soon a sale has to be expected to recommend waiting for it
+const forecastWaitWindow = 60 * 24 * time.Hour
+
+// categoryAgreementWindow is how close the product's and its categories' estimates
+// have to be to count as agreeing
+const categoryAgreementWindow = 14 * 24 * time.Hour
+
+// SaleForecast is when a product is expected to go on sale next. Confidence is low,
+// medium or high, from ConfidenceScore between 0 and 1.
+type SaleForecast struct {
+	Date            string   `json:"date"`
+	InDays          int      `json:"in_days"`
+	EstimatedPrice  *float64 `json:"estimated_price"`
+	Confidence      string   `json:"confidence"`
+	ConfidenceScore float64  `json:"confidence_score"`
+}
+
+// ForecastBasis is the sale history a forecast was made from. SaleMonths are the
+// months, 1 to 12, in which the product or its categories have gone on sale, busiest
+// first.
+type ForecastBasis struct {
+	SaleEpisodes        int      `json:"sale_episodes"`
+	AverageIntervalDays *float64 `json:"average_interval_days"`
+	CategorySaleEvents  int      `json:"category_sale_events"`
+	NextCategorySale    *string  `json:"next_category_sale"`
+	SaleMonths          []int    `json:"sale_months"`
+}
+
+// ForecastResponse is the body returned by the product forecast endpoint. NextSale is
+// null while the product is on sale, or when its history gives nothing to forecast from.
+type ForecastResponse struct {
+	ProductID      string        `json:"product_id"`
+	Name           string        `json:"name"`
+	AsOf           string        `json:"as_of"`
+	CurrentPrice   float64       `json:"current_price"`
+	RegularPrice   float64       `json:"regular_price"`
+	LowestPrice    float64       `json:"lowest_price"`
+	OnSale         bool          `json:"on_sale"`
+	NextSale       *SaleForecast `json:"next_sale"`
+	Basis          ForecastBasis `json:"basis"`
+	Recommendation string        `json:"recommendation"`
+	Reason         string        `json:"reason"`
+}
+
+// averageInterval returns the mean gap between consecutive times, oldest first, and
+// its coefficient of variation
+func averageInterval(times []time.Time) (mean time.Duration, variation float64) {
+	if len(times) < 2 {
+		return 0, 0
+	}
+	gaps := make([]float64, 0, len(times)-1)
+	var total float64
+	for i := 1; i < len(times); i++ {
+		gap := times[i].Sub(times[i-1]).Hours()
+		gaps = append(gaps, gap)
+		total += gap
+	}
+	avg := total / float64(len(gaps))
+	var squares float64
+	for _, g := range gaps {
+		squares += (g - avg) * (g - avg)
+	}
+	if avg > 0 {
+		variation = math.Sqrt(squares/float64(len(gaps))) / avg
+	}
+	return time.Duration(avg * float64(time.Hour)), variation
+}
+
+// nextAfter projects the next occurrence of a recurring event from its past times,
+// oldest first, and reports whether there were enough to project from. An overdue
+// occurrence is expected at asOf.
+func nextAfter(times []time.Time, asOf time.Time) (time.Time, bool) {
+	if len(times) < 2 {
+		return time.Time{}, false
+	}
+	mean, _ := averageInterval(times)
+	next := times[len(times)-1].Add(mean)
+	if next.Before(asOf) {
+		next = asOf
+	}
+	return next, true
+}
+
+// saleMonths counts sale starts by calendar month and returns the months that had
+// any, busiest first
+func saleMonths(starts []time.Time) []int {
+	counts := make(map[int]int)
+	for _, t := range starts {
+		counts[int(t.UTC().Month())]++
+	}
+	months := make([]int, 0, len(counts))
+	for m := range counts {
+		months = append(months, m)
+	}
+	slices.SortFunc(months, func(a, b int) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return a - b
+	})
+	return months
+}
+
+// nextSaleMonth returns the start of the first month from asOf's onwards in which
+// sales have started before, or asOf itself when that is this month
+func nextSaleMonth(months []int, asOf time.Time) (time.Time, bool) {
+	asOf = asOf.UTC()
+	for i := 0; i < 12; i++ {
+		month := time.Date(asOf.Year(), asOf.Month()+time.Month(i), 1, 0, 0, 0, 0, time.UTC)
+		if slices.Contains(months, int(month.Month())) {
+			if i == 0 {
+				return asOf, true
+			}
+			return month, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// categorySaleCache holds each category's sale days until the next ingest or
+// correction, since forecasts look them up for every product in the category
+var categorySaleCache = struct {
+	mu   sync.RWMutex
+	days map[string][]time.Time
+}{days: make(map[string][]time.Time)}
+
+// categorySaleEvents returns the days, oldest first, on which enough of a category's
+// products dropped in price at once to count as a category-wide sale
+func categorySaleEvents(categories []string) ([]time.Time, error) {
+	days := make(map[time.Time]bool)
+	for _, category := range categories {
+		categorySaleCache.mu.RLock()
+		saleDays, ok := categorySaleCache.days[category]
+		categorySaleCache.mu.RUnlock()
+		if !ok {
+			var err error
+			if saleDays, err = categorySaleDays(category); err != nil {
+				return nil, err
+			}
+			categorySaleCache.mu.Lock()
+			categorySaleCache.days[category] = saleDays
+			categorySaleCache.mu.Unlock()
+		}
+		for _, day := range saleDays {
+			days[day] = true
+		}
+	}
+
+	events := make([]time.Time, 0, len(days))
+	for day := range days {
+		events = append(events, day)
+	}
+	slices.SortFunc(events, time.Time.Compare)
+	return events, nil
+}
+
+// categorySaleDays returns the days on which enough of one category's products
+// dropped in price to count as a sale, in no particular order
+func categorySaleDays(category string) ([]time.Time, error) {
+	snapshot, err := latestSnapshot(category)
+	if err != nil {
+		return nil, err
+	}
+	threshold := max(categorySaleMinDrops, int(math.Ceil(categorySaleShare*float64(len(snapshot.Products)))))
+
+	drops, err := store.PriceChanges(PriceChangeFilter{Type: ChangeTypePrice, Direction: "down", Category: category})
+	if err != nil {
+		return nil, err
+	}
+	dropped := make(map[time.Time]map[string]bool)
+	for _, d := range drops {
+		day := bucketStart(d.Datetime, IntervalDay)
+		if dropped[day] == nil {
+			dropped[day] = make(map[string]bool)
+		}
+		dropped[day][d.ProductID] = true
+	}
+	days := []time.Time{}
+	for day, products := range dropped {
+		if len(products) >= threshold {
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+// forecastSale estimates a product's next sale from its own sale episodes and the
+// category-wide sale events of its categories, as of the newest scrape run. The
+// estimate is projected from the average interval between past sales, leaning on the
+// product's own history over its categories', and falls back to the next month in
+// which sales have started before. It returns nil with nothing to go on, or while the
+// newest episode is still on, since the next sale can't start before this one ends.
+func forecastSale(episodes []saleEpisode, events []time.Time, asOf time.Time) (*SaleForecast, ForecastBasis) {
+	starts := make([]time.Time, 0, len(episodes))
+	for _, e := range episodes {
+		starts = append(starts, e.Start)
+	}
+	basis := ForecastBasis{
+		SaleEpisodes:       len(episodes),
+		CategorySaleEvents: len(events),
+		SaleMonths:         saleMonths(append(slices.Clone(starts), events...)),
+	}
+
+	productNext, hasProduct := nextAfter(starts, asOf)
+	categoryNext, hasCategory := nextAfter(events, asOf)
+	mean, variation := averageInterval(starts)
+	if hasProduct {
+		days := roundTo(mean.Hours()/24, 1)
+		basis.AverageIntervalDays = &days
+	}
+	if hasCategory {
+		basis.NextCategorySale = formatDatetime(categoryNext)
+	}
+	if len(episodes) > 0 && episodes[len(episodes)-1].Ongoing {
+		return nil, basis
+	}
+
+	var next time.Time
+	switch {
+	case hasProduct && hasCategory:
+		next = productNext.Add(categoryNext.Sub(productNext) / 3)
+	case hasProduct:
+		next = productNext
+	case hasCategory:
+		next = categoryNext
+	default:
+		month, ok := nextSaleMonth(basis.SaleMonths, asOf)
+		if !ok {
+			return nil, basis
+		}
+		next = month
+	}
+
+	// Confidence grows with the number of past sales, how regular they were, whether
+	// the estimate falls in a month that has seen sales, and whether the product and
+	// its categories agree
+	score := 0.4 * float64(min(len(episodes), 4)) / 4
+	if hasProduct {
+		regularity := 0.5
+		if len(starts) > 2 {
+			regularity = max(0, 1-variation)
+		}
+		score += 0.3 * regularity
+	}
+	if slices.Contains(basis.SaleMonths, int(next.UTC().Month())) {
+		score += 0.15
+	}
+	if hasProduct && hasCategory && productNext.Sub(categoryNext).Abs() <= categoryAgreementWindow {
+		score += 0.15
+	}
+	score = roundTo(score, 2)
+	confidence := ConfidenceLow
+	switch {
+	case score >= 0.65:
+		confidence = ConfidenceHigh
+	case score >= 0.35:
+		confidence = ConfidenceMedium
+	}
+
+	forecast := &SaleForecast{
+		Date:            next.UTC().Format(time.DateOnly),
+		InDays:          int(math.Ceil(next.Sub(asOf).Hours() / 24)),
+		Confidence:      confidence,
+		ConfidenceScore: score,
+	}
+	if len(episodes) > 0 {
+		var lows float64
+		for _, e := range episodes {
+			lows += e.Low
+		}
+		price := roundTo(lows/float64(len(episodes)), 2)
+		forecast.EstimatedPrice = &price
+	}
+	return forecast, basis
+}
+
+// recommend decides between buying now and waiting for the next sale from the
+// product's regular and lowest prices
+func recommend(current, regular, lowest float64, next *SaleForecast) (string, string) {
+	switch {
+	case current <= lowest*(1+nearLowTolerance):
+		return RecommendBuyNow, fmt.Sprintf("The price is within %.0f%% of the lowest price recorded", nearLowTolerance*100)
+	case lowest >= regular:
+		return RecommendBuyNow, "The product has never been discounted"
+	case current < regular:
+		return RecommendBuyNow, fmt.Sprintf("The product is on sale at %.0f%% below its regular price", (regular-current)/regular*100)
+	case next != nil && time.Duration(next.InDays)*24*time.Hour <= forecastWaitWindow:
+		return RecommendWait, fmt.Sprintf("A sale is expected in about %d days, and it has been as low as %.2f", next.InDays, lowest)
+	default:
+		return RecommendBuyNow, fmt.Sprintf("No sale is expected in the next %.0f days", forecastWaitWindow.Hours()/24)
+	}
+}
+
+// getProductForecast estimates when a product will next go on sale and at what price,
+// and whether to buy it now or wait
+func getProductForecast(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Hidden products are withheld by an admin and look like they don't exist
+	hidden, err := store.IsHidden(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product"})
+		return
+	}
+	if hidden {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product datapoints"})
+		return
+	}
+	if len(history) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	stats, err := store.GetStats(productID)
+	if err != nil {
+		if err != ErrNotFound {
+			fmt.Printf("WARNING: stats scan failed for %s: %v, falling back to datapoints\n", productID, err)
+		}
+		stats = statsFromHistory(history)
+	}
+	times, err := store.ScrapeTimes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query scrape runs"})
+		return
+	}
+	latest := history[len(history)-1]
+	events, err := categorySaleEvents(latest.Categories)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query price changes"})
+		return
+	}
+
+	asOf := times[len(times)-1]
+	next, basis := forecastSale(saleEpisodes(history, stats.RegularPrice), events, asOf)
+	recommendation, reason := recommend(latest.Price, stats.RegularPrice, stats.LowestPrice, next)
+	c.JSON(http.StatusOK, ForecastResponse{
+		ProductID:      productID,
+		Name:           latest.Name,
+		AsOf:           formatTimestamp(asOf),
+		CurrentPrice:   latest.Price,
+		RegularPrice:   stats.RegularPrice,
+		LowestPrice:    stats.LowestPrice,
+		OnSale:         latest.Price < stats.RegularPrice,
+		NextSale:       next,
+		Basis:          basis,
+		Recommendation: recommendation,
+		Reason:         reason,
+	})
+}
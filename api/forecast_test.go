@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForecastSale(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	episodes := []saleEpisode{
+		{Start: day("2025-01-01"), End: day("2025-01-03"), Low: 19.90},
+		{Start: day("2025-01-31"), End: day("2025-02-02"), Low: 14.90},
+		{Start: day("2025-03-02"), End: day("2025-03-04"), Low: 19.90},
+	}
+
+	// Evenly spaced sales project one interval on from the last
+	next, basis := forecastSale(episodes, nil, day("2025-03-10"))
+	if next == nil || next.Date != "2025-04-01" || next.InDays != 22 {
+		t.Fatalf("expected a sale on 2025-04-01 in 22 days, got %+v", next)
+	}
+	if next.EstimatedPrice == nil || *next.EstimatedPrice != 18.23 {
+		t.Errorf("expected the average sale low of 18.23, got %v", next.EstimatedPrice)
+	}
+	if next.Confidence != ConfidenceMedium || next.ConfidenceScore != 0.6 {
+		t.Errorf("expected medium confidence of 0.6, got %s %v", next.Confidence, next.ConfidenceScore)
+	}
+	if basis.AverageIntervalDays == nil || *basis.AverageIntervalDays != 30 || basis.SaleEpisodes != 3 {
+		t.Errorf("unexpected basis %+v", basis)
+	}
+
+	// An overdue sale is expected straight away
+	next, _ = forecastSale(episodes, nil, day("2025-05-01"))
+	if next == nil || next.InDays != 0 {
+		t.Errorf("expected an overdue sale today, got %+v", next)
+	}
+
+	// A single sale only gives its month to go on
+	next, _ = forecastSale(episodes[1:2], nil, day("2025-03-10"))
+	if next == nil || next.Date != "2026-01-01" || next.Confidence != ConfidenceLow {
+		t.Errorf("expected a low confidence sale next January, got %+v", next)
+	}
+
+	// No forecast while a sale is still on, though it counts towards the basis
+	ongoing := append(episodes, saleEpisode{Start: day("2025-03-30"), End: day("2025-03-31"), Low: 19.90, Ongoing: true})
+	next, basis = forecastSale(ongoing, nil, day("2025-03-31"))
+	if next != nil || basis.SaleEpisodes != 4 {
+		t.Errorf("expected no forecast during a sale with 4 episodes in the basis, got %+v %+v", next, basis)
+	}
+
+	if next, _ := forecastSale(nil, nil, day("2025-03-10")); next != nil {
+		t.Errorf("expected no forecast without sales, got %+v", next)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	soon := &SaleForecast{InDays: 10}
+	tests := []struct {
+		name                     string
+		current, regular, lowest float64
+		next                     *SaleForecast
+		want                     string
+	}{
+		{"at its low", 20.40, 29.90, 19.90, soon, RecommendBuyNow},
+		{"never discounted", 29.90, 29.90, 29.90, soon, RecommendBuyNow},
+		{"on sale", 24.90, 29.90, 14.90, soon, RecommendBuyNow},
+		{"sale coming", 29.90, 29.90, 19.90, soon, RecommendWait},
+		{"sale far off", 29.90, 29.90, 19.90, &SaleForecast{InDays: 90}, RecommendBuyNow},
+		{"no forecast", 29.90, 29.90, 19.90, nil, RecommendBuyNow},
+	}
+	for _, tt := range tests {
+		if got, reason := recommend(tt.current, tt.regular, tt.lowest, tt.next); got != tt.want {
+			t.Errorf("%s: expected %s, got %s (%s)", tt.name, tt.want, got, reason)
+		}
+	}
+}
+
+func TestProductForecast(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// Four products in a category that all go on sale together twice, 30 days apart
+		runs := []struct {
+			datetime string
+			price    string
+		}{
+			{"2025-01-01T06:00:00Z", "29.90"},
+			{"2025-01-11T06:00:00Z", "19.90"},
+			{"2025-01-13T06:00:00Z", "29.90"},
+			{"2025-02-10T06:00:00Z", "19.90"},
+			{"2025-02-12T06:00:00Z", "29.90"},
+			{"2025-02-25T06:00:00Z", "29.90"},
+		}
+		for _, r := range runs {
+			products := map[string]string{"E100": r.price, "F100": r.price, "G100": r.price, "H100": r.price}
+			output := scrapeOutput(r.datetime, map[string]map[string]string{"men/tops": products})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var forecast ForecastResponse
+		if rec := get(t, router, "/api/v1/product/E100/forecast", &forecast); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if forecast.AsOf != "2025-02-25T06:00:00Z" || forecast.RegularPrice != 29.90 || forecast.LowestPrice != 19.90 {
+			t.Errorf("unexpected forecast %+v", forecast)
+		}
+		next := forecast.NextSale
+		if next == nil || next.Date != "2025-03-12" || next.InDays != 15 {
+			t.Fatalf("expected a sale on 2025-03-12, got %+v", next)
+		}
+		if next.EstimatedPrice == nil || *next.EstimatedPrice != 19.90 || next.Confidence != ConfidenceMedium {
+			t.Errorf("expected a medium confidence sale at 19.90, got %+v", next)
+		}
+		if forecast.Basis.CategorySaleEvents != 2 || forecast.Basis.NextCategorySale == nil {
+			t.Errorf("expected two category-wide sales, got %+v", forecast.Basis)
+		}
+		if forecast.Recommendation != RecommendWait {
+			t.Errorf("expected to wait for the sale, got %s: %s", forecast.Recommendation, forecast.Reason)
+		}
+
+		// The category's sale days are cached until the next ingest
+		products := map[string]string{"E100": "19.90", "F100": "19.90", "G100": "19.90", "H100": "19.90"}
+		output := scrapeOutput("2025-03-01T06:00:00Z", map[string]map[string]string{"men/tops": products})
+		if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/product/F100/forecast", &forecast)
+		if forecast.Basis.CategorySaleEvents != 3 {
+			t.Errorf("expected the new sale to count after ingest, got %+v", forecast.Basis)
+		}
+		if !forecast.OnSale || forecast.NextSale != nil || forecast.Recommendation != RecommendBuyNow {
+			t.Errorf("expected no forecast while the sale is on, got %+v", forecast)
+		}
+
+		if rec := get(t, router, "/api/v1/product/Z999/forecast", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown product, got %d", rec.Code)
+		}
+	})
+}
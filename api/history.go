@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Price history intervals accepted by the product endpoint
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// PriceStep is a stretch of a product's price history. Without an interval each
+// datapoint is its own bucket; with one, datapoints are grouped by day, week or month.
+// Consecutive buckets that all held the same single price are collapsed into one step,
+// so a price that never moved costs one entry however long the range. From and To are
+// the first and last datapoint in the step.
+type PriceStep struct {
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	Last       float64 `json:"last"`
+	Datapoints int     `json:"datapoints"`
+}
+
+// HistoryRange is the slice of history a product response covers. From and To are
+// null when the range is open on that side.
+type HistoryRange struct {
+	From     *string `json:"from"`
+	To       *string `json:"to"`
+	Interval string  `json:"interval,omitempty"`
+}
+
+// historyQuery is a parsed from/to/interval selection. Zero times leave the range open.
+type historyQuery struct {
+	from     time.Time
+	to       time.Time
+	interval string
+}
+
+// parseHistoryQuery validates the from, to and interval query parameters. A YYYY-MM-DD
+// to includes that whole day.
+func parseHistoryQuery(from, to, interval string) (historyQuery, error) {
+	var q historyQuery
+	if from != "" {
+		start, _, err := parseTimeWindow(from)
+		if err != nil {
+			return q, fmt.Errorf("from: %w", err)
+		}
+		q.from = start
+	}
+	if to != "" {
+		_, end, err := parseTimeWindow(to)
+		if err != nil {
+			return q, fmt.Errorf("to: %w", err)
+		}
+		q.to = end
+	}
+	if !q.from.IsZero() && !q.to.IsZero() && !q.from.Before(q.to) {
+		return q, fmt.Errorf("from must be before to")
+	}
+	switch interval {
+	case "", IntervalDay, IntervalWeek, IntervalMonth:
+		q.interval = interval
+	default:
+		return q, fmt.Errorf("interval must be one of day, week, month")
+	}
+	return q, nil
+}
+
+// isZero reports whether the query selects the full, unbucketed history
+func (q historyQuery) isZero() bool {
+	return q.from.IsZero() && q.to.IsZero() && q.interval == ""
+}
+
+// contains reports whether t falls in the query's [from, to) range
+func (q historyQuery) contains(t time.Time) bool {
+	return (q.from.IsZero() || !t.Before(q.from)) && (q.to.IsZero() || t.Before(q.to))
+}
+
+// response describes the query for a product response
+func (q historyQuery) response() HistoryRange {
+	return HistoryRange{From: formatDatetime(q.from), To: formatDatetime(q.to), Interval: q.interval}
+}
+
+// bucketStart returns the start of the UTC day, ISO week or month t falls in
+func bucketStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case IntervalWeek:
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// priceSteps buckets datapoints, oldest first, by interval and collapses runs of
+// unchanged prices. An empty interval buckets each datapoint on its own.
+func priceSteps(history []ProductRecord, interval string) []PriceStep {
+	var buckets []PriceStep
+	var currentBucket time.Time
+	for _, r := range history {
+		datetime := formatTimestamp(r.Datetime)
+		if interval != "" && len(buckets) > 0 && bucketStart(r.Datetime, interval).Equal(currentBucket) {
+			b := &buckets[len(buckets)-1]
+			b.To = datetime
+			b.Min = min(b.Min, r.Price)
+			b.Max = max(b.Max, r.Price)
+			b.Last = r.Price
+			b.Datapoints++
+			continue
+		}
+		buckets = append(buckets, PriceStep{From: datetime, To: datetime, Min: r.Price, Max: r.Price, Last: r.Price, Datapoints: 1})
+		if interval != "" {
+			currentBucket = bucketStart(r.Datetime, interval)
+		}
+	}
+
+	steps := []PriceStep{}
+	for _, b := range buckets {
+		if n := len(steps); n > 0 {
+			prev := &steps[n-1]
+			if samePrice(prev.Min, prev.Max) && samePrice(b.Min, b.Max) && samePrice(prev.Last, b.Last) {
+				prev.To = b.To
+				prev.Datapoints += b.Datapoints
+				continue
+			}
+		}
+		steps = append(steps, b)
+	}
+	return steps
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyPrices is a product's price by scrape time, used by the history tests
+var historyPrices = []struct {
+	datetime string
+	price    string
+}{
+	{"2025-01-06T06:00:00Z", "29.90"},
+	{"2025-01-06T18:00:00Z", "29.90"},
+	{"2025-01-07T06:00:00Z", "29.90"},
+	{"2025-01-08T06:00:00Z", "19.90"},
+	{"2025-01-08T18:00:00Z", "24.90"},
+	{"2025-01-09T06:00:00Z", "24.90"},
+	{"2025-02-03T06:00:00Z", "24.90"},
+}
+
+func TestPriceSteps(t *testing.T) {
+	var history []ProductRecord
+	for _, p := range historyPrices {
+		datetime, _ := time.Parse(time.RFC3339, p.datetime)
+		price := map[string]float64{"29.90": 29.90, "24.90": 24.90, "19.90": 19.90}[p.price]
+		history = append(history, ProductRecord{ProductID: "E100", Price: price, Datetime: datetime})
+	}
+
+	tests := []struct {
+		interval string
+		want     []PriceStep
+	}{
+		{"", []PriceStep{
+			{From: "2025-01-06T06:00:00Z", To: "2025-01-07T06:00:00Z", Min: 29.90, Max: 29.90, Last: 29.90, Datapoints: 3},
+			{From: "2025-01-08T06:00:00Z", To: "2025-01-08T06:00:00Z", Min: 19.90, Max: 19.90, Last: 19.90, Datapoints: 1},
+			{From: "2025-01-08T18:00:00Z", To: "2025-02-03T06:00:00Z", Min: 24.90, Max: 24.90, Last: 24.90, Datapoints: 3},
+		}},
+		{IntervalDay, []PriceStep{
+			{From: "2025-01-06T06:00:00Z", To: "2025-01-07T06:00:00Z", Min: 29.90, Max: 29.90, Last: 29.90, Datapoints: 3},
+			{From: "2025-01-08T06:00:00Z", To: "2025-01-08T18:00:00Z", Min: 19.90, Max: 24.90, Last: 24.90, Datapoints: 2},
+			{From: "2025-01-09T06:00:00Z", To: "2025-02-03T06:00:00Z", Min: 24.90, Max: 24.90, Last: 24.90, Datapoints: 2},
+		}},
+		{IntervalWeek, []PriceStep{
+			{From: "2025-01-06T06:00:00Z", To: "2025-01-09T06:00:00Z", Min: 19.90, Max: 29.90, Last: 24.90, Datapoints: 6},
+			{From: "2025-02-03T06:00:00Z", To: "2025-02-03T06:00:00Z", Min: 24.90, Max: 24.90, Last: 24.90, Datapoints: 1},
+		}},
+		{IntervalMonth, []PriceStep{
+			{From: "2025-01-06T06:00:00Z", To: "2025-01-09T06:00:00Z", Min: 19.90, Max: 29.90, Last: 24.90, Datapoints: 6},
+			{From: "2025-02-03T06:00:00Z", To: "2025-02-03T06:00:00Z", Min: 24.90, Max: 24.90, Last: 24.90, Datapoints: 1},
+		}},
+	}
+	for _, tt := range tests {
+		got := priceSteps(history, tt.interval)
+		if len(got) != len(tt.want) {
+			t.Errorf("interval %q: expected %d steps, got %+v", tt.interval, len(tt.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("interval %q step %d: expected %+v, got %+v", tt.interval, i, tt.want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestProductHistoryRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for _, p := range historyPrices {
+			output := scrapeOutput(p.datetime, map[string]map[string]string{"men/tops": {"E100": p.price}})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var full ProductDetailResponse
+		get(t, router, "/api/v1/product/E100", &full)
+		if len(full.Datapoints) != 7 || full.History != nil || full.Range.From != nil || full.Range.To != nil {
+			t.Fatalf("expected every datapoint and no steps by default, got %d datapoints, %d steps, range %+v", len(full.Datapoints), len(full.History), full.Range)
+		}
+
+		var ranged ProductDetailResponse
+		get(t, router, "/api/v1/product/E100?from=2025-01-08&to=2025-01-09", &ranged)
+		if ranged.Datapoints != nil || len(ranged.History) != 2 || ranged.History[1].Datapoints != 2 {
+			t.Errorf("expected only the 2 steps on the 8th and 9th, got %+v and %+v", ranged.Datapoints, ranged.History)
+		}
+		if ranged.Range.To == nil || *ranged.Range.To != "2025-01-10T00:00:00Z" {
+			t.Errorf("expected to to include the whole day, got %+v", ranged.Range)
+		}
+		// Stats still cover the whole history
+		if ranged.HighestPrice.HighestPrice != 29.90 || ranged.CurrentPrice != 24.90 {
+			t.Errorf("expected stats over the whole history, got %+v", ranged)
+		}
+
+		var weekly ProductDetailResponse
+		get(t, router, "/api/v1/product/E100?interval=week", &weekly)
+		if weekly.Datapoints != nil || len(weekly.History) != 2 || weekly.Range.Interval != IntervalWeek {
+			t.Errorf("expected two weekly steps and no datapoints, got %+v", weekly)
+		}
+
+		// A range with no datapoints still has an empty history
+		rec := get(t, router, "/api/v1/product/E100?from=2024-01-01&to=2024-01-31", nil)
+		if body := rec.Body.String(); !strings.Contains(body, `"history":[]`) || strings.Contains(body, `"datapoints"`) {
+			t.Errorf("expected an empty history and no datapoints, got %s", body)
+		}
+
+		for _, query := range []string{"interval=hour", "from=2025-02-01&to=2025-01-01", "from=last-week"} {
+			if rec := get(t, router, "/api/v1/product/E100?"+query, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", query, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IngestError is a failed ingest, carrying the HTTP status the handler responds with
+type IngestError struct {
+	Status  int
+	Message string
+	Details string
+}
+
+func (e *IngestError) Error() string {
+	if e.Details != "" {
+		return e.Message + ": " + e.Details
+	}
+	return e.Message
+}
+
+// scrapeDatetime returns when a scrape was taken, from its metadata. Archives from
+// scrapers that predate the datetime field, or with a datetime that doesn't parse, are
+// stamped with the time of ingest.
+func scrapeDatetime(metadata ScraperMetadata) time.Time {
+	if metadata.Datetime == "" {
+		return time.Now()
+	}
+	date, err := time.Parse(time.RFC3339, metadata.Datetime)
+	if err != nil {
+		fmt.Printf("WARNING: invalid metadata datetime %q, using the time of ingest: %v\n", metadata.Datetime, err)
+		return time.Now()
+	}
+	return date
+}
+
+// ingestArchive loads a scraper ZIP (prices.json plus images) into the store. It is
+// shared by the ingest endpoint and the `ingest` and `restore` subcommands. Progress
+// is broadcast to /api/v1/events. With guard set, a scrape that looks broken next to
+// the previous run is quarantined instead and a *QuarantinedError returned. A non-zero
+// datetime dates the scrape instead of its metadata, so an approved upload keeps the
+// time it was quarantined with.
+func ingestArchive(archive []byte, guard bool, datetime time.Time) (IngestResponse, error) {
+	response, err := loadArchive(archive, guard, datetime)
+	var quarantined *QuarantinedError
+	if errors.As(err, &quarantined) {
+		liveEvents.publish(EventIngestQuarantined, IngestEvent{Datetime: formatTimestamp(quarantined.Scrape.Datetime), Total: quarantined.Scrape.Products, Error: err.Error()})
+	} else if err != nil {
+		liveEvents.publish(EventIngestFailed, IngestEvent{Error: err.Error()})
+	}
+	return response, err
+}
+
+// loadArchive does the work of ingestArchive
+func loadArchive(archive []byte, guard bool, datetime time.Time) (IngestResponse, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return IngestResponse{}, &IngestError{Status: http.StatusBadRequest, Message: "Invalid ZIP file"}
+	}
+
+	var scraperOutput ScraperOutput
+	images := map[string]*zip.File{}
+	foundPrices := false
+
+	for _, f := range zipReader.File {
+		if f.Name == "prices.json" {
+			rc, err := f.Open()
+			if err != nil {
+				return IngestResponse{}, &IngestError{Status: http.StatusInternalServerError, Message: "Failed to open prices.json"}
+			}
+
+			if err := json.NewDecoder(rc).Decode(&scraperOutput); err != nil {
+				rc.Close()
+				return IngestResponse{}, &IngestError{Status: http.StatusBadRequest, Message: "Failed to parse prices.json"}
+			}
+			rc.Close()
+			foundPrices = true
+		} else {
+			images[f.Name] = f
+		}
+	}
+
+	if !foundPrices {
+		return IngestResponse{}, &IngestError{Status: http.StatusBadRequest, Message: "prices.json not found in ZIP"}
+	}
+
+	// Consolidate products by product_id across categories
+	type ConsolidatedProduct struct {
+		Product    Product
+		Categories []string
+		Price      string
+	}
+	consolidated := map[string]*ConsolidatedProduct{}
+
+	for category, categoryProducts := range scraperOutput.Products {
+		for _, product := range categoryProducts {
+			if existing, ok := consolidated[product.ProductID]; ok {
+				existing.Categories = append(existing.Categories, category)
+			} else {
+				price, ok := strings.CutPrefix(product.Price, "CA $ ")
+				if !ok {
+					fmt.Printf("%s %q - WARNING price without the CA $ prefix\n", product.ProductID, product.Price)
+					continue
+				}
+				consolidated[product.ProductID] = &ConsolidatedProduct{
+					Product:    product,
+					Categories: []string{category},
+					Price:      price,
+				}
+			}
+		}
+	}
+
+	// Wait for DB to be ready — handles the case where Postgres is still recovering
+	// from a crash when this request arrives (e.g. from a GH Actions run).
+	if err := waitForDB(5); err != nil {
+		return IngestResponse{}, &IngestError{Status: http.StatusServiceUnavailable, Message: "Database unavailable, try again later", Details: err.Error()}
+	}
+
+	// Inject consolidated products into the database
+	count := 0
+	total := len(consolidated)
+	date := datetime
+	if date.IsZero() {
+		date = scrapeDatetime(scraperOutput.Metadata)
+	}
+
+	if guard {
+		incoming := make([]ProductRecord, 0, len(consolidated))
+		for id, cp := range consolidated {
+			if price, err := strconv.ParseFloat(cp.Price, 64); err == nil {
+				incoming = append(incoming, ProductRecord{ProductID: id, Price: price, Categories: cp.Categories})
+			}
+		}
+		categories := make(map[string]int)
+		for _, category := range scraperOutput.Metadata.Categories {
+			categories[category] = 0
+		}
+		for category, products := range scraperOutput.Products {
+			categories[category] = len(products)
+		}
+		anomalies, err := checkScrape(date, incoming, categories)
+		if err != nil {
+			return IngestResponse{}, &IngestError{Status: http.StatusInternalServerError, Message: "Failed to check scrape for anomalies", Details: err.Error()}
+		}
+		if len(anomalies) > 0 {
+			return IngestResponse{}, quarantineScrape(archive, scraperOutput.Metadata, date, total, anomalies)
+		}
+	}
+
+	fmt.Printf("Ingesting %d products...\n", total)
+	scraped := formatTimestamp(date)
+	liveEvents.publish(EventIngestStarted, IngestEvent{Datetime: scraped, Total: total})
+
+	for _, cp := range consolidated {
+		if count > 0 && count%ingestProgressEvery == 0 {
+			liveEvents.publish(EventIngestProgress, IngestEvent{Datetime: scraped, Count: count, Total: total})
+		}
+		count++
+
+		priceFloat, err := strconv.ParseFloat(cp.Price, 64)
+		if err != nil {
+			fmt.Printf("[%d/%d] %s $%s - WARNING bad price\n", count, total, cp.Product.ProductID, cp.Price)
+			continue
+		}
+
+		record := ProductRecord{
+			ProductID:  cp.Product.ProductID,
+			Name:       cp.Product.Name,
+			Price:      priceFloat,
+			URL:        cp.Product.URL,
+			Categories: cp.Categories,
+			Datetime:   date,
+		}
+		if err := store.InsertProduct(record); err != nil {
+			return IngestResponse{}, &IngestError{Status: http.StatusInternalServerError, Message: "Failed to insert product into database", Details: err.Error()}
+		}
+
+		// Update stats table with lowest price tracking
+		if err := store.UpdateStats(cp.Product.ProductID, priceFloat, date); err != nil {
+			fmt.Printf("[%d/%d] %s $%s - WARNING stats failed: %v\n", count, total, cp.Product.ProductID, cp.Price, err)
+		}
+
+		// Track when the product was first and last seen
+		if err := store.UpdateLifecycle(record); err != nil {
+			fmt.Printf("[%d/%d] %s $%s - WARNING lifecycle failed: %v\n", count, total, cp.Product.ProductID, cp.Price, err)
+		}
+
+		// A backfilled scrape keeps the image of the product's newer datapoints
+		if lifecycle, err := store.GetLifecycle(cp.Product.ProductID); err == nil && date.Before(lifecycle.LastSeen) {
+			fmt.Printf("[%d/%d] %s $%s OK (backfilled, image kept)\n", count, total, cp.Product.ProductID, cp.Price)
+			continue
+		}
+
+		// Save image to database
+		imageFile, ok := images[cp.Product.Image]
+		if !ok {
+			fmt.Printf("[%d/%d] %s $%s - no image\n", count, total, cp.Product.ProductID, cp.Price)
+			continue
+		}
+
+		rc, err := imageFile.Open()
+		if err != nil {
+			fmt.Printf("[%d/%d] %s $%s - image read error\n", count, total, cp.Product.ProductID, cp.Price)
+			continue
+		}
+
+		imageBytes, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			fmt.Printf("[%d/%d] %s $%s - image read error\n", count, total, cp.Product.ProductID, cp.Price)
+			continue
+		}
+
+		if err := store.SaveImage(cp.Product.ProductID, imageBytes); err != nil {
+			fmt.Printf("[%d/%d] %s $%s - image save error: %v\n", count, total, cp.Product.ProductID, cp.Price, err)
+			continue
+		}
+
+		fmt.Printf("[%d/%d] %s $%s OK\n", count, total, cp.Product.ProductID, cp.Price)
+	}
+
+	// Extended stats look at each product's whole history, so they're done in one pass
+	if err := updateRunExtendedStats(date); err != nil {
+		fmt.Printf("WARNING: failed to update extended stats: %v\n", err)
+	}
+
+	// Insert scraper run metadata into scraper table
+	_, err = store.InsertScraperRun(ScraperRun{
+		Datetime:          date,
+		ScraperVersion:    scraperOutput.Metadata.ScraperVersion,
+		TotalProducts:     scraperOutput.Metadata.TotalProducts,
+		TotalFailed:       scraperOutput.Metadata.TotalFailed,
+		CategoriesScraped: scraperOutput.Metadata.CategoriesScraped,
+		Categories:        scraperOutput.Metadata.Categories,
+		DurationSeconds:   scraperOutput.Metadata.DurationSeconds,
+		CategoryCounts:    categoryCounts(scraperOutput),
+	})
+	if err != nil {
+		fmt.Printf("WARNING: failed to insert scraper stats: %v\n", err)
+	}
+
+	// Upsert each category into the categories table
+	for _, category := range scraperOutput.Metadata.Categories {
+		if err := store.AddCategory(category); err != nil {
+			fmt.Printf("WARNING: failed to insert category %q: %v\n", category, err)
+		}
+	}
+
+	// Record what changed since the previous run, and refresh the next run's events in
+	// case this scrape was backfilled between two others
+	if err := rebuildRunChanges(date); err != nil {
+		fmt.Printf("WARNING: failed to record price changes: %v\n", err)
+	}
+
+	// Every product's missed runs move on with a new run, so statuses are refreshed
+	if err := refreshLifecycles(); err != nil {
+		fmt.Printf("WARNING: failed to refresh product lifecycles: %v\n", err)
+	}
+
+	// Invalidate caches after ingesting new data
+	invalidateCaches()
+
+	// Compute the site-wide summary now rather than on the next request
+	if _, err := refreshSummary(); err != nil {
+		fmt.Printf("WARNING: %v\n", err)
+	}
+
+	liveEvents.publish(EventIngestCompleted, IngestEvent{Datetime: scraped, Count: count, Total: total, Categories: len(scraperOutput.Products)})
+	publishRunChanges(date)
+
+	return IngestResponse{
+		Message:    "Products ingested successfully",
+		Count:      count,
+		Categories: len(scraperOutput.Products),
+		Metadata:   scraperOutput.Metadata,
+	}, nil
+}
+
+// injestProducts accepts a ZIP file and extracts product data
+func injestProducts(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer src.Close()
+
+	fileBytes, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	response, err := ingestArchive(fileBytes, true, time.Time{})
+	var quarantined *QuarantinedError
+	if errors.As(err, &quarantined) {
+		c.JSON(http.StatusAccepted, IngestQuarantinedResponse{Message: "Scrape quarantined for review", Quarantine: newQuarantinedScrapeInfo(quarantined.Scrape)})
+		return
+	}
+	var ingestErr *IngestError
+	if errors.As(err, &ingestErr) {
+		body := gin.H{"error": ingestErr.Message}
+		if ingestErr.Details != "" {
+			body["details"] = ingestErr.Details
+		}
+		c.JSON(ingestErr.Status, body)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ingest products", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
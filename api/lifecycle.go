@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// newProductWindow is how long after it first appears a product counts as new
+	newProductWindow = 7 * 24 * time.Hour
+	// discontinuedAfterRuns is how many consecutive scrape runs a product must be
+	// missing from before it counts as discontinued rather than missing
+	discontinuedAfterRuns = 3
+)
+
+// LifecycleProductInfo is a product listed by the new and discontinued endpoints.
+// Price is the last price it was seen at.
+type LifecycleProductInfo struct {
+	ProductID  string   `json:"product_id"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Categories []string `json:"categories"`
+	Price      float64  `json:"price"`
+	FirstSeen  string   `json:"first_seen"`
+	LastSeen   string   `json:"last_seen"`
+	MissedRuns int      `json:"missed_runs"`
+	Status     string   `json:"status"`
+}
+
+// LifecycleProductsResponse is the body returned by the new and discontinued endpoints
+type LifecycleProductsResponse struct {
+	Status   string                 `json:"status"`
+	Count    int                    `json:"count"`
+	Products []LifecycleProductInfo `json:"products"`
+}
+
+// lifecycleStatus counts the scrape runs since the product was last seen that covered
+// one of its categories, so a partial scrape doesn't count against products it never
+// looked for, and derives its status. runs are oldest first. Products from the very
+// first run are never new, since there was nothing to be new relative to.
+func lifecycleStatus(l ProductLifecycle, runs []coveredRun) (int, string) {
+	if len(runs) == 0 {
+		return 0, LifecycleActive
+	}
+	missed := 0
+	for _, run := range runs {
+		if run.Datetime.After(l.LastSeen) && run.covers(l.Categories) {
+			missed++
+		}
+	}
+	switch {
+	case missed >= discontinuedAfterRuns:
+		return missed, LifecycleDiscontinued
+	case missed > 0:
+		return missed, LifecycleMissing
+	case l.FirstSeen.After(runs[0].Datetime) && runs[len(runs)-1].Datetime.Sub(l.FirstSeen) < newProductWindow:
+		return 0, LifecycleNew
+	default:
+		return 0, LifecycleActive
+	}
+}
+
+// refreshLifecycles recomputes the missed runs and status of every product after the
+// set of scrape runs changed, writing back only the rows that moved
+func refreshLifecycles() error {
+	runs, err := coveredRuns()
+	if err != nil {
+		return err
+	}
+	lifecycles, err := store.Lifecycles("")
+	if err != nil {
+		return err
+	}
+	var changed []ProductLifecycle
+	for _, l := range lifecycles {
+		missed, status := lifecycleStatus(l, runs)
+		if missed != l.MissedRuns || status != l.Status {
+			l.MissedRuns, l.Status = missed, status
+			changed = append(changed, l)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return store.PutLifecycles(changed)
+}
+
+// lifecycleFromHistory builds a product's lifecycle from its datapoints, oldest first.
+// MissedRuns and Status are left for refreshLifecycles.
+func lifecycleFromHistory(history []ProductRecord) ProductLifecycle {
+	first, last := history[0], history[len(history)-1]
+	return ProductLifecycle{
+		ProductID:  last.ProductID,
+		Name:       last.Name,
+		URL:        last.URL,
+		Categories: last.Categories,
+		Price:      last.Price,
+		FirstSeen:  first.Datetime,
+		LastSeen:   last.Datetime,
+		Status:     LifecycleActive,
+	}
+}
+
+// rebuildLifecycle recomputes a product's first and last seen from its history,
+// dropping the row when no datapoints remain. Its status is left for refreshLifecycles.
+func rebuildLifecycle(productID string) error {
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return store.DeleteLifecycle(productID)
+	}
+	return store.PutLifecycles([]ProductLifecycle{lifecycleFromHistory(history)})
+}
+
+// recomputeLifecycles rebuilds the lifecycles of products whose datapoints were
+// corrected, then refreshes every status in case a whole run was removed
+func recomputeLifecycles(productIDs ...string) error {
+	for _, id := range productIDs {
+		if err := rebuildLifecycle(id); err != nil {
+			return err
+		}
+	}
+	return refreshLifecycles()
+}
+
+// newLifecycleProductInfo converts a stored lifecycle to its response form
+func newLifecycleProductInfo(l ProductLifecycle) LifecycleProductInfo {
+	return LifecycleProductInfo{
+		ProductID:  l.ProductID,
+		Name:       l.Name,
+		URL:        l.URL,
+		Categories: l.Categories,
+		Price:      l.Price,
+		FirstSeen:  formatTimestamp(l.FirstSeen),
+		LastSeen:   formatTimestamp(l.LastSeen),
+		MissedRuns: l.MissedRuns,
+		Status:     l.Status,
+	}
+}
+
+// visibleLifecycles returns the lifecycles with a status, or every status when empty,
+// leaving out hidden products and, when category is set, products outside it
+func visibleLifecycles(status, category string) ([]ProductLifecycle, error) {
+	lifecycles, err := store.Lifecycles(status)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := store.HiddenProducts()
+	if err != nil {
+		return nil, err
+	}
+	hiddenIDs := make(map[string]bool, len(hidden))
+	for _, h := range hidden {
+		hiddenIDs[h.ProductID] = true
+	}
+	return slices.DeleteFunc(lifecycles, func(l ProductLifecycle) bool {
+		return hiddenIDs[l.ProductID] || (category != "" && !slices.Contains(l.Categories, category))
+	}), nil
+}
+
+// listLifecycleProducts responds with the visible products in a lifecycle status,
+// optionally limited to one category, ordered by the time returned by key, newest first
+func listLifecycleProducts(c *gin.Context, status string, key func(ProductLifecycle) time.Time) {
+	lifecycles, err := visibleLifecycles(status, c.Query("category"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query products"})
+		return
+	}
+	slices.SortStableFunc(lifecycles, func(a, b ProductLifecycle) int {
+		if c := key(b).Compare(key(a)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ProductID, b.ProductID)
+	})
+
+	response := LifecycleProductsResponse{Status: status, Count: len(lifecycles), Products: make([]LifecycleProductInfo, 0, len(lifecycles))}
+	for _, l := range lifecycles {
+		response.Products = append(response.Products, newLifecycleProductInfo(l))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getNewProducts returns the products first seen in the past week, newest first
+func getNewProducts(c *gin.Context) {
+	listLifecycleProducts(c, LifecycleNew, func(l ProductLifecycle) time.Time { return l.FirstSeen })
+}
+
+// getDiscontinuedProducts returns the products missing from the last few scrape runs,
+// most recently seen first
+func getDiscontinuedProducts(c *gin.Context) {
+	listLifecycleProducts(c, LifecycleDiscontinued, func(l ProductLifecycle) time.Time { return l.LastSeen })
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProductLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		adminKey := newTestKey(t, ScopeAdmin)
+		for _, output := range []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90", "B200": "29.90", "C300": "39.90"}}),
+			scrapeOutput("2025-01-09T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90", "B200": "24.90"}, "women/tops": {"D400": "9.90"}}),
+			scrapeOutput("2025-01-10T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90"}, "women/tops": {"D400": "9.90"}}),
+			scrapeOutput("2025-01-11T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90"}, "women/tops": {"D400": "9.90"}}),
+		} {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var fresh LifecycleProductsResponse
+		get(t, router, "/api/v1/products/new", &fresh)
+		if fresh.Count != 1 || fresh.Products[0].ProductID != "D400" || fresh.Products[0].FirstSeen != "2025-01-09T06:00:00Z" {
+			t.Fatalf("expected only D400 to be new, got %+v", fresh.Products)
+		}
+		get(t, router, "/api/v1/products/new?category=men/tops", &fresh)
+		if fresh.Count != 0 {
+			t.Errorf("expected no new products in men/tops, got %+v", fresh.Products)
+		}
+
+		var gone LifecycleProductsResponse
+		get(t, router, "/api/v1/products/discontinued", &gone)
+		if gone.Count != 1 {
+			t.Fatalf("expected only C300 to be discontinued, got %+v", gone.Products)
+		}
+		if c := gone.Products[0]; c.ProductID != "C300" || c.MissedRuns != 3 || c.LastSeen != "2025-01-01T06:00:00Z" || c.Price != 39.90 {
+			t.Errorf("unexpected discontinued product: %+v", c)
+		}
+
+		// B200 has only missed two runs and keeps its last seen price
+		var detail ProductDetailResponse
+		get(t, router, "/api/v1/product/B200", &detail)
+		if detail.Status != LifecycleMissing || detail.MissedRuns != 2 || detail.FirstSeen != "2025-01-01T06:00:00Z" || detail.LastSeen != "2025-01-09T06:00:00Z" {
+			t.Errorf("unexpected lifecycle for B200: %+v", detail)
+		}
+		get(t, router, "/api/v1/product/A100", &detail)
+		if detail.Status != LifecycleActive || detail.MissedRuns != 0 {
+			t.Errorf("expected A100 to be active, got %s with %d missed runs", detail.Status, detail.MissedRuns)
+		}
+
+		// Removing the latest run brings C300 back to missing
+		rec := do(t, router, http.MethodDelete, "/api/v1/admin/scrapes/2025-01-11", adminKey, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("delete scrape: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/products/discontinued", &gone)
+		if gone.Count != 0 {
+			t.Errorf("expected nothing discontinued after the delete, got %+v", gone.Products)
+		}
+
+		// A product reappearing is active again
+		back := scrapeOutput("2025-01-12T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90", "C300": "34.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, back, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/product/C300", &detail)
+		if detail.Status != LifecycleActive || detail.LastSeen != "2025-01-12T06:00:00Z" {
+			t.Errorf("expected C300 to be active again, got %+v", detail)
+		}
+
+		// Runs that only cover men/tops don't count against D400 in women/tops
+		for _, datetime := range []string{"2025-01-13T06:00:00Z", "2025-01-14T06:00:00Z"} {
+			partial := scrapeOutput(datetime, map[string]map[string]string{"men/tops": {"A100": "19.90"}})
+			if rec := ingest(t, router, buildScrapeZip(t, partial, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+		get(t, router, "/api/v1/product/D400", &detail)
+		if detail.MissedRuns != 0 || detail.Status != LifecycleNew {
+			t.Errorf("expected D400 to be unaffected by partial runs, got %s with %d missed runs", detail.Status, detail.MissedRuns)
+		}
+		get(t, router, "/api/v1/products/discontinued?category=men/tops", &gone)
+		if gone.Count != 1 || gone.Products[0].ProductID != "B200" || gone.Products[0].MissedRuns != 4 {
+			t.Errorf("expected B200 to be discontinued after four men/tops runs, got %+v", gone.Products)
+		}
+	})
+}
@@ -1,45 +1,61 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
-	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-
-	_ "github.com/lib/pq"
 )
 
 // ProductsCache holds cached products response with expiration
 type ProductsCache struct {
 	mu        sync.RWMutex
-	data      gin.H
+	data      *ProductsListResponse
 	expiresAt time.Time
 }
 
-// ProductDetailCache holds cached product detail responses keyed by product ID
+// ProductDetailCache holds cached product detail responses keyed by product ID and
+// history query
 type ProductDetailCache struct {
 	mu    sync.RWMutex
 	cache map[string]productCacheEntry
 }
 
+// put caches a response, evicting expired entries once the cache is full. If it is
+// still full afterwards the response is not cached.
+func (pc *ProductDetailCache) put(key string, response ProductDetailResponse) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	now := time.Now()
+	if len(pc.cache) >= maxProductDetailEntries {
+		for id, entry := range pc.cache {
+			if now.After(entry.expiresAt) {
+				delete(pc.cache, id)
+			}
+		}
+		if len(pc.cache) >= maxProductDetailEntries {
+			return
+		}
+	}
+	pc.cache[key] = productCacheEntry{data: response, expiresAt: now.Add(cacheDuration)}
+}
+
 type productCacheEntry struct {
-	data      gin.H
+	data      ProductDetailResponse
 	expiresAt time.Time
 }
 
 // Cache duration
 const cacheDuration = 1 * time.Hour
 
+// maxProductDetailEntries bounds the product detail cache so scans over many IDs can't grow it without limit
+const maxProductDetailEntries = 5000
+
 var productsCache = &ProductsCache{}
 var productDetailCache = &ProductDetailCache{cache: make(map[string]productCacheEntry)}
 
@@ -52,17 +68,21 @@ type Product struct {
 	Image     string `json:"image"`
 }
 
-// ProductResponse represents a product in the API response with price stats
+// ProductResponse represents a product in the API response with price stats. Stale
+// marks products whose category was missing from the newest scrape run. Stats is only
+// filled in when a list is requested with stats=true.
 type ProductResponse struct {
-	ProductID    string   `json:"product_id"`
-	Name         string   `json:"name"`
-	Price        float64  `json:"price"`
-	URL          string   `json:"url"`
-	Categories   []string `json:"categories"`
-	Datetime     string   `json:"datetime"`
-	LowestPrice  float64  `json:"lowest_price"`
-	RegularPrice float64  `json:"regular_price"`
-	IsAllTimeLow bool     `json:"is_all_time_low"`
+	ProductID    string             `json:"product_id"`
+	Name         string             `json:"name"`
+	Price        float64            `json:"price"`
+	URL          string             `json:"url"`
+	Categories   []string           `json:"categories"`
+	Datetime     string             `json:"datetime"`
+	LowestPrice  float64            `json:"lowest_price"`
+	RegularPrice float64            `json:"regular_price"`
+	IsAllTimeLow bool               `json:"is_all_time_low"`
+	Stale        bool               `json:"stale"`
+	Stats        *ExtendedStatsInfo `json:"stats,omitempty"`
 }
 
 // ProductDatapoint represents a single price datapoint for a product
@@ -84,21 +104,113 @@ type HighestPriceInfo struct {
 	Datetime     string  `json:"highest_price_datetime"`
 }
 
+// CategorySnapshotInfo reports which scrape run a category's products come from
+type CategorySnapshotInfo struct {
+	Category string `json:"category"`
+	Datetime string `json:"datetime"`
+	Stale    bool   `json:"stale"`
+}
+
+// ProductsListResponse is the body returned by the products endpoint. Datetime is the
+// newest scrape run.
+type ProductsListResponse struct {
+	Datetime   *string                `json:"datetime"`
+	Count      int                    `json:"count"`
+	Categories []CategorySnapshotInfo `json:"categories"`
+	Products   []ProductResponse      `json:"products"`
+}
+
+// CategoryProductsResponse is the body returned by the category endpoint. Datetime is
+// the scrape run the category's products come from.
+type CategoryProductsResponse struct {
+	Datetime *string           `json:"datetime"`
+	Category string            `json:"category"`
+	Stale    bool              `json:"stale"`
+	Count    int               `json:"count"`
+	Products []ProductResponse `json:"products"`
+}
+
+// ProductDetailResponse is the body returned by the single product endpoint. Without
+// a range or interval it has every Datapoint; with one it has History, the steps of
+// the requested Range, instead. The price stats, extended ones included, cover the
+// whole history.
+type ProductDetailResponse struct {
+	ProductID    string             `json:"product_id"`
+	Name         string             `json:"name"`
+	URL          string             `json:"url"`
+	Datapoints   []ProductDatapoint `json:"datapoints,omitzero"`
+	LowestPrice  LowestPriceInfo    `json:"lowest_price"`
+	HighestPrice HighestPriceInfo   `json:"highest_price"`
+	RegularPrice float64            `json:"regular_price"`
+	CurrentPrice float64            `json:"current_price"`
+	OnSale       bool               `json:"on_sale"`
+	IsAllTimeLow bool               `json:"is_all_time_low"`
+	FirstSeen    string             `json:"first_seen"`
+	LastSeen     string             `json:"last_seen"`
+	Status       string             `json:"status"`
+	MissedRuns   int                `json:"missed_runs"`
+	Stats        ExtendedStatsInfo  `json:"stats"`
+	Range        HistoryRange       `json:"range"`
+	History      []PriceStep        `json:"history,omitzero"`
+}
+
+// CategoriesResponse is the body returned by the categories endpoint
+type CategoriesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+// IngestResponse is the body returned after a successful ingest
+type IngestResponse struct {
+	Message    string          `json:"message"`
+	Count      int             `json:"count"`
+	Categories int             `json:"categories"`
+	Metadata   ScraperMetadata `json:"metadata"`
+}
+
+// ErrorResponse is the body returned by every failed request
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// ScraperMetadata describes a scraper run as reported in prices.json
+type ScraperMetadata struct {
+	Datetime          string                   `json:"datetime"`
+	ScraperVersion    string                   `json:"scraper_version"`
+	DurationSeconds   float64                  `json:"duration_seconds"`
+	TotalProducts     int                      `json:"total_products"`
+	TotalFailed       int                      `json:"total_failed"`
+	CategoriesScraped int                      `json:"categories_scraped"`
+	Categories        []string                 `json:"categories"`
+	CategoryCounts    map[string]CategoryCount `json:"category_counts,omitempty"`
+}
+
+// CategoryCount is how many products the scraper found in a category, and how many of
+// them it failed to scrape. Failed is -1 when the whole category failed.
+type CategoryCount struct {
+	Products int `json:"products"`
+	Failed   int `json:"failed"`
+}
+
 // ScraperOutput matches the structure from prices.json
 type ScraperOutput struct {
-	Metadata struct {
-		Datetime          string   `json:"datetime"`
-		ScraperVersion    string   `json:"scraper_version"`
-		DurationSeconds   float64  `json:"duration_seconds"`
-		TotalProducts     int      `json:"total_products"`
-		TotalFailed       int      `json:"total_failed"`
-		CategoriesScraped int      `json:"categories_scraped"`
-		Categories        []string `json:"categories"`
-	} `json:"metadata"`
+	Metadata ScraperMetadata      `json:"metadata"`
 	Products map[string][]Product `json:"products"`
 }
 
-var db *sql.DB
+// formatTimestamp formats a timestamp for API responses, always in UTC
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatDatetime formats a timestamp for a nullable datetime field
+func formatDatetime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	formatted := formatTimestamp(t)
+	return &formatted
+}
 
 // waitForDB pings the database with exponential backoff, allowing time for Postgres
 // to finish starting up or recovering from a crash before giving up.
@@ -106,7 +218,7 @@ func waitForDB(maxAttempts int) error {
 	backoff := 2 * time.Second
 	var lastErr error
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		if lastErr = db.Ping(); lastErr == nil {
+		if lastErr = store.Ping(); lastErr == nil {
 			return nil
 		}
 		fmt.Printf("DB ping failed (attempt %d/%d): %v — retrying in %s\n", attempt, maxAttempts, lastErr, backoff)
@@ -119,326 +231,38 @@ func waitForDB(maxAttempts int) error {
 	return fmt.Errorf("database unavailable after %d attempts: %w", maxAttempts, lastErr)
 }
 
-// initDB connects to PostgreSQL and creates tables if they don't exist
+// logOutput receives startup messages. Subcommands that write data to stdout point it at stderr.
+var logOutput io.Writer = os.Stdout
+
+// initDB connects to the database named by DATABASE_URL and applies pending migrations
 func initDB() error {
 	databaseURL := os.Getenv("DATABASE_URL")
 	if databaseURL == "" {
 		return fmt.Errorf("DATABASE_URL environment variable must be set")
 	}
 
-	var err error
-	db, err = sql.Open("postgres", databaseURL)
+	s, err := openStore(databaseURL)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
-	}
-
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS products (
-			product_id TEXT NOT NULL,
-			name TEXT NOT NULL,
-			price NUMERIC(10,2) NOT NULL,
-			url TEXT NOT NULL,
-			category JSONB NOT NULL,
-			datetime DATE NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS scraper (
-			datetime DATE NOT NULL,
-			scraper_version TEXT NOT NULL,
-			total_products INTEGER NOT NULL,
-			total_failed INTEGER NOT NULL,
-			categories_scraped INTEGER NOT NULL,
-			categories TEXT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS stats (
-			product_id TEXT NOT NULL UNIQUE,
-			lowest_price NUMERIC(10,2) NOT NULL,
-			lowest_price_datetime DATE NOT NULL,
-			highest_price NUMERIC(10,2) NOT NULL,
-			highest_price_datetime DATE NOT NULL,
-			regular_price NUMERIC(10,2) NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS images (
-			product_id TEXT NOT NULL UNIQUE,
-			image BYTEA NOT NULL,
-			last_updated DATE DEFAULT NOW()
-		)`,
-		`CREATE TABLE IF NOT EXISTS categories (
-			category TEXT NOT NULL UNIQUE
-		)`,
-	}
-
-	for _, q := range queries {
-		if _, err := db.Exec(q); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
+		return err
 	}
+	store = s
 
-	fmt.Println("Database initialized successfully")
-	return nil
-}
-
-// calculateRegularPrice calculates the mode (most frequent price) for a product
-func calculateRegularPrice(db *sql.DB, productID string) (float64, error) {
-	query := `
-		SELECT price, COUNT(*) as count
-		FROM products
-		WHERE product_id = $1
-		GROUP BY price
-		ORDER BY count DESC, price DESC
-		LIMIT 1
-	`
-	var regularPrice float64
-	var count int
-	err := db.QueryRow(query, productID).Scan(&regularPrice, &count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to calculate regular price: %w", err)
+	applied, err := store.Migrate()
+	for _, m := range applied {
+		fmt.Fprintf(logOutput, "Applied migration %d: %s\n", m.Version, m.Name)
 	}
-	return regularPrice, nil
-}
-
-// updateProductStats updates the stats table with lowest, highest, and regular price tracking
-// Case 1: Product doesn't exist -> insert with current price as lowest, highest, and regular
-// Case 2: Product exists -> update lowest if current < lowest, update highest if current > highest, recalculate regular
-func updateProductStats(db *sql.DB, productID string, currentPrice float64, datetime time.Time) error {
-	var lowestPrice, highestPrice float64
-	err := db.QueryRow("SELECT lowest_price, highest_price FROM stats WHERE product_id = $1", productID).Scan(&lowestPrice, &highestPrice)
-
-	if err == sql.ErrNoRows {
-		_, err := db.Exec(
-			"INSERT INTO stats (product_id, lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price) VALUES ($1, $2, $3, $4, $5, $6)",
-			productID, currentPrice, datetime, currentPrice, datetime, currentPrice,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert stats: %w", err)
-		}
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("failed to query stats: %w", err)
-	}
-
-	if currentPrice < lowestPrice {
-		_, err := db.Exec(
-			"UPDATE stats SET lowest_price = $1, lowest_price_datetime = $2 WHERE product_id = $3",
-			currentPrice, datetime, productID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to update lowest price: %w", err)
-		}
-	}
-
-	if currentPrice > highestPrice {
-		_, err := db.Exec(
-			"UPDATE stats SET highest_price = $1, highest_price_datetime = $2 WHERE product_id = $3",
-			currentPrice, datetime, productID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to update highest price: %w", err)
-		}
-	}
-
-	regularPrice, err := calculateRegularPrice(db, productID)
 	if err != nil {
-		return fmt.Errorf("failed to calculate regular price: %w", err)
-	}
-
-	_, err = db.Exec(
-		"UPDATE stats SET regular_price = $1 WHERE product_id = $2",
-		regularPrice, productID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update regular price: %w", err)
+		store.Close()
+		return err
 	}
 
+	fmt.Fprintln(logOutput, "Database initialized successfully")
 	return nil
 }
 
-// injestProducts accepts a ZIP file and extracts product data
-func injestProducts(c *gin.Context) {
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
-		return
-	}
-
-	src, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
-		return
-	}
-	defer src.Close()
-
-	fileBytes, err := io.ReadAll(src)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
-		return
-	}
-
-	zipReader, err := zip.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ZIP file"})
-		return
-	}
-
-	var scraperOutput ScraperOutput
-	images := map[string]*zip.File{}
-	foundPrices := false
-
-	for _, f := range zipReader.File {
-		if f.Name == "prices.json" {
-			rc, err := f.Open()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open prices.json"})
-				return
-			}
-
-			if err := json.NewDecoder(rc).Decode(&scraperOutput); err != nil {
-				rc.Close()
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse prices.json"})
-				return
-			}
-			rc.Close()
-			foundPrices = true
-		} else {
-			images[f.Name] = f
-		}
-	}
-
-	if !foundPrices {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "prices.json not found in ZIP"})
-		return
-	}
-
-	// Consolidate products by product_id across categories
-	type ConsolidatedProduct struct {
-		Product    Product
-		Categories []string
-		Price      string
-	}
-	consolidated := map[string]*ConsolidatedProduct{}
-
-	for category, categoryProducts := range scraperOutput.Products {
-		for _, product := range categoryProducts {
-			if existing, ok := consolidated[product.ProductID]; ok {
-				existing.Categories = append(existing.Categories, category)
-			} else {
-				price := strings.Split(product.Price, "CA $ ")[1]
-				consolidated[product.ProductID] = &ConsolidatedProduct{
-					Product:    product,
-					Categories: []string{category},
-					Price:      price,
-				}
-			}
-		}
-	}
-
-	// Wait for DB to be ready — handles the case where Postgres is still recovering
-	// from a crash when this request arrives (e.g. from a GH Actions run).
-	if err := waitForDB(5); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable, try again later", "details": err.Error()})
-		return
-	}
-
-	// Inject consolidated products into the database
-	count := 0
-	total := len(consolidated)
-	date := time.Now()
-
-	fmt.Printf("Ingesting %d products...\n", total)
-
-	for _, cp := range consolidated {
-		count++
-
-		categoriesJSON, err := json.Marshal(cp.Categories)
-		if err != nil {
-			fmt.Printf("[%d/%d] %s - ERROR marshaling categories: %v\n", count, total, cp.Product.ProductID, err)
-			continue
-		}
-
-		sqlStmt := "INSERT INTO products (product_id, name, price, url, category, datetime) VALUES ($1, $2, $3, $4, $5, $6)"
-		_, err = db.Exec(sqlStmt, cp.Product.ProductID, cp.Product.Name, cp.Price, cp.Product.URL, string(categoriesJSON), date)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert product into database", "details": err.Error()})
-			return
-		}
-
-		// Update stats table with lowest price tracking
-		priceFloat, err := strconv.ParseFloat(cp.Price, 64)
-		if err != nil {
-			fmt.Printf("[%d/%d] %s $%s - WARNING bad price\n", count, total, cp.Product.ProductID, cp.Price)
-		} else {
-			if err := updateProductStats(db, cp.Product.ProductID, priceFloat, date); err != nil {
-				fmt.Printf("[%d/%d] %s $%s - WARNING stats failed: %v\n", count, total, cp.Product.ProductID, cp.Price, err)
-			}
-		}
-
-		// Save image to database
-		imageFile, ok := images[cp.Product.Image]
-		if !ok {
-			fmt.Printf("[%d/%d] %s $%s - no image\n", count, total, cp.Product.ProductID, cp.Price)
-			continue
-		}
-
-		rc, err := imageFile.Open()
-		if err != nil {
-			fmt.Printf("[%d/%d] %s $%s - image read error\n", count, total, cp.Product.ProductID, cp.Price)
-			continue
-		}
-
-		imageBytes, err := io.ReadAll(rc)
-		rc.Close()
-		if err != nil {
-			fmt.Printf("[%d/%d] %s $%s - image read error\n", count, total, cp.Product.ProductID, cp.Price)
-			continue
-		}
-
-		_, err = db.Exec(
-			`INSERT INTO images (product_id, image) VALUES ($1, $2)
-			 ON CONFLICT (product_id) DO UPDATE SET image = EXCLUDED.image, last_updated = NOW()`,
-			cp.Product.ProductID, imageBytes,
-		)
-		if err != nil {
-			fmt.Printf("[%d/%d] %s $%s - image save error: %v\n", count, total, cp.Product.ProductID, cp.Price, err)
-			continue
-		}
-
-		fmt.Printf("[%d/%d] %s $%s OK\n", count, total, cp.Product.ProductID, cp.Price)
-	}
-
-	// Insert scraper run metadata into scraper table
-	categoriesStr := strings.Join(scraperOutput.Metadata.Categories, ",")
-	scraperDatetime, err := time.Parse(time.RFC3339, scraperOutput.Metadata.Datetime)
-	if err != nil {
-		scraperDatetime = date
-	}
-	_, err = db.Exec(
-		"INSERT INTO scraper (datetime, scraper_version, total_products, total_failed, categories_scraped, categories) VALUES ($1, $2, $3, $4, $5, $6)",
-		scraperDatetime,
-		scraperOutput.Metadata.ScraperVersion,
-		scraperOutput.Metadata.TotalProducts,
-		scraperOutput.Metadata.TotalFailed,
-		scraperOutput.Metadata.CategoriesScraped,
-		categoriesStr,
-	)
-	if err != nil {
-		fmt.Printf("WARNING: failed to insert scraper stats: %v\n", err)
-	}
-
-	// Upsert each category into the categories table
-	for _, category := range scraperOutput.Metadata.Categories {
-		_, err := db.Exec(
-			"INSERT INTO categories (category) VALUES ($1) ON CONFLICT DO NOTHING",
-			category,
-		)
-		if err != nil {
-			fmt.Printf("WARNING: failed to insert category %q: %v\n", category, err)
-		}
-	}
-
-	// Invalidate caches after ingesting new data
+// invalidateCaches drops every cached products, product detail, category stats and
+// summary response
+func invalidateCaches() {
 	productsCache.mu.Lock()
 	productsCache.data = nil
 	productsCache.expiresAt = time.Time{}
@@ -448,90 +272,63 @@ func injestProducts(c *gin.Context) {
 	productDetailCache.cache = make(map[string]productCacheEntry)
 	productDetailCache.mu.Unlock()
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Products ingested successfully",
-		"count":      count,
-		"categories": len(scraperOutput.Products),
-		"metadata":   scraperOutput.Metadata,
-	})
+	categoryStatsCache.mu.Lock()
+	categoryStatsCache.entries = make(map[string]categoryStatsEntry)
+	categoryStatsCache.mu.Unlock()
+
+	dealsCache.mu.Lock()
+	dealsCache.entries = make(map[string]dealsEntry)
+	dealsCache.mu.Unlock()
+
+	categorySaleCache.mu.Lock()
+	categorySaleCache.days = make(map[string][]time.Time)
+	categorySaleCache.mu.Unlock()
+
+	summaryCache.mu.Lock()
+	summaryCache.data = nil
+	summaryCache.mu.Unlock()
 }
 
-// getProducts returns all products from the most recent scrape with their lowest prices
+// getProducts returns the latest products in every category with their lowest prices,
+// and their extended stats with stats=true
 func getProducts(c *gin.Context) {
+	withStats, err := includeStats(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stats must be true or false"})
+		return
+	}
+
 	// Check cache first
 	productsCache.mu.RLock()
 	if productsCache.data != nil && time.Now().Before(productsCache.expiresAt) {
 		cachedData := productsCache.data
 		productsCache.mu.RUnlock()
-		c.JSON(http.StatusOK, cachedData)
+		c.JSON(http.StatusOK, cachedData.withStats(withStats))
 		return
 	}
 	productsCache.mu.RUnlock()
 
-	// Get the newest datetime from products table
-	var newestDatetime sql.NullTime
-	err := db.QueryRow("SELECT MAX(datetime) FROM products").Scan(&newestDatetime)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get newest datetime"})
-		return
-	}
-
-	if !newestDatetime.Valid {
-		c.JSON(http.StatusOK, gin.H{"products": []ProductResponse{}, "datetime": nil})
-		return
-	}
-
-	// Query products with the newest datetime and join with stats for lowest_price and regular_price
-	query := `
-		SELECT
-			p.product_id,
-			p.name,
-			p.price,
-			p.url,
-			p.category,
-			p.datetime,
-			COALESCE(s.lowest_price, p.price) as lowest_price,
-			COALESCE(s.regular_price, p.price) as regular_price
-		FROM products p
-		LEFT JOIN stats s ON p.product_id = s.product_id
-		WHERE p.datetime = $1
-	`
-
-	rows, err := db.Query(query, newestDatetime.Time)
+	snapshot, err := latestSnapshot("")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query products"})
 		return
 	}
-	defer rows.Close()
 
-	var products []ProductResponse
-	for rows.Next() {
-		var p ProductResponse
-		var categoryJSON string
-		var datetime time.Time
-		err := rows.Scan(&p.ProductID, &p.Name, &p.Price, &p.URL, &categoryJSON, &datetime, &p.LowestPrice, &p.RegularPrice)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan product"})
-			return
-		}
-		p.Datetime = datetime.Format(time.RFC3339)
-		if err := json.Unmarshal([]byte(categoryJSON), &p.Categories); err != nil {
-			p.Categories = []string{categoryJSON}
-		}
-		p.IsAllTimeLow = p.Price <= p.LowestPrice && p.LowestPrice < p.RegularPrice
-		products = append(products, p)
+	products := snapshot.Products
+	if products == nil {
+		products = []ProductResponse{}
 	}
-
-	if err = rows.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating products"})
-		return
+	categories := make([]CategorySnapshotInfo, 0, len(snapshot.Categories))
+	for _, cs := range snapshot.Categories {
+		categories = append(categories, CategorySnapshotInfo{Category: cs.Category, Datetime: formatTimestamp(cs.Datetime), Stale: cs.Stale})
 	}
 
 	// Build response and cache it
-	response := gin.H{
-		"datetime": newestDatetime.Time.Format(time.RFC3339),
-		"count":    len(products),
-		"products": products,
+	response := &ProductsListResponse{
+		Datetime:   formatDatetime(snapshot.Datetime),
+		Count:      len(products),
+		Categories: categories,
+		Products:   products,
 	}
 
 	productsCache.mu.Lock()
@@ -539,7 +336,7 @@ func getProducts(c *gin.Context) {
 	productsCache.expiresAt = time.Now().Add(cacheDuration)
 	productsCache.mu.Unlock()
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, response.withStats(withStats))
 }
 
 // getProductImage returns the product image as JPEG from the database
@@ -550,9 +347,19 @@ func getProductImage(c *gin.Context) {
 		return
 	}
 
-	var imageBytes []byte
-	err := db.QueryRow("SELECT image FROM images WHERE product_id = $1", productID).Scan(&imageBytes)
-	if err == sql.ErrNoRows {
+	// Hidden products are withheld by an admin and look like they don't exist
+	hidden, err := store.IsHidden(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image"})
+		return
+	}
+	if hidden {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	imageBytes, err := store.GetImage(productID)
+	if err == ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
@@ -565,17 +372,27 @@ func getProductImage(c *gin.Context) {
 	c.Data(http.StatusOK, "image/jpeg", imageBytes)
 }
 
-// getProduct returns all datapoints and lowest price info for a specific product ID
+// getProduct returns the datapoints and price stats for a specific product ID. The
+// optional from, to and interval query parameters narrow and downsample the history.
 func getProduct(c *gin.Context) {
 	productID := c.Param("id")
 	if productID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required"})
 		return
 	}
+	query, err := parseHistoryQuery(c.Query("from"), c.Query("to"), c.Query("interval"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid history range", "details": err.Error()})
+		return
+	}
+	cacheKey := productID
+	if !query.isZero() {
+		cacheKey += "?" + c.Query("from") + "|" + c.Query("to") + "|" + query.interval
+	}
 
 	// Check cache first
 	productDetailCache.mu.RLock()
-	if entry, ok := productDetailCache.cache[productID]; ok && time.Now().Before(entry.expiresAt) {
+	if entry, ok := productDetailCache.cache[cacheKey]; ok && time.Now().Before(entry.expiresAt) {
 		cachedData := entry.data
 		productDetailCache.mu.RUnlock()
 		c.JSON(http.StatusOK, cachedData)
@@ -583,83 +400,69 @@ func getProduct(c *gin.Context) {
 	}
 	productDetailCache.mu.RUnlock()
 
-	// Get all datapoints for this product
-	query := `
-		SELECT price, category, datetime
-		FROM products
-		WHERE product_id = $1
-		ORDER BY datetime ASC
-	`
-
-	rows, err := db.Query(query, productID)
+	// Hidden products are withheld by an admin and look like they don't exist
+	hidden, err := store.IsHidden(productID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product datapoints"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product"})
 		return
 	}
-	defer rows.Close()
-
-	var datapoints []ProductDatapoint
-	var name, url string
-
-	for rows.Next() {
-		var dp ProductDatapoint
-		var categoryJSON string
-		var datetime time.Time
-		err := rows.Scan(&dp.Price, &categoryJSON, &datetime)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan datapoint"})
-			return
-		}
-		dp.Datetime = datetime.Format(time.RFC3339)
-		if err := json.Unmarshal([]byte(categoryJSON), &dp.Categories); err != nil {
-			dp.Categories = []string{categoryJSON}
-		}
-		datapoints = append(datapoints, dp)
+	if hidden {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
 	}
 
-	if err = rows.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating datapoints"})
+	// Get all datapoints for this product
+	history, err := store.ProductHistory(productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query product datapoints"})
 		return
 	}
 
-	if len(datapoints) == 0 {
+	if len(history) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
-	// Get product name and URL from the most recent entry
-	err = db.QueryRow("SELECT name, url FROM products WHERE product_id = $1 ORDER BY datetime DESC LIMIT 1", productID).Scan(&name, &url)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product details"})
-		return
+	datapoints := make([]ProductDatapoint, 0, len(history))
+	for _, r := range history {
+		datapoints = append(datapoints, ProductDatapoint{
+			Price:      r.Price,
+			Categories: r.Categories,
+			Datetime:   formatTimestamp(r.Datetime),
+		})
 	}
 
+	// Get product name and URL from the most recent entry
+	latest := history[len(history)-1]
+	name, url := latest.Name, latest.URL
+
 	// Get lowest, highest, and regular price info from stats table
 	var lowestPriceInfo LowestPriceInfo
 	var highestPriceInfo HighestPriceInfo
 	var regularPrice float64
-	var lowestDatetime, highestDatetime sql.NullTime
-	err = db.QueryRow(
-		"SELECT lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price FROM stats WHERE product_id = $1",
-		productID,
-	).Scan(&lowestPriceInfo.LowestPrice, &lowestDatetime, &highestPriceInfo.HighestPrice, &highestDatetime, &regularPrice)
+	var extended ExtendedStats
+	stats, err := store.GetStats(productID)
 	if err == nil {
-		if lowestDatetime.Valid {
-			lowestPriceInfo.Datetime = lowestDatetime.Time.Format(time.RFC3339)
+		lowestPriceInfo.LowestPrice = stats.LowestPrice
+		highestPriceInfo.HighestPrice = stats.HighestPrice
+		regularPrice = stats.RegularPrice
+		extended = stats.Extended
+		if !stats.LowestPriceDatetime.IsZero() {
+			lowestPriceInfo.Datetime = formatTimestamp(stats.LowestPriceDatetime)
 		}
-		if highestDatetime.Valid {
-			highestPriceInfo.Datetime = highestDatetime.Time.Format(time.RFC3339)
+		if !stats.HighestPriceDatetime.IsZero() {
+			highestPriceInfo.Datetime = formatTimestamp(stats.HighestPriceDatetime)
 		}
 	} else {
 		// No stats row, or scan failed (e.g. NULL datetime) — fall back to calculating from datapoints
-		if err != sql.ErrNoRows {
+		if err != ErrNotFound {
 			fmt.Printf("WARNING: stats scan failed for %s: %v, falling back to datapoints\n", productID, err)
 		}
 		minPrice := datapoints[0].Price
 		minDatetime := datapoints[0].Datetime
 		maxPrice := datapoints[0].Price
 		maxDatetime := datapoints[0].Datetime
-		priceCount := make(map[float64]int)
+		prices := make([]float64, 0, len(datapoints))
 		for _, dp := range datapoints {
 			if dp.Price < minPrice {
 				minPrice = dp.Price
@@ -669,154 +472,129 @@ func getProduct(c *gin.Context) {
 				maxPrice = dp.Price
 				maxDatetime = dp.Datetime
 			}
-			priceCount[dp.Price]++
+			prices = append(prices, dp.Price)
 		}
 		lowestPriceInfo.LowestPrice = minPrice
 		lowestPriceInfo.Datetime = minDatetime
 		highestPriceInfo.HighestPrice = maxPrice
 		highestPriceInfo.Datetime = maxDatetime
-		maxCount := 0
-		for price, count := range priceCount {
-			if count > maxCount || (count == maxCount && price > regularPrice) {
-				maxCount = count
-				regularPrice = price
-			}
-		}
+		regularPrice = regularPriceOf(prices)
+		extended = extendedStatsFromHistory(history, regularPrice)
 	}
 
 	// Determine if product is on sale (current price < regular price)
 	currentPrice := datapoints[len(datapoints)-1].Price
 	onSale := currentPrice < regularPrice
-	isAllTimeLow := currentPrice <= lowestPriceInfo.LowestPrice && lowestPriceInfo.LowestPrice < regularPrice
+	allTimeLow := isAllTimeLow(currentPrice, lowestPriceInfo.LowestPrice, regularPrice)
 
-	// Build response and cache it
-	response := gin.H{
-		"product_id":      productID,
-		"name":            name,
-		"url":             url,
-		"datapoints":      datapoints,
-		"lowest_price":    lowestPriceInfo,
-		"highest_price":   highestPriceInfo,
-		"regular_price":   regularPrice,
-		"current_price":   currentPrice,
-		"on_sale":         onSale,
-		"is_all_time_low": isAllTimeLow,
+	// Get first and last seen and the lifecycle status, falling back to the datapoints
+	lifecycle, err := store.GetLifecycle(productID)
+	if err != nil {
+		if err != ErrNotFound {
+			fmt.Printf("WARNING: lifecycle lookup failed for %s: %v, falling back to datapoints\n", productID, err)
+		}
+		lifecycle = lifecycleFromHistory(history)
+		if runs, err := coveredRuns(); err == nil {
+			lifecycle.MissedRuns, lifecycle.Status = lifecycleStatus(lifecycle, runs)
+		}
 	}
 
-	productDetailCache.mu.Lock()
-	productDetailCache.cache[productID] = productCacheEntry{
-		data:      response,
-		expiresAt: time.Now().Add(cacheDuration),
+	// Without a range or interval the raw datapoints are returned as before. With one,
+	// the narrowed history is summarized as steps instead, which is all a chart needs.
+	rangedDatapoints := datapoints
+	var steps []PriceStep
+	if !query.isZero() {
+		var ranged []ProductRecord
+		for _, r := range history {
+			if query.contains(r.Datetime) {
+				ranged = append(ranged, r)
+			}
+		}
+		rangedDatapoints = nil
+		steps = append([]PriceStep{}, priceSteps(ranged, query.interval)...)
 	}
-	productDetailCache.mu.Unlock()
+
+	// Build response and cache it
+	response := ProductDetailResponse{
+		ProductID:    productID,
+		Name:         name,
+		URL:          url,
+		Datapoints:   rangedDatapoints,
+		LowestPrice:  lowestPriceInfo,
+		HighestPrice: highestPriceInfo,
+		RegularPrice: regularPrice,
+		CurrentPrice: currentPrice,
+		OnSale:       onSale,
+		IsAllTimeLow: allTimeLow,
+		FirstSeen:    formatTimestamp(lifecycle.FirstSeen),
+		LastSeen:     formatTimestamp(lifecycle.LastSeen),
+		Status:       lifecycle.Status,
+		MissedRuns:   lifecycle.MissedRuns,
+		Stats:        *newExtendedStatsInfo(extended, onSale, time.Now()),
+		Range:        query.response(),
+		History:      steps,
+	}
+
+	productDetailCache.put(cacheKey, response)
 
 	c.JSON(http.StatusOK, response)
 }
 
+// getCategories returns every category seen by the scraper
 func getCategories(c *gin.Context) {
-	rows, err := db.Query("SELECT category FROM categories")
+	categories, err := store.Categories()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get categories"})
 		return
 	}
-	defer rows.Close()
-
-	var categories []string
-	for rows.Next() {
-		var category string
-		if err := rows.Scan(&category); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan category"})
-			return
-		}
-		categories = append(categories, category)
-	}
-
-	if err := rows.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating categories"})
-		return
-	}
 
 	if categories == nil {
 		categories = []string{}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"categories": categories})
+	c.JSON(http.StatusOK, CategoriesResponse{Categories: categories})
 }
 
-// getProductsByCategory returns all products from the most recent scrape filtered by category
+// getProductsByCategory returns the latest products in a category, and their extended
+// stats with stats=true
 func getProductsByCategory(c *gin.Context) {
 	category := strings.TrimPrefix(c.Param("category"), "/")
 	if category == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Category is required"})
 		return
 	}
-
-	// Get the newest datetime from products table
-	var newestDatetime sql.NullTime
-	err := db.QueryRow("SELECT MAX(datetime) FROM products").Scan(&newestDatetime)
+	withStats, err := includeStats(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get newest datetime"})
-		return
-	}
-
-	if !newestDatetime.Valid {
-		c.JSON(http.StatusOK, gin.H{"products": []ProductResponse{}, "datetime": nil, "category": category})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stats must be true or false"})
 		return
 	}
 
-	// Query products with the newest datetime filtered by category using JSONB contains and join with stats
-	categoryFilter, _ := json.Marshal([]string{category})
-
-	query := `
-		SELECT
-			p.product_id,
-			p.name,
-			p.price,
-			p.url,
-			p.category,
-			p.datetime,
-			COALESCE(s.lowest_price, p.price) as lowest_price,
-			COALESCE(s.regular_price, p.price) as regular_price
-		FROM products p
-		LEFT JOIN stats s ON p.product_id = s.product_id
-		WHERE p.datetime = $1 AND p.category @> $2::jsonb
-	`
-
-	rows, err := db.Query(query, newestDatetime.Time, string(categoryFilter))
+	snapshot, err := latestSnapshot(category)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query products"})
 		return
 	}
-	defer rows.Close()
 
-	var products []ProductResponse
-	for rows.Next() {
-		var p ProductResponse
-		var categoryJSON string
-		var datetime time.Time
-		err := rows.Scan(&p.ProductID, &p.Name, &p.Price, &p.URL, &categoryJSON, &datetime, &p.LowestPrice, &p.RegularPrice)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan product"})
-			return
-		}
-		p.Datetime = datetime.Format(time.RFC3339)
-		if err := json.Unmarshal([]byte(categoryJSON), &p.Categories); err != nil {
-			p.Categories = []string{categoryJSON}
-		}
-		p.IsAllTimeLow = p.Price <= p.LowestPrice && p.LowestPrice < p.RegularPrice
-		products = append(products, p)
+	products := snapshot.Products
+	if products == nil {
+		products = []ProductResponse{}
+	}
+	if !withStats {
+		products = withoutStats(products)
 	}
 
-	if err = rows.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating products"})
-		return
+	// The category's own run, which is older than the newest when it wasn't covered
+	datetime, stale := snapshot.Datetime, false
+	if len(snapshot.Categories) > 0 {
+		datetime, stale = snapshot.Categories[0].Datetime, snapshot.Categories[0].Stale
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"datetime": newestDatetime.Time.Format(time.RFC3339),
-		"category": category,
-		"count":    len(products),
-		"products": products,
+	c.JSON(http.StatusOK, CategoryProductsResponse{
+		Datetime: formatDatetime(datetime),
+		Category: category,
+		Stale:    stale,
+		Count:    len(products),
+		Products: products,
 	})
 }
 
@@ -835,8 +613,9 @@ func corsMiddleware() gin.HandlerFunc {
 			}
 		}
 
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-API-Key")
+		c.Header("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, Deprecation, Sunset, Link")
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -848,41 +627,125 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-func main() {
-	// Initialize database on startup
-	if err := initDB(); err != nil {
-		panic(fmt.Sprintf("Failed to initialize database: %v", err))
-	}
-	defer db.Close()
-
+// newRouter builds the gin engine with every API route registered. legacyAccounts,
+// when non-empty, are BasicAuth credentials still accepted for ingest.
+func newRouter(legacyAccounts gin.Accounts) *gin.Engine {
 	router := gin.Default()
 	router.Use(corsMiddleware())
 
+	// Only trust X-Forwarded-For from known proxies, otherwise clients could pick their
+	// own rate limit bucket. gin trusts every proxy unless told otherwise.
+	var proxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		proxies = strings.Split(v, ",")
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		fmt.Printf("WARNING: invalid TRUSTED_PROXIES: %v\n", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	limits := loadRateLimits()
+	router.Use(optionalAPIKey(newRateLimiter("key-failures", limits.KeyFailures)))
+	publicLimit := rateLimitMiddleware(newRateLimiter("public", limits.Public))
+	imageLimit := rateLimitMiddleware(newRateLimiter("images", limits.Images))
+	ingestLimit := rateLimitMiddleware(newRateLimiter("ingest", limits.Ingest))
+	exportLimit := rateLimitMiddleware(newRateLimiter("exports", limits.Exports))
+	lookupGuard := productLookupGuard(newRateLimiter("misses", limits.Misses))
+	ingestAuth := requireScope(ScopeIngest, legacyAccounts)
+	adminAuth := requireScope(ScopeAdmin, nil)
+
+	v1 := router.Group("/api/v1")
+
 	// Public endpoint to get products
-	router.GET("/api/products", getProducts)
+	v1.GET("/products", publicLimit, getProducts)
 
 	// Public endpoint to get products by category
-	router.GET("/api/category/*category", getProductsByCategory)
+	v1.GET("/category/*category", publicLimit, getProductsByCategory)
 
 	// Public endpoint to get single product with all datapoints
-	router.GET("/api/product/:id", getProduct)
+	v1.GET("/product/:id", publicLimit, lookupGuard, getProduct)
 
 	// Public endpoint to get product image
-	router.GET("/api/product/:id/image", getProductImage)
+	v1.GET("/product/:id/image", imageLimit, lookupGuard, getProductImage)
+
+	// Public endpoint to forecast a product's next sale
+	v1.GET("/product/:id/forecast", publicLimit, lookupGuard, getProductForecast)
 
-	router.GET("/api/categories", getCategories)
+	v1.GET("/categories", publicLimit, getCategories)
+
+	// Public endpoint for the site-wide statistics banner
+	v1.GET("/summary", publicLimit, getSummary)
+
+	// Public endpoints listing scraper runs with their per-category product and failure counts
+	v1.GET("/scraper/runs", publicLimit, getScraperRuns)
+	v1.GET("/scraper/runs/:id", publicLimit, getScraperRun)
+
+	// Public endpoint to get a category's stats over time, at /categories/{category}/stats
+	v1.GET("/categories/*path", publicLimit, getCategoryStats)
+
+	// Public feed of price changes between scrape runs
+	v1.GET("/changes", publicLimit, getChanges)
+
+	// Atom and JSON feeds of price drops and new all-time lows
+	v1.GET("/feeds/:feed", publicLimit, getFeed)
+
+	// Products first seen recently, and products no longer being scraped
+	v1.GET("/products/new", publicLimit, getNewProducts)
+	v1.GET("/products/discontinued", publicLimit, getDiscontinuedProducts)
+
+	// Products from the latest scrape ranked by discount, recent drop and price rarity
+	v1.GET("/deals", publicLimit, getDeals)
+
+	// Server-sent events for live ingest progress and price changes
+	v1.GET("/events", publicLimit, getEvents)
+
+	// Public endpoint to download the full price history
+	v1.GET("/export", exportLimit, getExport)
 
 	// Protected endpoint to ingest scraped data
-	authUser := os.Getenv("AUTH_USER")
-	authPass := os.Getenv("AUTH_PASS")
-	if authUser == "" || authPass == "" {
-		panic("AUTH_USER and AUTH_PASS environment variables must be set")
-	}
-	router.POST("/api/products/injest", gin.BasicAuth(gin.Accounts{authUser: authPass}), injestProducts)
+	v1.POST("/ingest", ingestAuth, ingestLimit, injestProducts)
+
+	// Admin endpoint reporting usage of the deprecated aliases below
+	v1.GET("/deprecations", adminAuth, getDeprecations)
+
+	// Admin endpoints to manage API keys
+	admin := v1.Group("/admin", adminAuth)
+	admin.GET("/keys", listAPIKeys)
+	admin.POST("/keys", postAPIKey)
+	admin.DELETE("/keys/:id", deleteAPIKey)
+
+	// Admin endpoints to correct bad data, every change is written to the audit log
+	admin.PATCH("/products/:id/datapoints/:datetime", patchDatapoint)
+	admin.DELETE("/products/:id/datapoints/:datetime", deleteDatapoint)
+	admin.PUT("/products/:id/hidden", hideProduct)
+	admin.DELETE("/products/:id/hidden", unhideProduct)
+	admin.POST("/products/:id/recompute-stats", postRecomputeProductStats)
+	admin.GET("/hidden", listHiddenProducts)
+	admin.POST("/recompute-stats", postRecomputeAllStats)
+	admin.DELETE("/scrapes/:date", deleteScrape)
+	admin.GET("/audit", getAuditLog)
+
+	// Admin endpoints to review scrapes the ingest guard quarantined
+	admin.GET("/quarantine", listQuarantine)
+	admin.POST("/quarantine/:id/approve", approveQuarantine)
+	admin.POST("/quarantine/:id/reject", rejectQuarantine)
+
+	// Unversioned routes from before /api/v1, kept as deprecated aliases
+	legacy := router.Group("/api")
+	legacyAlias(legacy, http.MethodGet, "/products", "/api/v1/products", publicLimit, getProducts)
+	legacyAlias(legacy, http.MethodGet, "/category/*category", "/api/v1/category/*category", publicLimit, getProductsByCategory)
+	legacyAlias(legacy, http.MethodGet, "/product/:id", "/api/v1/product/:id", publicLimit, lookupGuard, getProduct)
+	legacyAlias(legacy, http.MethodGet, "/product/:id/image", "/api/v1/product/:id/image", imageLimit, lookupGuard, getProductImage)
+	legacyAlias(legacy, http.MethodGet, "/categories", "/api/v1/categories", publicLimit, getCategories)
+	legacyAlias(legacy, http.MethodPost, "/products/injest", "/api/v1/ingest", ingestAuth, ingestLimit, injestProducts)
+
+	// API description and interactive docs
+	router.GET("/api/openapi.json", getOpenAPISpec)
+	router.GET("/api/docs", getAPIDocs)
+
+	return router
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	router.Run("0.0.0.0:" + port)
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testBackends opens a fresh, empty store for every backend that runs without a server
+var testBackends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return newMemoryStore()
+	},
+	"sqlite": func(t *testing.T) Store {
+		s, err := newSQLiteStore(filepath.Join(t.TempDir(), "tracker.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		if _, err := s.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+// forEachBackend runs fn against a router backed by each test backend
+func forEachBackend(t *testing.T, fn func(t *testing.T, router *gin.Engine)) {
+	for name, open := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			store = open(t)
+			invalidateCaches()
+			fn(t, newRouter(gin.Accounts{"scraper": "secret"}))
+		})
+	}
+}
+
+// buildScrapeZip packages a prices.json and fake images the way the scraper uploads them
+func buildScrapeZip(t *testing.T, output map[string]any, images map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("prices.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range images {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scrapeOutput builds a prices.json payload from product ID to CA price string
+func scrapeOutput(datetime string, products map[string]map[string]string) map[string]any {
+	byCategory := map[string][]map[string]string{}
+	var categories []string
+	for category, prices := range products {
+		categories = append(categories, category)
+		for id, price := range prices {
+			byCategory[category] = append(byCategory[category], map[string]string{
+				"product_id": id,
+				"name":       "Product " + id,
+				"price":      "CA $ " + price,
+				"url":        "https://www.uniqlo.com/ca/en/products/" + id,
+				"image":      id + ".jpg",
+			})
+		}
+	}
+	return map[string]any{
+		"metadata": map[string]any{
+			"datetime":           datetime,
+			"scraper_version":    "test",
+			"total_products":     len(byCategory),
+			"categories_scraped": len(categories),
+			"categories":         categories,
+		},
+		"products": byCategory,
+	}
+}
+
+func ingest(t *testing.T, router *gin.Engine, zipBytes []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return ingestAt(t, router, "/api/v1/ingest", zipBytes)
+}
+
+// ingestAt uploads a scrape ZIP to the given ingest route with valid credentials
+func ingestAt(t *testing.T, router *gin.Engine, path string, zipBytes []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "output.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(zipBytes)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetBasicAuth("scraper", "secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestKey creates an API key with the given scopes and returns its secret
+func newTestKey(t *testing.T, scopes ...string) string {
+	t.Helper()
+	secret, _, err := createAPIKey("test", scopes, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// do sends a request authenticated with an API key, JSON-encoding body when it isn't nil
+func do(t *testing.T, router *gin.Engine, method, path, key string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func get(t *testing.T, router *gin.Engine, path string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: invalid JSON: %v", path, err)
+		}
+	}
+	return rec
+}
+
+func TestIngestRequiresAuth(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+}
+
+func TestIngestAndQuery(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		output := scrapeOutput("2025-01-01T03:00:00Z", map[string]map[string]string{
+			"men/tops":   {"E100": "29.90", "E200": "49.90"},
+			"women/tops": {"E100": "29.90", "E300": "19.90"},
+		})
+		zipBytes := buildScrapeZip(t, output, map[string][]byte{"E100.jpg": []byte("jpeg")})
+		if rec := ingest(t, router, zipBytes); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		var products struct {
+			Count    int               `json:"count"`
+			Products []ProductResponse `json:"products"`
+		}
+		get(t, router, "/api/v1/products", &products)
+		if products.Count != 3 {
+			t.Fatalf("expected 3 products, got %d", products.Count)
+		}
+		for _, p := range products.Products {
+			if p.ProductID == "E100" && len(p.Categories) != 2 {
+				t.Errorf("expected E100 to be consolidated into 2 categories, got %v", p.Categories)
+			}
+		}
+
+		var byCategory struct {
+			Count int `json:"count"`
+		}
+		get(t, router, "/api/v1/category/women/tops", &byCategory)
+		if byCategory.Count != 2 {
+			t.Errorf("expected 2 products in women/tops, got %d", byCategory.Count)
+		}
+
+		var categories struct {
+			Categories []string `json:"categories"`
+		}
+		get(t, router, "/api/v1/categories", &categories)
+		if len(categories.Categories) != 2 {
+			t.Errorf("expected 2 categories, got %v", categories.Categories)
+		}
+
+		if rec := get(t, router, "/api/v1/product/E100/image", nil); rec.Code != http.StatusOK || rec.Body.String() != "jpeg" {
+			t.Errorf("unexpected image response: %d %q", rec.Code, rec.Body.String())
+		}
+		if rec := get(t, router, "/api/v1/product/E200/image", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for missing image, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/product/NOPE", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for unknown product, got %d", rec.Code)
+		}
+	})
+}
+
+func TestIngestSkipsMalformedPrices(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		output := scrapeOutput("2025-01-01T03:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "49.90"}})
+		for _, p := range output["products"].(map[string][]map[string]string)["men/tops"] {
+			if p["product_id"] == "E200" {
+				p["price"] = "$49.90"
+			}
+		}
+		if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		var products struct {
+			Count    int               `json:"count"`
+			Products []ProductResponse `json:"products"`
+		}
+		get(t, router, "/api/v1/products", &products)
+		if products.Count != 1 || products.Products[0].ProductID != "E100" {
+			t.Errorf("expected E200's malformed price to be skipped, got %+v", products.Products)
+		}
+	})
+}
+
+func TestProductStatsAcrossScrapes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for i, price := range []string{"29.90", "29.90", "19.90"} {
+			datetime := fmt.Sprintf("2025-01-0%dT03:00:00Z", i+1)
+			output := scrapeOutput(datetime, map[string]map[string]string{"men/tops": {"E100": price}})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var detail struct {
+			Datapoints   []ProductDatapoint `json:"datapoints"`
+			LowestPrice  LowestPriceInfo    `json:"lowest_price"`
+			HighestPrice HighestPriceInfo   `json:"highest_price"`
+			RegularPrice float64            `json:"regular_price"`
+			CurrentPrice float64            `json:"current_price"`
+			OnSale       bool               `json:"on_sale"`
+			IsAllTimeLow bool               `json:"is_all_time_low"`
+		}
+		get(t, router, "/api/v1/product/E100", &detail)
+		if len(detail.Datapoints) != 3 {
+			t.Fatalf("expected 3 datapoints, got %d", len(detail.Datapoints))
+		}
+		if detail.LowestPrice.LowestPrice != 19.90 || detail.HighestPrice.HighestPrice != 29.90 {
+			t.Errorf("unexpected extremes: low %v high %v", detail.LowestPrice.LowestPrice, detail.HighestPrice.HighestPrice)
+		}
+		if detail.RegularPrice != 29.90 || detail.CurrentPrice != 19.90 {
+			t.Errorf("unexpected regular %v / current %v", detail.RegularPrice, detail.CurrentPrice)
+		}
+		if !detail.OnSale || !detail.IsAllTimeLow {
+			t.Errorf("expected product to be on sale at an all-time low")
+		}
+	})
+}
+
+func TestIngestBackfill(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// Scrapes arrive newest first; the oldest repeats the low and high seen later
+		scrapes := []struct{ datetime, price string }{
+			{"2025-01-04T03:00:00Z", "19.90"},
+			{"2025-01-03T03:00:00Z", "39.90"},
+			{"2025-01-02T03:00:00Z", "29.90"},
+			{"2025-01-01T03:00:00.5+00:00", "19.90"},
+		}
+		for _, s := range scrapes {
+			output := scrapeOutput(s.datetime, map[string]map[string]string{"men/tops": {"E100": s.price}})
+			image := map[string][]byte{"E100.jpg": []byte("image from " + s.datetime)}
+			if rec := ingest(t, router, buildScrapeZip(t, output, image)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+		if image, _ := store.GetImage("E100"); string(image) != "image from 2025-01-04T03:00:00Z" {
+			t.Errorf("expected backfills to keep the newest image, got %q", image)
+		}
+
+		history, err := store.ProductHistory("E100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := time.Date(2025, time.January, 1, 3, 0, 0, 5e8, time.UTC)
+		if len(history) != 4 || !history[0].Datetime.Equal(first) {
+			t.Fatalf("expected rows dated from scrape metadata, got %+v", history)
+		}
+
+		stats, err := store.GetStats("E100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := statsFromHistory(history)
+		if stats.LowestPrice != 19.90 || !stats.LowestPriceDatetime.Equal(first) {
+			t.Errorf("expected the backfilled low to take the earlier date, got %v at %v", stats.LowestPrice, stats.LowestPriceDatetime)
+		}
+		if stats.HighestPrice != want.HighestPrice || !stats.HighestPriceDatetime.Equal(want.HighestPriceDatetime) || stats.RegularPrice != want.RegularPrice || stats.Extended.AveragePrice != want.Extended.AveragePrice {
+			t.Errorf("stats differ from in-order ingest: got %+v, want %+v", stats, want)
+		}
+
+		var detail ProductDetailResponse
+		get(t, router, "/api/v1/product/E100", &detail)
+		if detail.CurrentPrice != 19.90 {
+			t.Errorf("expected current price from the newest scrape, got %v", detail.CurrentPrice)
+		}
+
+		// An unparseable datetime is stamped with the time of ingest, like a missing one
+		bad := scrapeOutput("yesterday", map[string]map[string]string{"men/tops": {"E100": "9.90"}})
+		if rec := ingest(t, router, buildScrapeZip(t, bad, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("invalid datetime: expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if times, _ := store.ScrapeTimes(); len(times) != 5 || time.Since(times[4]) > time.Minute {
+			t.Errorf("expected the scrape to be dated now, got %v", times)
+		}
+	})
+}
+
+func TestSameDayScrapes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		morning := scrapeOutput("2025-06-01T08:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90", "E200": "49.90"}})
+		flashSale := scrapeOutput("2025-06-01T17:30:00Z", map[string]map[string]string{"men/tops": {"E100": "14.90"}})
+		for _, output := range []map[string]any{morning, flashSale} {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var list ProductsListResponse
+		get(t, router, "/api/v1/products", &list)
+		if list.Count != 1 || list.Products[0].Price != 14.90 {
+			t.Fatalf("expected only the latest run in the snapshot, got %+v", list.Products)
+		}
+		if list.Products[0].Datetime != "2025-06-01T17:30:00Z" {
+			t.Errorf("unexpected snapshot datetime %q", list.Products[0].Datetime)
+		}
+
+		var detail ProductDetailResponse
+		get(t, router, "/api/v1/product/E100", &detail)
+		if len(detail.Datapoints) != 2 || detail.LowestPrice.Datetime != "2025-06-01T17:30:00Z" {
+			t.Errorf("expected both runs with the low stamped at 17:30, got %d datapoints, low at %q", len(detail.Datapoints), detail.LowestPrice.Datetime)
+		}
+	})
+}
+
+func TestLegacyAliases(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		output := scrapeOutput("2025-01-01T03:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}})
+		rec := ingestAt(t, router, "/api/products/injest", buildScrapeZip(t, output, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("legacy ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = get(t, router, "/api/category/men/tops", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("legacy category route: %d", rec.Code)
+		}
+		if rec.Header().Get("Deprecation") == "" || rec.Header().Get("Sunset") == "" {
+			t.Errorf("expected Deprecation and Sunset headers, got %v", rec.Header())
+		}
+		if link := rec.Header().Get("Link"); link != `</api/v1/category/men/tops>; rel="successor-version"` {
+			t.Errorf("unexpected Link header %q", link)
+		}
+		if rec := get(t, router, "/api/v1/products", nil); rec.Header().Get("Deprecation") != "" {
+			t.Errorf("v1 routes must not be marked deprecated")
+		}
+
+		rec = do(t, router, http.MethodGet, "/api/v1/deprecations", newTestKey(t, ScopeAdmin), nil)
+		var deprecations DeprecationsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &deprecations); err != nil {
+			t.Fatal(err)
+		}
+		hits := map[string]int64{}
+		for _, a := range deprecations.Aliases {
+			hits[a.Method+" "+a.Route] = a.Hits
+		}
+		if hits["POST /api/products/injest"] != 1 || hits["GET /api/category/*category"] != 1 || hits["GET /api/products"] != 0 {
+			t.Errorf("unexpected alias hits: %v", hits)
+		}
+	})
+}
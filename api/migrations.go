@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// migration is a versioned schema change. Migrations are applied in version order,
+// each in its own transaction, and recorded in schema_migrations.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// MigrationStatus reports a migration and when it was applied. A zero AppliedAt
+// means the migration is still pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// appliedMigrations returns when each recorded migration was applied
+func (s *sqlStore) appliedMigrations() (map[int]time.Time, error) {
+	if _, err := s.db.Exec(s.dialect.migrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func (s *sqlStore) Migrations() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(s.dialect.migrations))
+	for _, m := range s.dialect.migrations {
+		statuses = append(statuses, MigrationStatus{Version: m.version, Name: m.name, AppliedAt: applied[m.version]})
+	}
+	return statuses, nil
+}
+
+func (s *sqlStore) Migrate() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []MigrationStatus
+	for _, m := range s.dialect.migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		at := time.Now().UTC()
+		if err := s.applyMigration(m, at); err != nil {
+			return ran, err
+		}
+		ran = append(ran, MigrationStatus{Version: m.version, Name: m.name, AppliedAt: at})
+	}
+	return ran, nil
+}
+
+// applyMigration runs a migration's statements and records it in one transaction
+func (s *sqlStore) applyMigration(m migration, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	for _, q := range m.statements {
+		if _, err := tx.Exec(q); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		m.version, m.name, s.timeArg(at),
+	); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
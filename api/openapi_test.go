@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"api/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// specSchemas maps each OpenAPI component schema to the Go type serialized for it
+var specSchemas = map[string]any{
+	"Product":                ProductResponse{},
+	"ProductsList":           ProductsListResponse{},
+	"CategorySnapshot":       CategorySnapshotInfo{},
+	"CategoryProducts":       CategoryProductsResponse{},
+	"ProductDatapoint":       ProductDatapoint{},
+	"LowestPrice":            LowestPriceInfo{},
+	"HighestPrice":           HighestPriceInfo{},
+	"ProductDetail":          ProductDetailResponse{},
+	"HistoryRange":           HistoryRange{},
+	"PriceStep":              PriceStep{},
+	"ExtendedStats":          ExtendedStatsInfo{},
+	"SaleForecast":           SaleForecast{},
+	"ForecastBasis":          ForecastBasis{},
+	"Forecast":               ForecastResponse{},
+	"CategoryStatsPoint":     CategoryStatsPoint{},
+	"CategoryStatsCurrent":   CategoryStatsCurrent{},
+	"CategoryStats":          CategoryStatsResponse{},
+	"Summary":                SummaryResponse{},
+	"LifecycleProduct":       LifecycleProductInfo{},
+	"LifecycleProducts":      LifecycleProductsResponse{},
+	"Categories":             CategoriesResponse{},
+	"ScraperMetadata":        ScraperMetadata{},
+	"CategoryCount":          CategoryCount{},
+	"ScraperRunCategory":     ScraperRunCategory{},
+	"ScraperRun":             ScraperRunInfo{},
+	"ScraperRuns":            ScraperRunsResponse{},
+	"IngestResult":           IngestResponse{},
+	"Anomaly":                Anomaly{},
+	"QuarantinedScrape":      QuarantinedScrapeInfo{},
+	"QuarantinedScrapes":     QuarantinedScrapesResponse{},
+	"IngestQuarantined":      IngestQuarantinedResponse{},
+	"Error":                  ErrorResponse{},
+	"DeprecatedAlias":        AliasUsage{},
+	"Deprecations":           DeprecationsResponse{},
+	"APIKey":                 APIKeyInfo{},
+	"APIKeys":                APIKeysResponse{},
+	"CreateAPIKeyRequest":    CreateAPIKeyRequest{},
+	"CreatedAPIKey":          CreateAPIKeyResponse{},
+	"UpdateDatapointRequest": UpdateDatapointRequest{},
+	"HideProductRequest":     HideProductRequest{},
+	"AdminChange":            AdminChangeResponse{},
+	"HiddenProduct":          HiddenProductInfo{},
+	"HiddenProducts":         HiddenProductsResponse{},
+	"AuditEntry":             AuditEntryInfo{},
+	"AuditLog":               AuditLogResponse{},
+	"ExportRow":              ExportRecord{},
+	"PriceChange":            PriceChangeInfo{},
+	"PriceChanges":           PriceChangesResponse{},
+	"DealScore":              DealScore{},
+	"Deal":                   DealInfo{},
+	"Deals":                  DealsResponse{},
+	"JSONFeed":               JSONFeed{},
+	"JSONFeedItem":           JSONFeedItem{},
+	"IngestEvent":            IngestEvent{},
+	"PriceChangesEvent":      PriceChangesEvent{},
+}
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return doc
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+func TestSpecCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := newRouter(gin.Accounts{"scraper": "secret"})
+	doc := loadSpec(t)
+
+	registered := map[string]bool{}
+	for _, r := range router.Routes() {
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		key := strings.ToLower(r.Method) + " " + path
+		registered[key] = true
+		if _, ok := doc.Paths[path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("route %s %s is missing from openapi.json", r.Method, path)
+		}
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			if !registered[method+" "+path] {
+				t.Errorf("openapi.json documents %s %s, which is not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestSpecMatchesResponseTypes(t *testing.T) {
+	doc := loadSpec(t)
+
+	for name, schema := range doc.Components.Schemas {
+		v, ok := specSchemas[name]
+		if !ok {
+			t.Errorf("schema %s has no Go type in specSchemas", name)
+			continue
+		}
+		var specFields []string
+		for prop := range schema.Properties {
+			specFields = append(specFields, prop)
+		}
+		goFields := jsonFields(reflect.TypeOf(v))
+		slices.Sort(specFields)
+		slices.Sort(goFields)
+		if !slices.Equal(specFields, goFields) {
+			t.Errorf("schema %s drifted from %T:\n  spec: %v\n  go:   %v", name, v, specFields, goFields)
+		}
+	}
+	for name := range specSchemas {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("specSchemas lists %s, which openapi.json doesn't define", name)
+		}
+	}
+}
+
+func TestServesSpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := newRouter(gin.Accounts{"scraper": "secret"})
+	for _, path := range []string{"/api/openapi.json", "/api/docs"} {
+		if rec := get(t, router, path, nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: expected 200, got %d", path, rec.Code)
+		}
+	}
+}
+
+// jsonFields returns the JSON property names a struct type serializes to
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		srv := httptest.NewServer(router)
+		defer srv.Close()
+
+		c := client.New(srv.URL)
+		c.Username, c.Password = "scraper", "secret"
+		ctx := context.Background()
+
+		output := scrapeOutput("2025-01-01T03:00:00Z", map[string]map[string]string{"men/tops": {"E100": "29.90"}})
+		zipBytes := buildScrapeZip(t, output, map[string][]byte{"E100.jpg": []byte("jpeg")})
+		if _, err := c.Ingest(ctx, bytes.NewReader(zipBytes)); err != nil {
+			t.Fatalf("Ingest: %v", err)
+		}
+
+		list, err := c.ProductsByCategory(ctx, "men/tops")
+		if err != nil || list.Count != 1 {
+			t.Fatalf("ProductsByCategory: %v %+v", err, list)
+		}
+		detail, err := c.Product(ctx, "E100")
+		if err != nil || detail.CurrentPrice != 29.90 {
+			t.Fatalf("Product: %v %+v", err, detail)
+		}
+		if image, err := c.ProductImage(ctx, "E100"); err != nil || string(image) != "jpeg" {
+			t.Fatalf("ProductImage: %v %q", err, image)
+		}
+
+		var apiErr *client.Error
+		if _, err := c.Product(ctx, "NOPE"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected 404 error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Anomaly checks run against each incoming scrape
+const (
+	AnomalyProductDrop     = "product_drop"
+	AnomalyPricesChanged   = "prices_changed"
+	AnomalyPriceJumps      = "price_jumps"
+	AnomalyEmptyCategories = "empty_categories"
+)
+
+// maxAnomalyExamples caps how many products or categories an anomaly lists
+const maxAnomalyExamples = 10
+
+// Anomaly is a check an incoming scrape failed against the previous run. Value and
+// Threshold are percentages.
+type Anomaly struct {
+	Check     string   `json:"check"`
+	Message   string   `json:"message"`
+	Value     float64  `json:"value"`
+	Threshold float64  `json:"threshold"`
+	Examples  []string `json:"examples,omitempty"`
+}
+
+// AnomalyThresholds are the limits the ingest guard holds a scrape to. Percentages are
+// of the products or categories the scrape shares with the previous run.
+type AnomalyThresholds struct {
+	// Enabled turns the guard on
+	Enabled bool
+	// MinProducts is how many products the previous run must have in the scrape's
+	// categories before it is compared, so small runs aren't judged on noise
+	MinProducts int
+	// MaxProductDrop is how far the product count may fall
+	MaxProductDrop float64
+	// MaxPricesChanged is the share of products whose price may change
+	MaxPricesChanged float64
+	// PriceJumpFactor is how many times higher or lower a price must be to count as a jump
+	PriceJumpFactor float64
+	// MaxPriceJumps is the share of products whose price may jump
+	MaxPriceJumps float64
+	// MaxEmptyCategories is the share of categories that may come back empty
+	MaxEmptyCategories float64
+}
+
+var defaultAnomalyThresholds = AnomalyThresholds{
+	Enabled:            true,
+	MinProducts:        20,
+	MaxProductDrop:     50,
+	MaxPricesChanged:   50,
+	PriceJumpFactor:    3,
+	MaxPriceJumps:      5,
+	MaxEmptyCategories: 25,
+}
+
+// anomalyThresholds is the guard applied to uploads from the scraper
+var anomalyThresholds = loadAnomalyThresholds()
+
+// loadAnomalyThresholds reads INGEST_GUARD=off and INGEST_<LIMIT> overrides such as
+// INGEST_MAX_PRODUCT_DROP=30
+func loadAnomalyThresholds() AnomalyThresholds {
+	thresholds := defaultAnomalyThresholds
+	if os.Getenv("INGEST_GUARD") == "off" {
+		thresholds.Enabled = false
+	}
+	if v := os.Getenv("INGEST_GUARD_MIN_PRODUCTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			thresholds.MinProducts = n
+		} else {
+			fmt.Printf("WARNING: ignoring INGEST_GUARD_MIN_PRODUCTS: invalid count %q\n", v)
+		}
+	}
+	for env, limit := range map[string]*float64{
+		"INGEST_MAX_PRODUCT_DROP":     &thresholds.MaxProductDrop,
+		"INGEST_MAX_PRICES_CHANGED":   &thresholds.MaxPricesChanged,
+		"INGEST_PRICE_JUMP_FACTOR":    &thresholds.PriceJumpFactor,
+		"INGEST_MAX_PRICE_JUMPS":      &thresholds.MaxPriceJumps,
+		"INGEST_MAX_EMPTY_CATEGORIES": &thresholds.MaxEmptyCategories,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 {
+			fmt.Printf("WARNING: ignoring %s: invalid value %q\n", env, v)
+			continue
+		}
+		*limit = parsed
+	}
+	return thresholds
+}
+
+// examples sorts items and keeps the first few for an anomaly
+func examples(items []string) []string {
+	slices.Sort(items)
+	return items[:min(len(items), maxAnomalyExamples)]
+}
+
+// detectAnomalies compares an incoming scrape, its products and its product count per
+// category, to the previous run's datapoints. Only the products of the categories the
+// scrape covered are compared on both sides, so a partial scrape isn't mistaken for a
+// bad one.
+func detectAnomalies(products []ProductRecord, categories map[string]int, previous []ProductRecord, t AnomalyThresholds) []Anomaly {
+	before := make(map[string]float64)
+	beforeCategories := make(map[string]int)
+	for _, r := range previous {
+		for _, c := range r.Categories {
+			if _, ok := categories[c]; ok {
+				before[r.ProductID] = r.Price
+				beforeCategories[c]++
+			}
+		}
+	}
+	if len(before) == 0 || len(before) < t.MinProducts {
+		return nil
+	}
+	incoming := make(map[string]float64)
+	for _, r := range products {
+		if slices.ContainsFunc(r.Categories, func(c string) bool {
+			_, ok := categories[c]
+			return ok
+		}) {
+			incoming[r.ProductID] = r.Price
+		}
+	}
+
+	var anomalies []Anomaly
+	if drop := float64(len(before)-len(incoming)) / float64(len(before)) * 100; drop > t.MaxProductDrop {
+		anomalies = append(anomalies, Anomaly{
+			Check:     AnomalyProductDrop,
+			Message:   fmt.Sprintf("%d products, down from %d", len(incoming), len(before)),
+			Value:     roundTo(drop, 2),
+			Threshold: t.MaxProductDrop,
+		})
+	}
+
+	var shared, changed int
+	var jumps []string
+	for id, price := range incoming {
+		old, ok := before[id]
+		if !ok {
+			continue
+		}
+		shared++
+		if !samePrice(old, price) {
+			changed++
+		}
+		if old > 0 && (price <= 0 || price/old >= t.PriceJumpFactor || old/price >= t.PriceJumpFactor) {
+			jumps = append(jumps, fmt.Sprintf("%s %.2f -> %.2f", id, old, price))
+		}
+	}
+	if shared > 0 {
+		if share := float64(changed) / float64(shared) * 100; share > t.MaxPricesChanged {
+			anomalies = append(anomalies, Anomaly{
+				Check:     AnomalyPricesChanged,
+				Message:   fmt.Sprintf("%d of %d products changed price", changed, shared),
+				Value:     roundTo(share, 2),
+				Threshold: t.MaxPricesChanged,
+			})
+		}
+		if share := float64(len(jumps)) / float64(shared) * 100; share > t.MaxPriceJumps {
+			anomalies = append(anomalies, Anomaly{
+				Check:     AnomalyPriceJumps,
+				Message:   fmt.Sprintf("%d of %d products moved %gx or more in price", len(jumps), shared, t.PriceJumpFactor),
+				Value:     roundTo(share, 2),
+				Threshold: t.MaxPriceJumps,
+				Examples:  examples(jumps),
+			})
+		}
+	}
+
+	var empty []string
+	for c := range beforeCategories {
+		if categories[c] == 0 {
+			empty = append(empty, c)
+		}
+	}
+	if share := float64(len(empty)) / float64(len(beforeCategories)) * 100; share > t.MaxEmptyCategories {
+		anomalies = append(anomalies, Anomaly{
+			Check:     AnomalyEmptyCategories,
+			Message:   fmt.Sprintf("%d of %d categories came back empty", len(empty), len(beforeCategories)),
+			Value:     roundTo(share, 2),
+			Threshold: t.MaxEmptyCategories,
+			Examples:  examples(empty),
+		})
+	}
+	return anomalies
+}
+
+// checkScrape runs the ingest guard on a scrape taken at datetime, comparing each
+// category with the last run before it that covered the category, as the latest
+// snapshot does. The first scrape has nothing to compare against.
+func checkScrape(datetime time.Time, incoming []ProductRecord, categories map[string]int) ([]Anomaly, error) {
+	if !anomalyThresholds.Enabled {
+		return nil, nil
+	}
+	times, err := store.ScrapeTimes()
+	if err != nil {
+		return nil, err
+	}
+	var previous time.Time
+	for _, t := range times {
+		if t.Before(datetime) {
+			previous = t
+		}
+	}
+	if previous.IsZero() {
+		return nil, nil
+	}
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		return nil, err
+	}
+	known, err := store.Categories()
+	if err != nil {
+		return nil, err
+	}
+	coverage := categoryCoverage(runs, known, previous)
+	byRun := make(map[time.Time][]string)
+	for c := range categories {
+		if at, ok := coverage[c]; ok {
+			byRun[at] = append(byRun[at], c)
+		}
+	}
+	ats := make([]time.Time, 0, len(byRun))
+	for at := range byRun {
+		ats = append(ats, at)
+	}
+	slices.SortFunc(ats, time.Time.Compare)
+
+	// Each product is compared in the categories taken from its run, with newer runs
+	// overriding older ones
+	var records []ProductRecord
+	for _, at := range ats {
+		products, err := store.ScrapeProducts(at)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range products {
+			r.Categories = slices.DeleteFunc(slices.Clone(r.Categories), func(c string) bool {
+				return !slices.Contains(byRun[at], c)
+			})
+			if len(r.Categories) > 0 {
+				records = append(records, r)
+			}
+		}
+	}
+	return detectAnomalies(incoming, categories, records, anomalyThresholds), nil
+}
+
+// QuarantinedError is returned by ingestArchive when the guard holds a scrape back
+type QuarantinedError struct {
+	Scrape QuarantinedScrape
+}
+
+func (e *QuarantinedError) Error() string {
+	messages := make([]string, 0, len(e.Scrape.Anomalies))
+	for _, a := range e.Scrape.Anomalies {
+		messages = append(messages, a.Message)
+	}
+	return fmt.Sprintf("scrape quarantined as #%d: %s", e.Scrape.ID, strings.Join(messages, "; "))
+}
+
+// pricesOnly returns a copy of a scraper archive with only its prices.json. Images
+// make up most of an upload, and can be uploaded again with the next scrape.
+func pricesOnly(archive []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		if f.Name == "prices.json" {
+			if err := zw.Copy(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quarantineScrape stores an upload that failed the guard for an admin to review,
+// without its images
+func quarantineScrape(archive []byte, metadata ScraperMetadata, datetime time.Time, products int, anomalies []Anomaly) error {
+	archive, err := pricesOnly(archive)
+	if err != nil {
+		return &IngestError{Status: http.StatusInternalServerError, Message: "Failed to quarantine scrape", Details: err.Error()}
+	}
+	q := QuarantinedScrape{
+		Datetime:       datetime,
+		ReceivedAt:     time.Now().UTC(),
+		ScraperVersion: metadata.ScraperVersion,
+		Products:       products,
+		Anomalies:      anomalies,
+		Archive:        archive,
+		Status:         QuarantinePending,
+	}
+	id, err := store.InsertQuarantine(q)
+	if err != nil {
+		return &IngestError{Status: http.StatusInternalServerError, Message: "Failed to quarantine scrape", Details: err.Error()}
+	}
+	q.ID = id
+	quarantined := &QuarantinedError{Scrape: q}
+	fmt.Printf("WARNING: %v\n", quarantined)
+	return quarantined
+}
+
+// QuarantinedScrapeInfo describes a quarantined upload
+type QuarantinedScrapeInfo struct {
+	ID             int64     `json:"id"`
+	Datetime       string    `json:"datetime"`
+	ReceivedAt     string    `json:"received_at"`
+	ScraperVersion string    `json:"scraper_version"`
+	Products       int       `json:"products"`
+	Anomalies      []Anomaly `json:"anomalies"`
+	Status         string    `json:"status"`
+	ResolvedAt     *string   `json:"resolved_at"`
+	ResolvedBy     string    `json:"resolved_by"`
+}
+
+// QuarantinedScrapesResponse is the body returned when listing quarantined uploads
+type QuarantinedScrapesResponse struct {
+	Scrapes []QuarantinedScrapeInfo `json:"scrapes"`
+}
+
+// IngestQuarantinedResponse is the body returned when an upload is quarantined
+type IngestQuarantinedResponse struct {
+	Message    string                `json:"message"`
+	Quarantine QuarantinedScrapeInfo `json:"quarantine"`
+}
+
+func newQuarantinedScrapeInfo(q QuarantinedScrape) QuarantinedScrapeInfo {
+	info := QuarantinedScrapeInfo{
+		ID:             q.ID,
+		Datetime:       formatTimestamp(q.Datetime),
+		ReceivedAt:     formatTimestamp(q.ReceivedAt),
+		ScraperVersion: q.ScraperVersion,
+		Products:       q.Products,
+		Anomalies:      q.Anomalies,
+		Status:         q.Status,
+		ResolvedAt:     formatDatetime(q.ResolvedAt),
+		ResolvedBy:     q.ResolvedBy,
+	}
+	if info.Anomalies == nil {
+		info.Anomalies = []Anomaly{}
+	}
+	return info
+}
+
+// quarantineMu serializes approvals and rejections so an upload is only ingested once
+var quarantineMu sync.Mutex
+
+// listQuarantine returns quarantined uploads, newest first, optionally by status
+func listQuarantine(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", QuarantinePending, QuarantineApproved, QuarantineRejected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, approved, rejected"})
+		return
+	}
+	scrapes, err := store.QuarantinedScrapes(status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quarantined scrapes"})
+		return
+	}
+	response := QuarantinedScrapesResponse{Scrapes: make([]QuarantinedScrapeInfo, 0, len(scrapes))}
+	for _, q := range scrapes {
+		response.Scrapes = append(response.Scrapes, newQuarantinedScrapeInfo(q))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// pendingQuarantine loads the pending upload named by the id parameter, responding
+// with an error when there isn't one
+func pendingQuarantine(c *gin.Context) (QuarantinedScrape, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a positive integer"})
+		return QuarantinedScrape{}, false
+	}
+	q, err := store.GetQuarantine(id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quarantined scrape not found"})
+		return q, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quarantined scrape"})
+		return q, false
+	}
+	if q.Status != QuarantinePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scrape was already " + q.Status})
+		return q, false
+	}
+	return q, true
+}
+
+// approveQuarantine ingests a quarantined upload as it was received, bypassing the
+// guard. Its images weren't kept, which the response points out.
+func approveQuarantine(c *gin.Context) {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	q, ok := pendingQuarantine(c)
+	if !ok {
+		return
+	}
+
+	response, err := ingestArchive(q.Archive, false, q.Datetime)
+	var ingestErr *IngestError
+	if errors.As(err, &ingestErr) {
+		body := gin.H{"error": ingestErr.Message}
+		if ingestErr.Details != "" {
+			body["details"] = ingestErr.Details
+		}
+		c.JSON(ingestErr.Status, body)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ingest products", "details": err.Error()})
+		return
+	}
+
+	if err := store.ResolveQuarantine(q.ID, QuarantineApproved, auditActor(c), time.Now().UTC()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Scrape ingested but failed to resolve quarantine", "details": err.Error()})
+		return
+	}
+	recordAudit(c, "quarantine.approve", strconv.FormatInt(q.ID, 10), map[string]any{"datetime": formatTimestamp(q.Datetime), "products": response.Count})
+	response.Message = "Products ingested without images, which quarantined scrapes don't keep; images update with the next upload"
+	c.JSON(http.StatusOK, response)
+}
+
+// rejectQuarantine discards a quarantined upload
+func rejectQuarantine(c *gin.Context) {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	q, ok := pendingQuarantine(c)
+	if !ok {
+		return
+	}
+	if err := store.ResolveQuarantine(q.ID, QuarantineRejected, auditActor(c), time.Now().UTC()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject scrape"})
+		return
+	}
+	recordAudit(c, "quarantine.reject", strconv.FormatInt(q.ID, 10), map[string]any{"datetime": formatTimestamp(q.Datetime)})
+	c.JSON(http.StatusOK, AdminChangeResponse{Message: "Scrape rejected", Affected: 1})
+}
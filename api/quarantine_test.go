@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pricedProducts returns n products with IDs starting at first, all at price
+func pricedProducts(first, n int, price string) map[string]string {
+	products := make(map[string]string, n)
+	for i := first; i < first+n; i++ {
+		products[fmt.Sprintf("P%03d", i)] = price
+	}
+	return products
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	// 30 tops and 10 innerwear products at 19.90
+	var previous []ProductRecord
+	for i := 0; i < 40; i++ {
+		category := "men/tops"
+		if i >= 30 {
+			category = "women/innerwear"
+		}
+		previous = append(previous, ProductRecord{ProductID: fmt.Sprintf("P%03d", i), Price: 19.90, Categories: []string{category}})
+	}
+	scrape := func(prices func(i int) float64, n int) []ProductRecord {
+		var incoming []ProductRecord
+		for i := 0; i < n; i++ {
+			incoming = append(incoming, ProductRecord{ProductID: fmt.Sprintf("P%03d", i), Price: prices(i), Categories: previous[i].Categories})
+		}
+		return incoming
+	}
+	steady := func(i int) float64 { return 19.90 }
+	both := map[string]int{"men/tops": 30, "women/innerwear": 10}
+
+	tests := []struct {
+		name       string
+		incoming   []ProductRecord
+		categories map[string]int
+		want       []string
+	}{
+		{"healthy", scrape(func(i int) float64 {
+			if i < 5 {
+				return 14.90
+			}
+			return 19.90
+		}, 40), both, nil},
+		{"half missing", scrape(steady, 15), map[string]int{"men/tops": 15, "women/innerwear": 0}, []string{AnomalyProductDrop, AnomalyEmptyCategories}},
+		{"prices changed", scrape(func(i int) float64 {
+			if i < 30 {
+				return 14.90
+			}
+			return 19.90
+		}, 40), both, []string{AnomalyPricesChanged}},
+		{"price jumps", scrape(func(i int) float64 {
+			if i < 3 {
+				return 199.00
+			}
+			return 19.90
+		}, 40), both, []string{AnomalyPriceJumps}},
+		{"partial scrape", scrape(steady, 30), map[string]int{"men/tops": 30}, nil},
+		// Innerwear products don't make up for missing tops when only tops were scraped
+		{"partial scrape missing products", scrape(steady, 40)[20:], map[string]int{"men/tops": 10}, []string{AnomalyProductDrop}},
+	}
+	for _, tt := range tests {
+		anomalies := detectAnomalies(tt.incoming, tt.categories, previous, defaultAnomalyThresholds)
+		if len(anomalies) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %+v", tt.name, tt.want, anomalies)
+			continue
+		}
+		for i, a := range anomalies {
+			if a.Check != tt.want[i] {
+				t.Errorf("%s: expected %v, got %+v", tt.name, tt.want, anomalies)
+			}
+		}
+	}
+
+	jumps := detectAnomalies(tests[3].incoming, both, previous, defaultAnomalyThresholds)
+	if len(jumps[0].Examples) != 3 || jumps[0].Examples[0] != "P000 19.90 -> 199.00" || jumps[0].Value != 7.5 {
+		t.Errorf("expected the three jumps listed, got %+v", jumps[0])
+	}
+
+	// Runs smaller than MinProducts aren't judged
+	if anomalies := detectAnomalies(nil, both, previous[:10], defaultAnomalyThresholds); anomalies != nil {
+		t.Errorf("expected no anomalies against a small run, got %+v", anomalies)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		first := scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{
+			"men/tops":        pricedProducts(0, 30, "19.90"),
+			"women/innerwear": pricedProducts(30, 10, "9.90"),
+		})
+		if rec := ingest(t, router, buildScrapeZip(t, first, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		// Innerwear comes back empty and most tops are missing
+		broken := func(datetime string) []byte {
+			return buildScrapeZip(t, scrapeOutput(datetime, map[string]map[string]string{
+				"men/tops":        pricedProducts(0, 10, "19.90"),
+				"women/innerwear": {},
+			}), map[string][]byte{"images/men/tops/P000.jpg": []byte("jpeg")})
+		}
+		rec := ingest(t, router, broken("2025-01-02T06:00:00Z"))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d %s", rec.Code, rec.Body.String())
+		}
+		var held IngestQuarantinedResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &held); err != nil {
+			t.Fatal(err)
+		}
+		if held.Quarantine.Status != QuarantinePending || held.Quarantine.Products != 10 || len(held.Quarantine.Anomalies) != 2 {
+			t.Errorf("expected a pending scrape with 2 anomalies, got %+v", held.Quarantine)
+		}
+		if times, _ := store.ScrapeTimes(); len(times) != 1 {
+			t.Errorf("expected the quarantined scrape not to be stored, got %d scrapes", len(times))
+		}
+		stored, err := store.GetQuarantine(held.Quarantine.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(stored.Archive), int64(len(stored.Archive)))
+		if err != nil || len(zr.File) != 1 || zr.File[0].Name != "prices.json" {
+			t.Errorf("expected only prices.json to be kept, got %v", err)
+		}
+
+		key := newTestKey(t, ScopeAdmin)
+		path := fmt.Sprintf("/api/v1/admin/quarantine/%d", held.Quarantine.ID)
+		if rec := do(t, router, http.MethodPost, path+"/approve", "", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 without a key, got %d", rec.Code)
+		}
+		rec = do(t, router, http.MethodPost, path+"/approve", key, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("approve failed: %d %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "without images") {
+			t.Errorf("expected the response to say images were dropped, got %s", rec.Body.String())
+		}
+		if times, _ := store.ScrapeTimes(); len(times) != 2 {
+			t.Errorf("expected the approved scrape to be stored, got %d scrapes", len(times))
+		}
+		if rec := do(t, router, http.MethodPost, path+"/approve", key, nil); rec.Code != http.StatusConflict {
+			t.Errorf("expected 409 approving twice, got %d", rec.Code)
+		}
+
+		// Later scrapes are compared to the approved one, whose 10 products are too few to judge
+		rec = ingest(t, router, buildScrapeZip(t, scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{
+			"men/tops": pricedProducts(0, 10, "199.00"),
+		}), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected a scrape after a small run to pass, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		// A scrape backfilled after the first run is compared to it
+		rec = ingest(t, router, broken("2025-01-01T18:00:00Z"))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for a backfilled scrape, got %d %s", rec.Code, rec.Body.String())
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &held); err != nil {
+			t.Fatal(err)
+		}
+		path = fmt.Sprintf("/api/v1/admin/quarantine/%d", held.Quarantine.ID)
+		if rec := do(t, router, http.MethodPost, path+"/reject", key, nil); rec.Code != http.StatusOK {
+			t.Fatalf("reject failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		var list QuarantinedScrapesResponse
+		rec = do(t, router, http.MethodGet, "/api/v1/admin/quarantine", key, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Scrapes) != 2 || list.Scrapes[0].Status != QuarantineRejected || list.Scrapes[1].Status != QuarantineApproved {
+			t.Fatalf("expected a rejected and an approved scrape, got %+v", list.Scrapes)
+		}
+		if list.Scrapes[0].ResolvedAt == nil || list.Scrapes[0].ResolvedBy == "" {
+			t.Errorf("expected who rejected it and when, got %+v", list.Scrapes[0])
+		}
+		var pending QuarantinedScrapesResponse
+		rec = do(t, router, http.MethodGet, "/api/v1/admin/quarantine?status=pending", key, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &pending); err != nil {
+			t.Fatal(err)
+		}
+		if len(pending.Scrapes) != 0 {
+			t.Errorf("expected no pending scrapes, got %+v", pending.Scrapes)
+		}
+		if rec := do(t, router, http.MethodGet, "/api/v1/admin/quarantine?status=held", key, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an invalid status, got %d", rec.Code)
+		}
+		if rec := do(t, router, http.MethodPost, "/api/v1/admin/quarantine/999/reject", key, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown scrape, got %d", rec.Code)
+		}
+
+		entries, _ := store.AuditLog(10)
+		if len(entries) < 2 || entries[0].Action != "quarantine.reject" || entries[1].Action != "quarantine.approve" {
+			t.Errorf("expected the approval and rejection in the audit log, got %+v", entries)
+		}
+	})
+}
+
+func TestIngestGuardDisabled(t *testing.T) {
+	defer func(saved AnomalyThresholds) { anomalyThresholds = saved }(anomalyThresholds)
+	anomalyThresholds.Enabled = false
+
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for i, products := range []map[string]string{pricedProducts(0, 30, "19.90"), pricedProducts(0, 5, "19.90")} {
+			output := scrapeOutput(time.Date(2025, 1, i+1, 6, 0, 0, 0, time.UTC).Format(time.RFC3339), map[string]map[string]string{"men/tops": products})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("expected ingest without the guard, got %d %s", rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
+
+func TestApproveKeepsQuarantineDatetime(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		first := scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": pricedProducts(0, 30, "19.90")})
+		if rec := ingest(t, router, buildScrapeZip(t, first, nil)); rec.Code != http.StatusOK {
+			t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+		}
+
+		// A scrape without a usable datetime is dated when it arrives
+		broken := scrapeOutput("yesterday", map[string]map[string]string{"men/tops": pricedProducts(0, 5, "19.90")})
+		rec := ingest(t, router, buildScrapeZip(t, broken, nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d %s", rec.Code, rec.Body.String())
+		}
+		var held IngestQuarantinedResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &held); err != nil {
+			t.Fatal(err)
+		}
+		q, err := store.GetQuarantine(held.Quarantine.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		path := fmt.Sprintf("/api/v1/admin/quarantine/%d/approve", q.ID)
+		if rec := do(t, router, http.MethodPost, path, newTestKey(t, ScopeAdmin), nil); rec.Code != http.StatusOK {
+			t.Fatalf("approve failed: %d %s", rec.Code, rec.Body.String())
+		}
+		times, err := store.ScrapeTimes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(times) != 2 || times[1].UnixMicro() != q.Datetime.UnixMicro() {
+			t.Errorf("expected the scrape dated %s as quarantined, got %v", q.Datetime, times)
+		}
+	})
+}
+
+func TestQuarantineComparesLastCoveringRun(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// The second run only scrapes tops, so innerwear is compared with the first
+		for _, output := range []map[string]any{
+			scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{
+				"men/tops":        pricedProducts(0, 30, "19.90"),
+				"women/innerwear": pricedProducts(30, 10, "9.90"),
+			}),
+			scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": pricedProducts(0, 30, "19.90")}),
+		} {
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		rec := ingest(t, router, buildScrapeZip(t, scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{
+			"men/tops":        pricedProducts(0, 30, "19.90"),
+			"women/innerwear": {},
+		}), nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected innerwear coming back empty to be quarantined, got %d %s", rec.Code, rec.Body.String())
+		}
+		var held IngestQuarantinedResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &held); err != nil {
+			t.Fatal(err)
+		}
+		if len(held.Quarantine.Anomalies) != 1 || held.Quarantine.Anomalies[0].Check != AnomalyEmptyCategories {
+			t.Errorf("expected only empty categories, got %+v", held.Quarantine.Anomalies)
+		}
+	})
+}
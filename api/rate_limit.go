@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit is a token bucket: Burst tokens, refilled at Burst per Period
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Period)
+}
+
+// RateLimits holds the limit applied to each route group
+type RateLimits struct {
+	// Public covers the product, category and listing endpoints
+	Public RateLimit
+	// Images covers product images, which a single page load fetches many of
+	Images RateLimit
+	// Ingest covers uploads from the scraper
+	Ingest RateLimit
+	// Exports covers bulk history exports, which are expensive to produce
+	Exports RateLimit
+	// Misses caps how many unknown product IDs a client may look up, to stop enumeration
+	Misses RateLimit
+	// KeyFailures caps how many invalid API keys a client IP may present, to stop key guessing
+	KeyFailures RateLimit
+}
+
+var defaultRateLimits = RateLimits{
+	Public:      RateLimit{Burst: 120, Period: time.Minute},
+	Images:      RateLimit{Burst: 600, Period: time.Minute},
+	Ingest:      RateLimit{Burst: 10, Period: time.Minute},
+	Exports:     RateLimit{Burst: 10, Period: time.Hour},
+	Misses:      RateLimit{Burst: 30, Period: time.Hour},
+	KeyFailures: RateLimit{Burst: 20, Period: time.Hour},
+}
+
+// loadRateLimits reads RATE_LIMIT_<GROUP> overrides such as RATE_LIMIT_PUBLIC=60/m
+func loadRateLimits() RateLimits {
+	limits := defaultRateLimits
+	for env, limit := range map[string]*RateLimit{
+		"RATE_LIMIT_PUBLIC":       &limits.Public,
+		"RATE_LIMIT_IMAGES":       &limits.Images,
+		"RATE_LIMIT_INGEST":       &limits.Ingest,
+		"RATE_LIMIT_EXPORTS":      &limits.Exports,
+		"RATE_LIMIT_MISSES":       &limits.Misses,
+		"RATE_LIMIT_KEY_FAILURES": &limits.KeyFailures,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		parsed, err := parseRateLimit(v)
+		if err != nil {
+			fmt.Printf("WARNING: ignoring %s: %v\n", env, err)
+			continue
+		}
+		*limit = parsed
+	}
+	return limits
+}
+
+// parseRateLimit parses "<requests>/<s|m|h>", e.g. "120/m"
+func parseRateLimit(v string) (RateLimit, error) {
+	count, unit, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected e.g. 120/m", v)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid request count in %q", v)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid period in %q, expected s, m or h", v)
+	}
+	return RateLimit{Burst: burst, Period: period}, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per client for a route group
+type rateLimiter struct {
+	name  string
+	limit RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(name string, limit RateLimit) *rateLimiter {
+	return &rateLimiter{name: name, limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// refillRate returns tokens added per second
+func (l *rateLimiter) refillRate() float64 {
+	return float64(l.limit.Burst) / l.limit.Period.Seconds()
+}
+
+// bucket returns the client's bucket refilled up to now. Callers must hold l.mu.
+func (l *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.refillRate())
+	b.last = now
+	return b
+}
+
+// sweep drops buckets that have refilled completely, so idle clients don't accumulate
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limit.Period {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.limit.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// take consumes a token if one is available, returning the tokens left and how
+// long until the next one is available
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	if b.tokens < 1 {
+		return false, 0, l.untilToken(b)
+	}
+	b.tokens--
+	return true, int(b.tokens), l.untilToken(b)
+}
+
+// peek reports whether the client has a token without consuming it
+func (l *rateLimiter) peek(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(key, now)
+	return b.tokens >= 1, l.untilToken(b)
+}
+
+// untilToken returns the wait until the bucket holds a whole token
+func (l *rateLimiter) untilToken(b *tokenBucket) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.refillRate() * float64(time.Second))
+}
+
+// highRateMultiplier scales a group's limit for keys with the read-high-rate scope
+const highRateMultiplier = 10
+
+// rateLimitIdentity identifies the client a request is charged to: the API key or
+// BasicAuth user when the request is authenticated, otherwise the client IP
+func rateLimitIdentity(c *gin.Context) string {
+	if key, ok := requestAPIKey(c); ok {
+		return "key:" + key.ID
+	}
+	if user := c.GetString(gin.AuthUserKey); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// rateLimitMiddleware charges each request to its client's bucket, setting
+// RateLimit-* headers and rejecting with 429 once the bucket is empty. Keys with
+// the read-high-rate scope draw from a separate, larger bucket.
+func rateLimitMiddleware(l *rateLimiter) gin.HandlerFunc {
+	elevated := newRateLimiter(l.name+"-high-rate", RateLimit{Burst: l.limit.Burst * highRateMultiplier, Period: l.limit.Period})
+	return func(c *gin.Context) {
+		limiter := l
+		if key, ok := requestAPIKey(c); ok && key.hasScope(ScopeReadHighRate) {
+			limiter = elevated
+		}
+		ok, remaining, wait := limiter.take(rateLimitIdentity(c), time.Now())
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limiter.limit.Burst, int(limiter.limit.Period.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(limiter.limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(wait)))
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// productIDPattern matches the IDs the scraper extracts from product URLs, e.g. E482305-000
+var productIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// productLookupGuard protects product lookups from ID enumeration: malformed IDs are
+// rejected outright, and each lookup that ends in a 404 costs the client a token from
+// the misses bucket. Clients that run out are refused until the bucket refills.
+func productLookupGuard(misses *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !productIDPattern.MatchString(c.Param("id")) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		identity := rateLimitIdentity(c)
+		if ok, wait := misses.peek(identity, time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many lookups of unknown products, try again later"})
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() == http.StatusNotFound {
+			misses.take(identity, time.Now())
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	l := newRateLimiter("test", RateLimit{Burst: 2, Period: time.Minute})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.take("a", now); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if ok, _, wait := l.take("a", now); ok || wait != 30*time.Second {
+		t.Fatalf("expected third request to be refused for 30s, got ok=%v wait=%s", ok, wait)
+	}
+	if ok, _, _ := l.take("b", now); !ok {
+		t.Fatal("other clients must have their own bucket")
+	}
+	if ok, _, _ := l.take("a", now.Add(30*time.Second)); !ok {
+		t.Fatal("expected a token after half the period")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	if l, err := parseRateLimit("60/m"); err != nil || l.Burst != 60 || l.Period != time.Minute {
+		t.Errorf("unexpected parse: %+v %v", l, err)
+	}
+	for _, bad := range []string{"60", "x/m", "0/m", "60/d"} {
+		if _, err := parseRateLimit(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRateLimitedRoutes(t *testing.T) {
+	t.Setenv("RATE_LIMIT_PUBLIC", "2/m")
+	t.Setenv("RATE_LIMIT_MISSES", "2/h")
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for i := 0; i < 2; i++ {
+			rec := get(t, router, "/api/v1/categories", nil)
+			if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "2" {
+				t.Fatalf("request %d: %d %v", i+1, rec.Code, rec.Header())
+			}
+		}
+		rec := get(t, router, "/api/v1/categories", nil)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+		}
+
+		// Image lookups have their own limit but share the misses budget with product lookups
+		for _, id := range []string{"E000001-000", "E000002-000"} {
+			if rec := get(t, router, "/api/v1/product/"+id+"/image", nil); rec.Code != http.StatusNotFound {
+				t.Fatalf("expected 404 for unknown product, got %d", rec.Code)
+			}
+		}
+		if rec := get(t, router, "/api/v1/product/E000003-000/image", nil); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected enumeration to be throttled, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/product/not%20an%20id/image", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected malformed ID to be rejected, got %d", rec.Code)
+		}
+	})
+}
+
+func TestForwardedForIgnoredWithoutTrustedProxies(t *testing.T) {
+	t.Setenv("RATE_LIMIT_PUBLIC", "2/m")
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/categories", nil)
+			req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+		}
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected a spoofed X-Forwarded-For not to get a fresh bucket, got %d", rec.Code)
+		}
+	})
+}
+
+func TestInvalidAPIKeysThrottled(t *testing.T) {
+	t.Setenv("RATE_LIMIT_KEY_FAILURES", "2/h")
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for i := 0; i < 2; i++ {
+			if rec := do(t, router, http.MethodGet, "/api/v1/categories", "upt_guessed", nil); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("guess %d: expected 401, got %d", i+1, rec.Code)
+			}
+		}
+		rec := do(t, router, http.MethodGet, "/api/v1/categories", "upt_guessed", nil)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("expected further guesses to be throttled, got %d %v", rec.Code, rec.Header())
+		}
+
+		// Anonymous requests from the same IP aren't affected
+		if rec := get(t, router, "/api/v1/categories", nil); rec.Code != http.StatusOK {
+			t.Errorf("expected anonymous requests to pass, got %d", rec.Code)
+		}
+	})
+}
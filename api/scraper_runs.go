@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultScraperRunsLimit and maxScraperRunsLimit bound how many scraper runs are returned at once
+const (
+	defaultScraperRunsLimit = 30
+	maxScraperRunsLimit     = 1000
+)
+
+// ScraperRunCategory is how many products a scraper run found in one category, and how
+// many of them failed
+type ScraperRunCategory struct {
+	Category string `json:"category"`
+	Products int    `json:"products"`
+	Failed   int    `json:"failed"`
+}
+
+// ScraperRunInfo is a scraper run as returned by the API, with its categories sorted by
+// name. Runs recorded before per-category counts were kept have the product counts
+// migration 8 rebuilt from the stored history, without failures.
+type ScraperRunInfo struct {
+	ID                int64                `json:"id"`
+	Datetime          string               `json:"datetime"`
+	ScraperVersion    string               `json:"scraper_version"`
+	DurationSeconds   float64              `json:"duration_seconds"`
+	TotalProducts     int                  `json:"total_products"`
+	TotalFailed       int                  `json:"total_failed"`
+	CategoriesScraped int                  `json:"categories_scraped"`
+	Categories        []ScraperRunCategory `json:"categories"`
+}
+
+// ScraperRunsResponse is the body returned by the scraper runs endpoint, newest run
+// first. NextOffset is set when more runs match.
+type ScraperRunsResponse struct {
+	Count      int              `json:"count"`
+	Runs       []ScraperRunInfo `json:"runs"`
+	NextOffset *int             `json:"next_offset"`
+}
+
+// categoryCounts returns the per-category counts of an upload. Categories the scraper
+// didn't report counts for are counted from the uploaded products, without failures.
+func categoryCounts(output ScraperOutput) map[string]CategoryCount {
+	counts := make(map[string]CategoryCount)
+	for _, category := range output.Metadata.Categories {
+		counts[category] = CategoryCount{Products: len(output.Products[category])}
+	}
+	for category, count := range output.Metadata.CategoryCounts {
+		counts[category] = count
+	}
+	return counts
+}
+
+// runCovers reports whether a scraper run scraped category
+func runCovers(run ScraperRun, category string) bool {
+	if _, ok := run.CategoryCounts[category]; ok {
+		return true
+	}
+	return slices.Contains(run.Categories, category)
+}
+
+// newScraperRunInfo converts a scraper run for the API. A non-empty category narrows
+// the categories to that one.
+func newScraperRunInfo(run ScraperRun, category string) ScraperRunInfo {
+	info := ScraperRunInfo{
+		ID:                run.ID,
+		Datetime:          formatTimestamp(run.Datetime),
+		ScraperVersion:    run.ScraperVersion,
+		DurationSeconds:   run.DurationSeconds,
+		TotalProducts:     run.TotalProducts,
+		TotalFailed:       run.TotalFailed,
+		CategoriesScraped: run.CategoriesScraped,
+		Categories:        []ScraperRunCategory{},
+	}
+	for c, count := range run.CategoryCounts {
+		if category != "" && c != category {
+			continue
+		}
+		info.Categories = append(info.Categories, ScraperRunCategory{Category: c, Products: count.Products, Failed: count.Failed})
+	}
+	sort.Slice(info.Categories, func(i, j int) bool {
+		return info.Categories[i].Category < info.Categories[j].Category
+	})
+	return info
+}
+
+// getScraperRuns returns the recorded scraper runs with their per-category counts,
+// newest first, optionally narrowed to the runs that scraped a category and paged with
+// limit and offset
+func getScraperRuns(c *gin.Context) {
+	category := c.Query("category")
+	limit := defaultScraperRunsLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxScraperRunsLimit)
+	}
+	offset := 0
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		offset = n
+	}
+
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scraper runs"})
+		return
+	}
+	slices.Reverse(runs)
+	if category != "" {
+		runs = slices.DeleteFunc(runs, func(r ScraperRun) bool {
+			return !runCovers(r, category)
+		})
+	}
+
+	response := ScraperRunsResponse{Runs: []ScraperRunInfo{}}
+	runs = runs[min(offset, len(runs)):]
+	if len(runs) > limit {
+		runs = runs[:limit]
+		next := offset + limit
+		response.NextOffset = &next
+	}
+	for _, run := range runs {
+		response.Runs = append(response.Runs, newScraperRunInfo(run, category))
+	}
+	response.Count = len(response.Runs)
+	c.JSON(http.StatusOK, response)
+}
+
+// getScraperRun returns a single scraper run with its per-category counts
+func getScraperRun(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a positive integer"})
+		return
+	}
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scraper runs"})
+		return
+	}
+	i := slices.IndexFunc(runs, func(r ScraperRun) bool { return r.ID == id })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newScraperRunInfo(runs[i], ""))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScraperRuns(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		// The first run has no counts from the scraper, the others report innerwear coming
+		// back empty, and the last one kids failing outright
+		runs := []struct {
+			datetime  string
+			innerwear map[string]string
+			counts    map[string]any
+		}{
+			{"2025-01-01T06:00:00Z", map[string]string{"C300": "9.90"}, nil},
+			{"2025-01-02T06:00:00Z", map[string]string{}, map[string]any{
+				"men/tops":        map[string]int{"products": 3, "failed": 1},
+				"women/innerwear": map[string]int{"products": 0, "failed": 0},
+			}},
+			{"2025-01-03T06:00:00Z", map[string]string{}, map[string]any{
+				"men/tops":        map[string]int{"products": 2, "failed": 0},
+				"women/innerwear": map[string]int{"products": 0, "failed": 0},
+				"kids":            map[string]int{"products": 0, "failed": -1},
+			}},
+		}
+		for _, r := range runs {
+			output := scrapeOutput(r.datetime, map[string]map[string]string{
+				"men/tops":        {"A100": "29.90", "B200": "19.90"},
+				"women/innerwear": r.innerwear,
+			})
+			metadata := output["metadata"].(map[string]any)
+			metadata["duration_seconds"] = 60.5
+			if r.counts != nil {
+				metadata["category_counts"] = r.counts
+			}
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var list ScraperRunsResponse
+		if rec := get(t, router, "/api/v1/scraper/runs", &list); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if list.Count != 3 || list.NextOffset != nil || list.Runs[0].Datetime != "2025-01-03T06:00:00Z" {
+			t.Fatalf("expected 3 runs newest first, got %+v", list)
+		}
+		first := list.Runs[2]
+		want := []ScraperRunCategory{{Category: "men/tops", Products: 2}, {Category: "women/innerwear", Products: 1}}
+		if first.DurationSeconds != 60.5 || first.ScraperVersion != "test" || len(first.Categories) != 2 || first.Categories[0] != want[0] || first.Categories[1] != want[1] {
+			t.Errorf("expected counts from the uploaded products, got %+v", first)
+		}
+		if got := list.Runs[1].Categories[0]; got.Products != 3 || got.Failed != 1 {
+			t.Errorf("expected the scraper's own counts, got %+v", got)
+		}
+
+		if got := list.Runs[0].Categories[0]; got != (ScraperRunCategory{Category: "kids", Failed: -1}) {
+			t.Errorf("expected kids to have failed, got %+v", got)
+		}
+
+		// Narrowing to a category shows it coming back empty
+		var innerwear ScraperRunsResponse
+		get(t, router, "/api/v1/scraper/runs?category=women/innerwear&limit=2", &innerwear)
+		if innerwear.Count != 2 || innerwear.NextOffset == nil || *innerwear.NextOffset != 2 {
+			t.Fatalf("expected a page of 2 runs, got %+v", innerwear)
+		}
+		for _, run := range innerwear.Runs {
+			if len(run.Categories) != 1 || run.Categories[0].Products != 0 {
+				t.Errorf("expected an empty women/innerwear, got %+v", run.Categories)
+			}
+		}
+
+		var run ScraperRunInfo
+		if rec := get(t, router, "/api/v1/scraper/runs/"+strconv.FormatInt(list.Runs[2].ID, 10), &run); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if run.Datetime != "2025-01-01T06:00:00Z" || len(run.Categories) != 2 {
+			t.Errorf("unexpected run %+v", run)
+		}
+		if rec := get(t, router, "/api/v1/scraper/runs/999", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown run, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/scraper/runs/latest", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an invalid id, got %d", rec.Code)
+		}
+		if rec := get(t, router, "/api/v1/scraper/runs?limit=0", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an invalid limit, got %d", rec.Code)
+		}
+	})
+}
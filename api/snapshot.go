@@ -0,0 +1,197 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// staleCategoryLimit is how far back a category missing from recent scrapes is still
+// filled in from its last covering run. Older categories are assumed to be gone.
+const staleCategoryLimit = 14 * 24 * time.Hour
+
+// coveredRun is a scrape run and the categories it covered. A nil Categories covers
+// every category, as for runs recorded without a category list or without metadata.
+type coveredRun struct {
+	Datetime   time.Time
+	Categories []string
+}
+
+// covers reports whether the run scraped any of the given categories
+func (r coveredRun) covers(categories []string) bool {
+	if r.Categories == nil {
+		return true
+	}
+	return slices.ContainsFunc(categories, func(c string) bool { return slices.Contains(r.Categories, c) })
+}
+
+// coversAll reports whether the run scraped every category other did
+func (r coveredRun) coversAll(other coveredRun) bool {
+	if r.Categories == nil {
+		return true
+	}
+	if other.Categories == nil {
+		return false
+	}
+	return !slices.ContainsFunc(other.Categories, func(c string) bool { return !slices.Contains(r.Categories, c) })
+}
+
+// coveredRuns returns every scrape run with the categories it covered, oldest first
+func coveredRuns() ([]coveredRun, error) {
+	times, err := store.ScrapeTimes()
+	if err != nil {
+		return nil, err
+	}
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[int64][]string, len(runs))
+	for _, run := range runs {
+		if len(run.Categories) > 0 {
+			listed[run.Datetime.UnixMicro()] = run.Categories
+		}
+	}
+	covered := make([]coveredRun, 0, len(times))
+	for _, t := range times {
+		covered = append(covered, coveredRun{Datetime: t, Categories: listed[t.UnixMicro()]})
+	}
+	return covered, nil
+}
+
+// categoryCoverage maps each category to the newest scrape run that covered it, up to
+// and including newest. A run covers the categories listed in its metadata; runs
+// recorded without a category list, or without metadata at all, cover every category.
+func categoryCoverage(runs []ScraperRun, categories []string, newest time.Time) map[string]time.Time {
+	coverage := make(map[string]time.Time, len(categories))
+	cover := func(category string, at time.Time) {
+		if at.After(coverage[category]) {
+			coverage[category] = at
+		}
+	}
+
+	newestRecorded := false
+	for _, run := range runs {
+		if run.Datetime.After(newest) {
+			continue
+		}
+		newestRecorded = newestRecorded || run.Datetime.Equal(newest)
+		covered := run.Categories
+		if len(covered) == 0 {
+			covered = categories
+		}
+		for _, category := range covered {
+			cover(category, run.Datetime)
+		}
+	}
+	if !newestRecorded {
+		for _, category := range categories {
+			cover(category, newest)
+		}
+	}
+
+	for category, at := range coverage {
+		if newest.Sub(at) > staleCategoryLimit {
+			delete(coverage, category)
+		}
+	}
+	return coverage
+}
+
+// Runs returns the distinct scrape runs the snapshot's products come from, oldest first
+func (s Snapshot) Runs() []time.Time {
+	runs := []time.Time{s.Datetime}
+	for _, cs := range s.Categories {
+		if !slices.ContainsFunc(runs, cs.Datetime.Equal) {
+			runs = append(runs, cs.Datetime)
+		}
+	}
+	slices.SortFunc(runs, time.Time.Compare)
+	return runs
+}
+
+// latestSnapshot returns the newest products in every category, optionally just one.
+// A category the newest scrape run didn't cover, e.g. because its scraper worker
+// crashed, is served from the last run that did, with its products marked stale.
+func latestSnapshot(category string) (Snapshot, error) {
+	var snapshot Snapshot
+	times, err := store.ScrapeTimes()
+	if err != nil || len(times) == 0 {
+		return snapshot, err
+	}
+	newest := times[len(times)-1]
+	snapshot.Datetime = newest
+
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		return snapshot, err
+	}
+	categories, err := store.Categories()
+	if err != nil {
+		return snapshot, err
+	}
+	coverage := categoryCoverage(runs, categories, newest)
+
+	if category != "" {
+		at, ok := coverage[category]
+		if !ok {
+			at = newest
+		}
+		products, err := store.RunSnapshot(at, category)
+		if err != nil {
+			return snapshot, err
+		}
+		stale := at.Before(newest)
+		for i := range products {
+			products[i].Stale = stale
+		}
+		snapshot.Products = products
+		snapshot.Categories = []CategorySnapshot{{Category: category, Datetime: at, Stale: stale}}
+		return snapshot, nil
+	}
+
+	products, err := store.RunSnapshot(newest, "")
+	if err != nil {
+		return snapshot, err
+	}
+	seen := make(map[string]bool, len(products))
+	for _, p := range products {
+		seen[p.ProductID] = true
+	}
+
+	// Group the stale categories by the run they fall back to, newest run first, so a
+	// product in several of them is taken from its most recent datapoint
+	staleRuns := make(map[time.Time][]string)
+	for c, at := range coverage {
+		snapshot.Categories = append(snapshot.Categories, CategorySnapshot{Category: c, Datetime: at, Stale: at.Before(newest)})
+		if at.Before(newest) {
+			staleRuns[at] = append(staleRuns[at], c)
+		}
+	}
+	fallbacks := make([]time.Time, 0, len(staleRuns))
+	for at := range staleRuns {
+		fallbacks = append(fallbacks, at)
+	}
+	slices.SortFunc(fallbacks, func(a, b time.Time) int { return b.Compare(a) })
+
+	for _, at := range fallbacks {
+		older, err := store.RunSnapshot(at, "")
+		if err != nil {
+			return snapshot, err
+		}
+		for _, p := range older {
+			if seen[p.ProductID] || !slices.ContainsFunc(p.Categories, func(c string) bool { return slices.Contains(staleRuns[at], c) }) {
+				continue
+			}
+			seen[p.ProductID] = true
+			p.Stale = true
+			products = append(products, p)
+		}
+	}
+
+	slices.SortFunc(snapshot.Categories, func(a, b CategorySnapshot) int {
+		return strings.Compare(a.Category, b.Category)
+	})
+	snapshot.Products = products
+	return snapshot, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPartialScrapeKeepsCategories(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		ingestRun := func(output map[string]any) {
+			t.Helper()
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+		ingestRun(scrapeOutput("2025-01-01T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "19.90"}, "women/tops": {"B200": "29.90"}}))
+		// The women/tops worker crashed, so the second run only covers men/tops
+		ingestRun(scrapeOutput("2025-01-02T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "14.90"}}))
+
+		var list ProductsListResponse
+		get(t, router, "/api/v1/products", &list)
+		if list.Count != 2 || *list.Datetime != "2025-01-02T06:00:00Z" {
+			t.Fatalf("expected both products as of the second run, got %+v", list)
+		}
+		for _, p := range list.Products {
+			wantStale := p.ProductID == "B200"
+			if p.Stale != wantStale {
+				t.Errorf("%s: expected stale=%v, got %+v", p.ProductID, wantStale, p)
+			}
+		}
+		if p := findProduct(list.Products, "B200"); p == nil || p.Datetime != "2025-01-01T06:00:00Z" {
+			t.Errorf("expected B200 from the first run, got %+v", p)
+		}
+		want := []CategorySnapshotInfo{
+			{Category: "men/tops", Datetime: "2025-01-02T06:00:00Z"},
+			{Category: "women/tops", Datetime: "2025-01-01T06:00:00Z", Stale: true},
+		}
+		if len(list.Categories) != 2 || list.Categories[0] != want[0] || list.Categories[1] != want[1] {
+			t.Errorf("unexpected category coverage: %+v", list.Categories)
+		}
+
+		var category CategoryProductsResponse
+		get(t, router, "/api/v1/category/women/tops", &category)
+		if category.Count != 1 || !category.Stale || *category.Datetime != "2025-01-01T06:00:00Z" {
+			t.Errorf("expected women/tops from the first run, got %+v", category)
+		}
+
+		var deals DealsResponse
+		get(t, router, "/api/v1/deals", &deals)
+		if deals.Count != 1 || deals.Deals[0].ProductID != "A100" || deals.Deals[0].PreviousPrice == nil {
+			t.Errorf("expected the A100 drop as the only deal, got %+v", deals.Deals)
+		}
+
+		// A full run brings the category up to date
+		ingestRun(scrapeOutput("2025-01-03T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "14.90"}, "women/tops": {"B200": "29.90"}}))
+		get(t, router, "/api/v1/category/women/tops", &category)
+		if category.Stale || *category.Datetime != "2025-01-03T06:00:00Z" {
+			t.Errorf("expected women/tops to be fresh, got %+v", category)
+		}
+
+		// A category missing for longer than staleCategoryLimit is dropped
+		ingestRun(scrapeOutput("2025-01-20T06:00:00Z", map[string]map[string]string{"men/tops": {"A100": "14.90"}}))
+		get(t, router, "/api/v1/products", &list)
+		if list.Count != 1 || len(list.Categories) != 1 || list.Products[0].ProductID != "A100" {
+			t.Errorf("expected women/tops to be dropped, got %+v", list)
+		}
+	})
+}
+
+// findProduct returns the product with the given ID, or nil
+func findProduct(products []ProductResponse, productID string) *ProductResponse {
+	for i := range products {
+		if products[i].ProductID == productID {
+			return &products[i]
+		}
+	}
+	return nil
+}
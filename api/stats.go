@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExtendedStatsInfo is the API form of ExtendedStats. Prices and percentages are
+// rounded to cents; the sale lengths and last sale are null for a product that was
+// never on sale.
+type ExtendedStatsInfo struct {
+	AveragePrice      float64 `json:"average_price"`
+	MedianPrice       float64 `json:"median_price"`
+	SaleDaysPercent   float64 `json:"sale_days_percent"`
+	SaleEpisodes      int     `json:"sale_episodes"`
+	AverageSaleDepth  float64 `json:"average_sale_depth_percent"`
+	LongestSaleDays   *int    `json:"longest_sale_days"`
+	ShortestSaleDays  *int    `json:"shortest_sale_days"`
+	LastSaleDatetime  *string `json:"last_sale_datetime"`
+	DaysSinceLastSale *int    `json:"days_since_last_sale"`
+	Low30Day          float64 `json:"low_30_day"`
+	Low90Day          float64 `json:"low_90_day"`
+}
+
+// newExtendedStatsInfo formats extended stats for a response. onSale reports whether
+// the product's current price is a sale, which puts its last sale at zero days ago.
+func newExtendedStatsInfo(ext ExtendedStats, onSale bool, now time.Time) *ExtendedStatsInfo {
+	info := &ExtendedStatsInfo{
+		AveragePrice:     roundTo(ext.AveragePrice, 2),
+		MedianPrice:      roundTo(ext.MedianPrice, 2),
+		SaleDaysPercent:  roundTo(ext.SaleDaysPercent, 2),
+		SaleEpisodes:     ext.SaleEpisodes,
+		AverageSaleDepth: roundTo(ext.AverageSaleDepth, 2),
+		LastSaleDatetime: formatDatetime(ext.LastSaleDatetime),
+		Low30Day:         ext.Low30Day,
+		Low90Day:         ext.Low90Day,
+	}
+	if ext.SaleEpisodes > 0 {
+		longest, shortest := ext.LongestSaleDays, ext.ShortestSaleDays
+		info.LongestSaleDays, info.ShortestSaleDays = &longest, &shortest
+	}
+	if !ext.LastSaleDatetime.IsZero() {
+		days := 0
+		if !onSale {
+			days = int(now.Sub(ext.LastSaleDatetime).Hours() / 24)
+		}
+		info.DaysSinceLastSale = &days
+	}
+	return info
+}
+
+// includeStats reads the stats query parameter that adds extended stats to product lists
+func includeStats(c *gin.Context) (bool, error) {
+	v := c.Query("stats")
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// withoutStats returns a copy of products with their extended stats left out
+func withoutStats(products []ProductResponse) []ProductResponse {
+	trimmed := make([]ProductResponse, len(products))
+	for i, p := range products {
+		p.Stats = nil
+		trimmed[i] = p
+	}
+	return trimmed
+}
+
+// withStats returns the response with or without its products' extended stats. The
+// cached response always carries them.
+func (r *ProductsListResponse) withStats(include bool) *ProductsListResponse {
+	if include {
+		return r
+	}
+	trimmed := *r
+	trimmed.Products = withoutStats(r.Products)
+	return &trimmed
+}
+
+// saleDaysBetween counts the days a sale episode lasted, rounding part days up
+func saleDaysBetween(start, end time.Time) int {
+	return max(1, int(math.Ceil(end.Sub(start).Hours()/24)))
+}
+
+// saleEpisode is a run of consecutive datapoints below the regular price. It ends at
+// the first datapoint back at or above regular, or at the newest datapoint while the
+// sale is still on. Low is the lowest price during the sale.
+type saleEpisode struct {
+	Start   time.Time
+	End     time.Time
+	Low     float64
+	Ongoing bool
+}
+
+// days is how long the sale lasted
+func (e saleEpisode) days() int {
+	return saleDaysBetween(e.Start, e.End)
+}
+
+// saleEpisodes splits a product's datapoints, oldest first, into its sales against
+// its regular price
+func saleEpisodes(history []ProductRecord, regularPrice float64) []saleEpisode {
+	var episodes []saleEpisode
+	var current *saleEpisode
+	for _, r := range history {
+		if r.Price >= regularPrice {
+			if current != nil {
+				current.End = r.Datetime
+				episodes = append(episodes, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			current = &saleEpisode{Start: r.Datetime, Low: r.Price}
+		}
+		current.Low = min(current.Low, r.Price)
+	}
+	if current != nil {
+		current.End = history[len(history)-1].Datetime
+		current.Ongoing = true
+		episodes = append(episodes, *current)
+	}
+	return episodes
+}
+
+// extendedStatsFromHistory computes a product's extended stats from its datapoints,
+// oldest first, against its regular price
+func extendedStatsFromHistory(history []ProductRecord, regularPrice float64) ExtendedStats {
+	var ext ExtendedStats
+	if len(history) == 0 {
+		return ext
+	}
+
+	prices := make([]float64, 0, len(history))
+	var total, depth float64
+	var saleDatapoints int
+	days := make(map[string]bool)
+	saleDays := make(map[string]bool)
+	for _, r := range history {
+		prices = append(prices, r.Price)
+		total += r.Price
+		day := r.Datetime.UTC().Format(time.DateOnly)
+		days[day] = true
+		if r.Price < regularPrice {
+			saleDatapoints++
+			depth += (regularPrice - r.Price) / regularPrice * 100
+			saleDays[day] = true
+			ext.LastSaleDatetime = r.Datetime
+		}
+	}
+
+	for i, e := range saleEpisodes(history, regularPrice) {
+		if i == 0 || e.days() > ext.LongestSaleDays {
+			ext.LongestSaleDays = e.days()
+		}
+		if i == 0 || e.days() < ext.ShortestSaleDays {
+			ext.ShortestSaleDays = e.days()
+		}
+		ext.SaleEpisodes++
+	}
+
+	newest := history[len(history)-1].Datetime
+	ext.AveragePrice = total / float64(len(history))
+	ext.MedianPrice = medianOf(prices)
+	ext.SaleDaysPercent = float64(len(saleDays)) / float64(len(days)) * 100
+	if saleDatapoints > 0 {
+		ext.AverageSaleDepth = depth / float64(saleDatapoints)
+	}
+	ext.Low30Day = lowSince(history, newest.AddDate(0, 0, -30))
+	ext.Low90Day = lowSince(history, newest.AddDate(0, 0, -90))
+	return ext
+}
+
+// medianOf returns the median of the given prices, averaging the middle two of an
+// even count
+func medianOf(prices []float64) float64 {
+	sorted := slices.Clone(prices)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// lowSince returns the lowest price among datapoints at or after since
+func lowSince(history []ProductRecord, since time.Time) float64 {
+	low := math.Inf(1)
+	for _, r := range history {
+		if !r.Datetime.Before(since) && r.Price < low {
+			low = r.Price
+		}
+	}
+	return low
+}
+
+// updateRunExtendedStats recomputes the extended stats of every product in the scrape
+// run at datetime once its datapoints have been folded into their stats rows. The
+// histories are read in one pass rather than a query per product.
+func updateRunExtendedStats(datetime time.Time) error {
+	return store.RunHistories(datetime, func(history []ProductRecord) error {
+		productID := history[0].ProductID
+		if err := store.PutExtendedStats(productID, statsFromHistory(history).Extended); err != nil {
+			fmt.Printf("WARNING: extended stats failed for %s: %v\n", productID, err)
+		}
+		return nil
+	})
+}
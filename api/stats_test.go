@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// salePrices is a product with two sales against a regular price of 29.90: two days
+// at 19.90, then half a day at 24.90
+var salePrices = []struct {
+	datetime string
+	price    string
+}{
+	{"2025-03-01T06:00:00Z", "29.90"},
+	{"2025-03-02T06:00:00Z", "19.90"},
+	{"2025-03-03T06:00:00Z", "19.90"},
+	{"2025-03-04T06:00:00Z", "29.90"},
+	{"2025-03-05T06:00:00Z", "29.90"},
+	{"2025-03-06T06:00:00Z", "24.90"},
+	{"2025-03-06T18:00:00Z", "29.90"},
+	{"2025-04-05T06:00:00Z", "29.90"},
+}
+
+func TestExtendedStatsFromHistory(t *testing.T) {
+	var history []ProductRecord
+	for _, p := range salePrices {
+		datetime, _ := time.Parse(time.RFC3339, p.datetime)
+		price := map[string]float64{"29.90": 29.90, "24.90": 24.90, "19.90": 19.90}[p.price]
+		history = append(history, ProductRecord{ProductID: "E100", Price: price, Datetime: datetime})
+	}
+
+	ext := statsFromHistory(history).Extended
+	approx := func(name string, got, want float64) {
+		t.Helper()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+	approx("average price", ext.AveragePrice, (29.90*5+19.90*2+24.90)/8)
+	approx("median price", ext.MedianPrice, 29.90)
+	approx("sale days percent", ext.SaleDaysPercent, 3.0/7*100)
+	approx("average sale depth", ext.AverageSaleDepth, ((10/29.90)*2+5/29.90)/3*100)
+	approx("30-day low", ext.Low30Day, 24.90)
+	approx("90-day low", ext.Low90Day, 19.90)
+	if ext.SaleEpisodes != 2 || ext.LongestSaleDays != 2 || ext.ShortestSaleDays != 1 {
+		t.Errorf("expected 2 sales of 2 and 1 days, got %d of %d to %d days", ext.SaleEpisodes, ext.LongestSaleDays, ext.ShortestSaleDays)
+	}
+	if want := history[5].Datetime; !ext.LastSaleDatetime.Equal(want) {
+		t.Errorf("expected last sale at %v, got %v", want, ext.LastSaleDatetime)
+	}
+
+	// A product that never went on sale has no sale lengths
+	never := extendedStatsFromHistory(history[:1], 29.90)
+	if never.SaleEpisodes != 0 || !never.LastSaleDatetime.IsZero() || never.SaleDaysPercent != 0 {
+		t.Errorf("expected no sales, got %+v", never)
+	}
+	info := newExtendedStatsInfo(never, false, time.Now())
+	if info.LongestSaleDays != nil || info.DaysSinceLastSale != nil || info.LastSaleDatetime != nil {
+		t.Errorf("expected null sale fields, got %+v", info)
+	}
+}
+
+func TestExtendedStatsEndpoints(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		for _, p := range salePrices {
+			output := scrapeOutput(p.datetime, map[string]map[string]string{"men/tops": {"E100": p.price}})
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var detail ProductDetailResponse
+		get(t, router, "/api/v1/product/E100", &detail)
+		stats := detail.Stats
+		if stats.MedianPrice != 29.90 || stats.SaleEpisodes != 2 || stats.Low30Day != 24.90 || stats.Low90Day != 19.90 {
+			t.Errorf("unexpected extended stats: %+v", stats)
+		}
+		if stats.SaleDaysPercent != 42.86 || stats.AveragePrice != roundTo((29.90*5+19.90*2+24.90)/8, 2) {
+			t.Errorf("expected 42.86%% of days on sale and a rounded average, got %+v", stats)
+		}
+		if stats.LongestSaleDays == nil || *stats.LongestSaleDays != 2 || stats.ShortestSaleDays == nil || *stats.ShortestSaleDays != 1 {
+			t.Errorf("expected sales of 2 and 1 days, got %v and %v", stats.LongestSaleDays, stats.ShortestSaleDays)
+		}
+		if stats.LastSaleDatetime == nil || *stats.LastSaleDatetime != "2025-03-06T06:00:00Z" {
+			t.Errorf("expected last sale on 2025-03-06, got %v", stats.LastSaleDatetime)
+		}
+		if stats.DaysSinceLastSale == nil || *stats.DaysSinceLastSale <= 0 {
+			t.Errorf("expected days since the last sale to be counted, got %v", stats.DaysSinceLastSale)
+		}
+
+		// Recomputing from history gives the stats ingest accumulated
+		want := detail.Stats
+		key := newTestKey(t, ScopeAdmin)
+		if rec := do(t, router, http.MethodPost, "/api/v1/admin/products/E100/recompute-stats", key, nil); rec.Code != http.StatusOK {
+			t.Fatalf("recompute failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/product/E100", &detail)
+		if a, b := mustJSON(t, detail.Stats), mustJSON(t, want); a != b {
+			t.Errorf("recomputed stats differ:\n  got  %s\n  want %s", a, b)
+		}
+
+		// Product lists only carry the extended stats on request
+		var raw struct {
+			Products []map[string]json.RawMessage `json:"products"`
+		}
+		get(t, router, "/api/v1/products", &raw)
+		if _, ok := raw.Products[0]["stats"]; ok {
+			t.Errorf("expected no stats without stats=true")
+		}
+		var list ProductsListResponse
+		get(t, router, "/api/v1/products?stats=true", &list)
+		if p := findProduct(list.Products, "E100"); p == nil || p.Stats == nil || p.Stats.SaleEpisodes != 2 {
+			t.Errorf("expected extended stats with stats=true, got %+v", p)
+		}
+		var category CategoryProductsResponse
+		get(t, router, "/api/v1/category/men/tops?stats=true", &category)
+		if p := findProduct(category.Products, "E100"); p == nil || p.Stats == nil || p.Stats.MedianPrice != 29.90 {
+			t.Errorf("expected extended stats in the category with stats=true, got %+v", p)
+		}
+		if rec := get(t, router, "/api/v1/products?stats=maybe", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an invalid stats flag, got %d", rec.Code)
+		}
+	})
+}
+
+// mustJSON encodes v for comparing responses
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Store lookups when no matching row exists
+var ErrNotFound = errors.New("not found")
+
+// ProductRecord is a single row of scraped product history
+type ProductRecord struct {
+	ProductID  string
+	Name       string
+	Price      float64
+	URL        string
+	Categories []string
+	Datetime   time.Time
+}
+
+// ProductStats holds the tracked price statistics for a product
+type ProductStats struct {
+	LowestPrice          float64
+	LowestPriceDatetime  time.Time
+	HighestPrice         float64
+	HighestPriceDatetime time.Time
+	RegularPrice         float64
+	Extended             ExtendedStats
+}
+
+// ExtendedStats describes how a product's price behaves over its whole history. A
+// datapoint below the regular price is on sale, and consecutive sale datapoints form
+// one sale episode. The 30 and 90-day lows look back from the product's newest
+// datapoint. LastSaleDatetime is zero when the product was never on sale.
+type ExtendedStats struct {
+	AveragePrice     float64
+	MedianPrice      float64
+	SaleDaysPercent  float64
+	SaleEpisodes     int
+	AverageSaleDepth float64
+	LongestSaleDays  int
+	ShortestSaleDays int
+	LastSaleDatetime time.Time
+	Low30Day         float64
+	Low90Day         float64
+}
+
+// Snapshot is the latest view of the catalogue. Datetime is the newest scrape run;
+// categories it didn't cover are filled in from the last run that did, and their
+// products are marked stale.
+type Snapshot struct {
+	Datetime   time.Time
+	Products   []ProductResponse
+	Categories []CategorySnapshot
+}
+
+// CategorySnapshot records which scrape run a category's products come from in a
+// Snapshot. Stale is set when that isn't the newest run.
+type CategorySnapshot struct {
+	Category string
+	Datetime time.Time
+	Stale    bool
+}
+
+// ScraperRun is the metadata recorded for a single scraper upload. CategoryCounts is
+// nil for runs recorded before per-category counts were kept.
+type ScraperRun struct {
+	ID                int64
+	Datetime          time.Time
+	ScraperVersion    string
+	TotalProducts     int
+	TotalFailed       int
+	CategoriesScraped int
+	Categories        []string
+	DurationSeconds   float64
+	CategoryCounts    map[string]CategoryCount
+}
+
+// Quarantined scrape statuses
+const (
+	QuarantinePending  = "pending"
+	QuarantineApproved = "approved"
+	QuarantineRejected = "rejected"
+)
+
+// QuarantinedScrape is an upload the ingest guard held back until an admin approves
+// or rejects it. Archive holds the uploaded ZIP while it is pending.
+type QuarantinedScrape struct {
+	ID             int64
+	Datetime       time.Time
+	ReceivedAt     time.Time
+	ScraperVersion string
+	Products       int
+	Anomalies      []Anomaly
+	Archive        []byte
+	Status         string
+	ResolvedAt     time.Time
+	ResolvedBy     string
+}
+
+// APIKey is a stored API key. Only the SHA-256 hash of the secret is kept.
+type APIKey struct {
+	ID         string
+	Name       string
+	Hash       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// ExportFilter restricts an export to datapoints in [From, To) tagged with Category.
+// Zero values don't filter.
+type ExportFilter struct {
+	From     time.Time
+	To       time.Time
+	Category string
+}
+
+// ExportRow is a datapoint joined with its product's stats
+type ExportRow struct {
+	ProductRecord
+	LowestPrice  float64
+	HighestPrice float64
+	RegularPrice float64
+}
+
+// PriceRank compares a product's price in a scrape run with its whole history:
+// Datapoints is how many prices were recorded and AtOrBelow how many of them were no
+// higher than the price in the run
+type PriceRank struct {
+	Datapoints int
+	AtOrBelow  int
+}
+
+// Product lifecycle statuses
+const (
+	LifecycleNew          = "new"
+	LifecycleActive       = "active"
+	LifecycleMissing      = "missing"
+	LifecycleDiscontinued = "discontinued"
+)
+
+// ProductLifecycle tracks when a product was first and last scraped. Name, URL,
+// Categories and Price are from the last datapoint. MissedRuns counts the scrape runs
+// since LastSeen and Status is derived from it.
+type ProductLifecycle struct {
+	ProductID  string
+	Name       string
+	URL        string
+	Categories []string
+	Price      float64
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	MissedRuns int
+	Status     string
+}
+
+// HiddenProduct is a product withheld from public listings by an admin
+type HiddenProduct struct {
+	ProductID string
+	Reason    string
+	HiddenAt  time.Time
+}
+
+// AuditEntry records a single admin change. Details holds a JSON object describing it.
+type AuditEntry struct {
+	ID      int64
+	At      time.Time
+	Actor   string
+	Action  string
+	Target  string
+	Details string
+}
+
+// Price change event types
+const (
+	ChangeTypePrice   = "price"
+	ChangeTypeNew     = "new"
+	ChangeTypeRemoved = "removed"
+)
+
+// PriceChange is an event recorded when a scrape run differs from the run before it.
+// Price events carry both prices; new products have no OldPrice and removed products
+// no NewPrice. AllTimeLow marks drops below every earlier price. Datetime is the run
+// the change was seen in.
+type PriceChange struct {
+	ID            int64
+	ProductID     string
+	Name          string
+	URL           string
+	Categories    []string
+	Type          string
+	OldPrice      *float64
+	NewPrice      *float64
+	PercentChange *float64
+	Direction     string
+	AllTimeLow    bool
+	Datetime      time.Time
+}
+
+// PriceChangeFilter selects price change events in [Since, Until). Zero fields match
+// everything.
+type PriceChangeFilter struct {
+	Since      time.Time
+	Until      time.Time
+	Type       string
+	Direction  string
+	AllTimeLow bool
+	Category   string
+	Limit      int
+	Offset     int
+}
+
+// Store is the persistence layer used by the API handlers
+type Store interface {
+	// Ping checks that the underlying database is reachable
+	Ping() error
+	// Close releases any resources held by the store
+	Close() error
+	// Migrate applies pending schema migrations, returning the ones it ran
+	Migrate() ([]MigrationStatus, error)
+	// Migrations reports every known migration and whether it has been applied
+	Migrations() ([]MigrationStatus, error)
+
+	// InsertProduct records a product datapoint
+	InsertProduct(p ProductRecord) error
+	// ProductHistory returns every datapoint for a product, oldest first
+	ProductHistory(productID string) ([]ProductRecord, error)
+	// RunSnapshot returns the products recorded at exactly datetime with their stats,
+	// optionally filtered by category. Hidden products are left out.
+	RunSnapshot(datetime time.Time, category string) ([]ProductResponse, error)
+	// ScrapeTimes returns the distinct datetimes products were recorded at, oldest first
+	ScrapeTimes() ([]time.Time, error)
+	// ScrapeProducts returns every datapoint recorded at exactly datetime, hidden
+	// products included
+	ScrapeProducts(datetime time.Time) ([]ProductRecord, error)
+	// RunHistories calls fn with the full history, oldest first, of each product
+	// recorded at exactly datetime, in one pass. fn may write to the store.
+	RunHistories(datetime time.Time, fn func(history []ProductRecord) error) error
+	// PriceRanks returns the PriceRank of every product recorded at exactly datetime,
+	// keyed by product ID
+	PriceRanks(datetime time.Time) (map[string]PriceRank, error)
+	// LowestPricesBefore returns the lowest price each product recorded at exactly
+	// datetime was seen at before it, keyed by product ID. Products first seen at
+	// datetime are left out.
+	LowestPricesBefore(datetime time.Time) (map[string]float64, error)
+	// ExportHistory calls fn for every datapoint matching filter, oldest first, joined
+	// with the product's stats. Hidden products are left out. Rows are streamed, so fn
+	// must not call back into the store.
+	ExportHistory(filter ExportFilter, fn func(ExportRow) error) error
+	// ProductIDs returns every product ID with at least one datapoint
+	ProductIDs() ([]string, error)
+	// UpdateDatapoints sets the price of a product's datapoints in [from, to),
+	// returning how many were changed
+	UpdateDatapoints(productID string, from, to time.Time, price float64) (int64, error)
+	// DeleteDatapoints removes a product's datapoints in [from, to), returning how
+	// many were removed
+	DeleteDatapoints(productID string, from, to time.Time) (int64, error)
+	// DeleteScrape removes every datapoint, scraper run and price change in [from, to),
+	// returning the IDs of the products that lost datapoints
+	DeleteScrape(from, to time.Time) ([]string, error)
+
+	// ReplacePriceChanges replaces the price change events recorded for the scrape run at datetime
+	ReplacePriceChanges(datetime time.Time, changes []PriceChange) error
+	// PriceChanges returns the events matching filter, newest run first. Hidden
+	// products are left out.
+	PriceChanges(filter PriceChangeFilter) ([]PriceChange, error)
+
+	// GetStats returns the stats row for a product, or ErrNotFound
+	GetStats(productID string) (ProductStats, error)
+	// UpdateStats folds a price observation into the product's stats. Observations may
+	// arrive in any order; older ones are placed by their datetime.
+	UpdateStats(productID string, price float64, datetime time.Time) error
+	// PutStats replaces the stats row for a product
+	PutStats(productID string, stats ProductStats) error
+	// PutExtendedStats replaces the extended stats of a product's existing stats row
+	PutExtendedStats(productID string, ext ExtendedStats) error
+	// DeleteStats removes the stats row for a product, if any
+	DeleteStats(productID string) error
+
+	// UpdateLifecycle folds a datapoint into the product's lifecycle, moving FirstSeen
+	// back or LastSeen forward. Like UpdateStats, datapoints may arrive in any order.
+	UpdateLifecycle(p ProductRecord) error
+	// PutLifecycles inserts or replaces the lifecycle rows of the given products
+	PutLifecycles(lifecycles []ProductLifecycle) error
+	// DeleteLifecycle removes the lifecycle row for a product, if any
+	DeleteLifecycle(productID string) error
+	// GetLifecycle returns the lifecycle row for a product, or ErrNotFound
+	GetLifecycle(productID string) (ProductLifecycle, error)
+	// Lifecycles returns every lifecycle row with the given status, or all of them for
+	// an empty status, ordered by product ID. Hidden products are included.
+	Lifecycles(status string) ([]ProductLifecycle, error)
+
+	// GetImage returns the stored image for a product, or ErrNotFound
+	GetImage(productID string) ([]byte, error)
+	// SaveImage inserts or replaces the image for a product
+	SaveImage(productID string, image []byte) error
+
+	// Categories returns every known category
+	Categories() ([]string, error)
+	// AddCategory records a category if it isn't already known
+	AddCategory(category string) error
+
+	// InsertScraperRun records the metadata of a scraper upload and its per-category
+	// counts, returning the run's ID
+	InsertScraperRun(run ScraperRun) (int64, error)
+	// ScraperRuns returns every recorded scraper run, oldest first
+	ScraperRuns() ([]ScraperRun, error)
+
+	// InsertQuarantine stores a held back upload, returning its ID
+	InsertQuarantine(q QuarantinedScrape) (int64, error)
+	// QuarantinedScrapes returns every quarantined upload with the given status, or all
+	// of them for an empty status, newest first and without their archives
+	QuarantinedScrapes(status string) ([]QuarantinedScrape, error)
+	// GetQuarantine returns a quarantined upload with its archive, or ErrNotFound
+	GetQuarantine(id int64) (QuarantinedScrape, error)
+	// ResolveQuarantine approves or rejects a pending upload and drops its archive,
+	// returning ErrNotFound unless it is pending
+	ResolveQuarantine(id int64, status, by string, at time.Time) error
+
+	// HideProduct withholds a product from public endpoints
+	HideProduct(p HiddenProduct) error
+	// UnhideProduct makes a hidden product public again, returning ErrNotFound if it wasn't hidden
+	UnhideProduct(productID string) error
+	// HiddenProducts returns every hidden product, most recently hidden first
+	HiddenProducts() ([]HiddenProduct, error)
+	// IsHidden reports whether a product is hidden
+	IsHidden(productID string) (bool, error)
+
+	// InsertAuditEntry appends an entry to the audit log
+	InsertAuditEntry(e AuditEntry) error
+	// AuditLog returns up to limit audit entries, newest first
+	AuditLog(limit int) ([]AuditEntry, error)
+
+	// CreateAPIKey stores a new API key
+	CreateAPIKey(key APIKey) error
+	// APIKeyByHash returns the key with the given secret hash, or ErrNotFound
+	APIKeyByHash(hash string) (APIKey, error)
+	// ListAPIKeys returns every key, newest first
+	ListAPIKeys() ([]APIKey, error)
+	// RevokeAPIKey marks a key as revoked, returning ErrNotFound for unknown IDs
+	RevokeAPIKey(id string, at time.Time) error
+	// TouchAPIKey records that a key was just used
+	TouchAPIKey(id string, at time.Time) error
+}
+
+var store Store
+
+// openStore opens the Store named by a database URL: sqlite://path selects the
+// embedded SQLite backend, anything else is passed to the Postgres driver
+func openStore(databaseURL string) (Store, error) {
+	if path, ok := sqlitePath(databaseURL); ok {
+		return newSQLiteStore(path)
+	}
+	return newPostgresStore(databaseURL)
+}
+
+// isAllTimeLow reports whether a price matches a lowest price that is below regular
+func isAllTimeLow(price, lowestPrice, regularPrice float64) bool {
+	return price <= lowestPrice && lowestPrice < regularPrice
+}
+
+// statsFromHistory computes a product's stats from its datapoints, oldest first, the
+// same way UpdateStats accumulates them: lowest and highest keep the first time the
+// price was seen and regular is the mode. The extended stats are computed against that
+// regular price.
+func statsFromHistory(history []ProductRecord) ProductStats {
+	var st ProductStats
+	prices := make([]float64, 0, len(history))
+	for i, r := range history {
+		if i == 0 || r.Price < st.LowestPrice {
+			st.LowestPrice = r.Price
+			st.LowestPriceDatetime = r.Datetime
+		}
+		if i == 0 || r.Price > st.HighestPrice {
+			st.HighestPrice = r.Price
+			st.HighestPriceDatetime = r.Datetime
+		}
+		prices = append(prices, r.Price)
+	}
+	st.RegularPrice = regularPriceOf(prices)
+	st.Extended = extendedStatsFromHistory(history, st.RegularPrice)
+	return st
+}
+
+// regularPriceOf returns the mode of the given prices, preferring the higher price on ties
+func regularPriceOf(prices []float64) float64 {
+	priceCount := make(map[float64]int)
+	for _, p := range prices {
+		priceCount[p]++
+	}
+	var regularPrice float64
+	maxCount := 0
+	for price, count := range priceCount {
+		if count > maxCount || (count == maxCount && price > regularPrice) {
+			maxCount = count
+			regularPrice = price
+		}
+	}
+	return regularPrice
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store held entirely in memory, used by tests and local experiments
+type memoryStore struct {
+	mu          sync.RWMutex
+	products    []ProductRecord
+	stats       map[string]ProductStats
+	images      map[string][]byte
+	categories  []string
+	scraperRuns []ScraperRun
+	hidden      map[string]HiddenProduct
+	auditLog    []AuditEntry
+	apiKeys     []APIKey
+	changes     []PriceChange
+	nextChange  int64
+	nextRun     int64
+	lifecycles  map[string]ProductLifecycle
+	quarantine  []QuarantinedScrape
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		stats:      make(map[string]ProductStats),
+		images:     make(map[string][]byte),
+		hidden:     make(map[string]HiddenProduct),
+		lifecycles: make(map[string]ProductLifecycle),
+	}
+}
+
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// Migrate is a no-op: the memory store has no schema
+func (s *memoryStore) Migrate() ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (s *memoryStore) Migrations() ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (s *memoryStore) InsertProduct(p ProductRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Categories = slices.Clone(p.Categories)
+	s.products = append(s.products, p)
+	return nil
+}
+
+func (s *memoryStore) ProductHistory(productID string) ([]ProductRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var records []ProductRecord
+	for _, p := range s.products {
+		if p.ProductID == productID {
+			records = append(records, p)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Datetime.Before(records[j].Datetime)
+	})
+	return records, nil
+}
+
+func (s *memoryStore) RunSnapshot(datetime time.Time, category string) ([]ProductResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var products []ProductResponse
+	for _, p := range s.products {
+		if !p.Datetime.Equal(datetime) {
+			continue
+		}
+		if _, hidden := s.hidden[p.ProductID]; hidden {
+			continue
+		}
+		if category != "" && !slices.Contains(p.Categories, category) {
+			continue
+		}
+		resp := ProductResponse{
+			ProductID:    p.ProductID,
+			Name:         p.Name,
+			Price:        p.Price,
+			URL:          p.URL,
+			Categories:   slices.Clone(p.Categories),
+			Datetime:     formatTimestamp(p.Datetime),
+			LowestPrice:  p.Price,
+			RegularPrice: p.Price,
+		}
+		if st, ok := s.stats[p.ProductID]; ok {
+			resp.LowestPrice = st.LowestPrice
+			resp.RegularPrice = st.RegularPrice
+			resp.Stats = newExtendedStatsInfo(st.Extended, p.Price < st.RegularPrice, time.Now())
+		}
+		resp.IsAllTimeLow = isAllTimeLow(resp.Price, resp.LowestPrice, resp.RegularPrice)
+		products = append(products, resp)
+	}
+	return products, nil
+}
+
+func (s *memoryStore) ScrapeTimes() ([]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var times []time.Time
+	for _, p := range s.products {
+		if !slices.ContainsFunc(times, p.Datetime.Equal) {
+			times = append(times, p.Datetime)
+		}
+	}
+	slices.SortFunc(times, time.Time.Compare)
+	return times, nil
+}
+
+func (s *memoryStore) ScrapeProducts(datetime time.Time) ([]ProductRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var records []ProductRecord
+	for _, p := range s.products {
+		if p.Datetime.Equal(datetime) {
+			p.Categories = slices.Clone(p.Categories)
+			records = append(records, p)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ProductID < records[j].ProductID
+	})
+	return records, nil
+}
+
+func (s *memoryStore) RunHistories(datetime time.Time, fn func(history []ProductRecord) error) error {
+	s.mu.RLock()
+	histories := make(map[string][]ProductRecord)
+	for _, p := range s.products {
+		if p.Datetime.Equal(datetime) {
+			histories[p.ProductID] = nil
+		}
+	}
+	for _, p := range s.products {
+		if history, ok := histories[p.ProductID]; ok {
+			p.Categories = slices.Clone(p.Categories)
+			histories[p.ProductID] = append(history, p)
+		}
+	}
+	s.mu.RUnlock()
+
+	// fn is called without the lock held, since it may write to the store
+	for _, id := range slices.Sorted(maps.Keys(histories)) {
+		history := histories[id]
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Datetime.Before(history[j].Datetime)
+		})
+		if err := fn(history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) PriceRanks(datetime time.Time) (map[string]PriceRank, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	current := make(map[string]float64)
+	for _, p := range s.products {
+		if p.Datetime.Equal(datetime) {
+			current[p.ProductID] = p.Price
+		}
+	}
+	ranks := make(map[string]PriceRank, len(current))
+	for _, p := range s.products {
+		price, ok := current[p.ProductID]
+		if !ok {
+			continue
+		}
+		rank := ranks[p.ProductID]
+		rank.Datapoints++
+		if p.Price <= price {
+			rank.AtOrBelow++
+		}
+		ranks[p.ProductID] = rank
+	}
+	return ranks, nil
+}
+
+func (s *memoryStore) LowestPricesBefore(datetime time.Time) (map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	current := make(map[string]bool)
+	for _, p := range s.products {
+		if p.Datetime.Equal(datetime) {
+			current[p.ProductID] = true
+		}
+	}
+	lowest := make(map[string]float64, len(current))
+	for _, p := range s.products {
+		if !current[p.ProductID] || !p.Datetime.Before(datetime) {
+			continue
+		}
+		if price, ok := lowest[p.ProductID]; !ok || p.Price < price {
+			lowest[p.ProductID] = p.Price
+		}
+	}
+	return lowest, nil
+}
+
+func (s *memoryStore) ExportHistory(filter ExportFilter, fn func(ExportRow) error) error {
+	s.mu.RLock()
+	var rows []ExportRow
+	for _, p := range s.products {
+		if _, hidden := s.hidden[p.ProductID]; hidden {
+			continue
+		}
+		if !filter.From.IsZero() && p.Datetime.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !p.Datetime.Before(filter.To) {
+			continue
+		}
+		if filter.Category != "" && !slices.Contains(p.Categories, filter.Category) {
+			continue
+		}
+		row := ExportRow{ProductRecord: p, LowestPrice: p.Price, HighestPrice: p.Price, RegularPrice: p.Price}
+		row.Categories = slices.Clone(p.Categories)
+		if st, ok := s.stats[p.ProductID]; ok {
+			row.LowestPrice, row.HighestPrice, row.RegularPrice = st.LowestPrice, st.HighestPrice, st.RegularPrice
+		}
+		rows = append(rows, row)
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].Datetime.Equal(rows[j].Datetime) {
+			return rows[i].Datetime.Before(rows[j].Datetime)
+		}
+		return rows[i].ProductID < rows[j].ProductID
+	})
+	for _, row := range rows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) ProductIDs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for _, p := range s.products {
+		if !slices.Contains(ids, p.ProductID) {
+			ids = append(ids, p.ProductID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// inRange reports whether t falls within [from, to)
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+func (s *memoryStore) UpdateDatapoints(productID string, from, to time.Time, price float64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for i, p := range s.products {
+		if p.ProductID == productID && inRange(p.Datetime, from, to) {
+			s.products[i].Price = price
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) DeleteDatapoints(productID string, from, to time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.products)
+	s.products = slices.DeleteFunc(s.products, func(p ProductRecord) bool {
+		return p.ProductID == productID && inRange(p.Datetime, from, to)
+	})
+	return int64(before - len(s.products)), nil
+}
+
+func (s *memoryStore) DeleteScrape(from, to time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	s.products = slices.DeleteFunc(s.products, func(p ProductRecord) bool {
+		if !inRange(p.Datetime, from, to) {
+			return false
+		}
+		if !slices.Contains(ids, p.ProductID) {
+			ids = append(ids, p.ProductID)
+		}
+		return true
+	})
+	s.scraperRuns = slices.DeleteFunc(s.scraperRuns, func(r ScraperRun) bool {
+		return inRange(r.Datetime, from, to)
+	})
+	s.changes = slices.DeleteFunc(s.changes, func(c PriceChange) bool {
+		return inRange(c.Datetime, from, to)
+	})
+	return ids, nil
+}
+
+func (s *memoryStore) ReplacePriceChanges(datetime time.Time, changes []PriceChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = slices.DeleteFunc(s.changes, func(c PriceChange) bool {
+		return c.Datetime.Equal(datetime)
+	})
+	for _, c := range changes {
+		s.nextChange++
+		c.ID = s.nextChange
+		c.Datetime = datetime
+		c.Categories = slices.Clone(c.Categories)
+		s.changes = append(s.changes, c)
+	}
+	return nil
+}
+
+func (s *memoryStore) PriceChanges(filter PriceChangeFilter) ([]PriceChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matched []PriceChange
+	for _, c := range s.changes {
+		if _, hidden := s.hidden[c.ProductID]; hidden {
+			continue
+		}
+		if !filter.Since.IsZero() && c.Datetime.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !c.Datetime.Before(filter.Until) {
+			continue
+		}
+		if filter.Type != "" && c.Type != filter.Type {
+			continue
+		}
+		if filter.Direction != "" && c.Direction != filter.Direction {
+			continue
+		}
+		if filter.AllTimeLow && !c.AllTimeLow {
+			continue
+		}
+		if filter.Category != "" && !slices.Contains(c.Categories, filter.Category) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].Datetime.Equal(matched[j].Datetime) {
+			return matched[i].Datetime.After(matched[j].Datetime)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	if filter.Offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, nil
+}
+
+func (s *memoryStore) GetStats(productID string) (ProductStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.stats[productID]
+	if !ok {
+		return st, ErrNotFound
+	}
+	return st, nil
+}
+
+// UpdateStats mirrors the Postgres stats tracking: lowest and highest move outward,
+// regular is recalculated as the mode of the product's history
+func (s *memoryStore) UpdateStats(productID string, currentPrice float64, datetime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.stats[productID]
+	if !ok {
+		s.stats[productID] = ProductStats{
+			LowestPrice:          currentPrice,
+			LowestPriceDatetime:  datetime,
+			HighestPrice:         currentPrice,
+			HighestPriceDatetime: datetime,
+			RegularPrice:         currentPrice,
+		}
+		return nil
+	}
+
+	if currentPrice < st.LowestPrice || (currentPrice == st.LowestPrice && datetime.Before(st.LowestPriceDatetime)) {
+		st.LowestPrice = currentPrice
+		st.LowestPriceDatetime = datetime
+	}
+	if currentPrice > st.HighestPrice || (currentPrice == st.HighestPrice && datetime.Before(st.HighestPriceDatetime)) {
+		st.HighestPrice = currentPrice
+		st.HighestPriceDatetime = datetime
+	}
+
+	var prices []float64
+	for _, p := range s.products {
+		if p.ProductID == productID {
+			prices = append(prices, p.Price)
+		}
+	}
+	if len(prices) > 0 {
+		st.RegularPrice = regularPriceOf(prices)
+	}
+
+	s.stats[productID] = st
+	return nil
+}
+
+func (s *memoryStore) PutStats(productID string, st ProductStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[productID] = st
+	return nil
+}
+
+func (s *memoryStore) PutExtendedStats(productID string, ext ExtendedStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stats[productID]
+	if !ok {
+		return nil
+	}
+	st.Extended = ext
+	s.stats[productID] = st
+	return nil
+}
+
+func (s *memoryStore) DeleteStats(productID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stats, productID)
+	return nil
+}
+
+func (s *memoryStore) UpdateLifecycle(p ProductRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lifecycles[p.ProductID]
+	if !ok {
+		l = ProductLifecycle{ProductID: p.ProductID, FirstSeen: p.Datetime, LastSeen: p.Datetime, Status: LifecycleActive}
+	}
+	if p.Datetime.Before(l.FirstSeen) {
+		l.FirstSeen = p.Datetime
+	}
+	if !p.Datetime.Before(l.LastSeen) {
+		l.Name, l.URL, l.Categories, l.Price = p.Name, p.URL, slices.Clone(p.Categories), p.Price
+		l.LastSeen = p.Datetime
+	}
+	s.lifecycles[p.ProductID] = l
+	return nil
+}
+
+func (s *memoryStore) PutLifecycles(lifecycles []ProductLifecycle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range lifecycles {
+		l.Categories = slices.Clone(l.Categories)
+		s.lifecycles[l.ProductID] = l
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteLifecycle(productID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lifecycles, productID)
+	return nil
+}
+
+func (s *memoryStore) GetLifecycle(productID string) (ProductLifecycle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.lifecycles[productID]
+	if !ok {
+		return ProductLifecycle{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (s *memoryStore) Lifecycles(status string) ([]ProductLifecycle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var lifecycles []ProductLifecycle
+	for _, l := range s.lifecycles {
+		if status == "" || l.Status == status {
+			lifecycles = append(lifecycles, l)
+		}
+	}
+	slices.SortFunc(lifecycles, func(a, b ProductLifecycle) int {
+		return strings.Compare(a.ProductID, b.ProductID)
+	})
+	return lifecycles, nil
+}
+
+func (s *memoryStore) GetImage(productID string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	image, ok := s.images[productID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return image, nil
+}
+
+func (s *memoryStore) SaveImage(productID string, image []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[productID] = slices.Clone(image)
+	return nil
+}
+
+func (s *memoryStore) Categories() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.categories), nil
+}
+
+func (s *memoryStore) AddCategory(category string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.categories, category) {
+		s.categories = append(s.categories, category)
+	}
+	return nil
+}
+
+func (s *memoryStore) InsertScraperRun(run ScraperRun) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRun++
+	run.ID = s.nextRun
+	run.Categories = slices.Clone(run.Categories)
+	run.CategoryCounts = maps.Clone(run.CategoryCounts)
+	s.scraperRuns = append(s.scraperRuns, run)
+	return run.ID, nil
+}
+
+func (s *memoryStore) InsertQuarantine(q QuarantinedScrape) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q.ID = int64(len(s.quarantine) + 1)
+	q.Anomalies = slices.Clone(q.Anomalies)
+	s.quarantine = append(s.quarantine, q)
+	return q.ID, nil
+}
+
+func (s *memoryStore) QuarantinedScrapes(status string) ([]QuarantinedScrape, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var scrapes []QuarantinedScrape
+	for i := len(s.quarantine) - 1; i >= 0; i-- {
+		q := s.quarantine[i]
+		if status != "" && q.Status != status {
+			continue
+		}
+		q.Archive = nil
+		scrapes = append(scrapes, q)
+	}
+	return scrapes, nil
+}
+
+func (s *memoryStore) GetQuarantine(id int64) (QuarantinedScrape, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id < 1 || id > int64(len(s.quarantine)) {
+		return QuarantinedScrape{}, ErrNotFound
+	}
+	return s.quarantine[id-1], nil
+}
+
+func (s *memoryStore) ResolveQuarantine(id int64, status, by string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.quarantine)) || s.quarantine[id-1].Status != QuarantinePending {
+		return ErrNotFound
+	}
+	q := &s.quarantine[id-1]
+	q.Status = status
+	q.ResolvedBy = by
+	q.ResolvedAt = at
+	q.Archive = nil
+	return nil
+}
+
+func (s *memoryStore) HideProduct(p HiddenProduct) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hidden[p.ProductID] = p
+	return nil
+}
+
+func (s *memoryStore) UnhideProduct(productID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hidden[productID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.hidden, productID)
+	return nil
+}
+
+func (s *memoryStore) HiddenProducts() ([]HiddenProduct, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hidden := make([]HiddenProduct, 0, len(s.hidden))
+	for _, p := range s.hidden {
+		hidden = append(hidden, p)
+	}
+	sort.Slice(hidden, func(i, j int) bool {
+		return hidden[i].HiddenAt.After(hidden[j].HiddenAt)
+	})
+	return hidden, nil
+}
+
+func (s *memoryStore) IsHidden(productID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.hidden[productID]
+	return ok, nil
+}
+
+func (s *memoryStore) InsertAuditEntry(e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.auditLog) + 1)
+	s.auditLog = append(s.auditLog, e)
+	return nil
+}
+
+func (s *memoryStore) AuditLog(limit int) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []AuditEntry
+	for i := len(s.auditLog) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, s.auditLog[i])
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) ScraperRuns() ([]ScraperRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runs := slices.Clone(s.scraperRuns)
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Datetime.Before(runs[j].Datetime)
+	})
+	return runs, nil
+}
+
+func (s *memoryStore) CreateAPIKey(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.ID == key.ID || k.Hash == key.Hash {
+			return fmt.Errorf("failed to insert api key: duplicate key")
+		}
+	}
+	key.Scopes = slices.Clone(key.Scopes)
+	s.apiKeys = append(s.apiKeys, key)
+	return nil
+}
+
+func (s *memoryStore) APIKeyByHash(hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *memoryStore) ListAPIKeys() ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := slices.Clone(s.apiKeys)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *memoryStore) RevokeAPIKey(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID == id {
+			if s.apiKeys[i].RevokedAt.IsZero() {
+				s.apiKeys[i].RevokedAt = at
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) TouchAPIKey(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID == id {
+			s.apiKeys[i].LastUsedAt = at
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect is the production PostgreSQL backend
+var postgresDialect = &sqlDialect{
+	driver: "postgres",
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`,
+	migrations: []migration{
+		{version: 1, name: "initial schema", statements: []string{
+			`CREATE TABLE IF NOT EXISTS products (
+				product_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				price NUMERIC(10,2) NOT NULL,
+				url TEXT NOT NULL,
+				category JSONB NOT NULL,
+				datetime DATE NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS scraper (
+				datetime DATE NOT NULL,
+				scraper_version TEXT NOT NULL,
+				total_products INTEGER NOT NULL,
+				total_failed INTEGER NOT NULL,
+				categories_scraped INTEGER NOT NULL,
+				categories TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS stats (
+				product_id TEXT NOT NULL UNIQUE,
+				lowest_price NUMERIC(10,2) NOT NULL,
+				lowest_price_datetime DATE NOT NULL,
+				highest_price NUMERIC(10,2) NOT NULL,
+				highest_price_datetime DATE NOT NULL,
+				regular_price NUMERIC(10,2) NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS images (
+				product_id TEXT NOT NULL UNIQUE,
+				image BYTEA NOT NULL,
+				last_updated DATE DEFAULT NOW()
+			)`,
+			`CREATE TABLE IF NOT EXISTS categories (
+				category TEXT NOT NULL UNIQUE
+			)`,
+			`CREATE TABLE IF NOT EXISTS hidden_products (
+				product_id TEXT PRIMARY KEY,
+				reason TEXT NOT NULL,
+				hidden_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id BIGSERIAL PRIMARY KEY,
+				at TIMESTAMPTZ NOT NULL,
+				actor TEXT NOT NULL,
+				action TEXT NOT NULL,
+				target TEXT NOT NULL,
+				details TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL,
+				expires_at TIMESTAMPTZ,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ
+			)`,
+		}},
+		// Scrape dates were stored as DATE, which collapses several scrapes on the same day
+		// into one. Existing dates become midnight UTC.
+		{version: 2, name: "timestamp columns", statements: []string{
+			`ALTER TABLE products ALTER COLUMN datetime TYPE TIMESTAMPTZ USING datetime::timestamp AT TIME ZONE 'UTC'`,
+			`ALTER TABLE scraper ALTER COLUMN datetime TYPE TIMESTAMPTZ USING datetime::timestamp AT TIME ZONE 'UTC'`,
+			`ALTER TABLE stats
+				ALTER COLUMN lowest_price_datetime TYPE TIMESTAMPTZ USING lowest_price_datetime::timestamp AT TIME ZONE 'UTC',
+				ALTER COLUMN highest_price_datetime TYPE TIMESTAMPTZ USING highest_price_datetime::timestamp AT TIME ZONE 'UTC'`,
+			`ALTER TABLE images ALTER COLUMN last_updated TYPE TIMESTAMPTZ USING last_updated::timestamp AT TIME ZONE 'UTC'`,
+		}},
+		{version: 3, name: "price changes", statements: []string{
+			`CREATE TABLE IF NOT EXISTS price_changes (
+				id BIGSERIAL PRIMARY KEY,
+				product_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				category JSONB NOT NULL,
+				type TEXT NOT NULL,
+				old_price NUMERIC(10,2),
+				new_price NUMERIC(10,2),
+				percent_change NUMERIC(10,2),
+				direction TEXT NOT NULL,
+				datetime TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS price_changes_datetime_idx ON price_changes (datetime)`,
+		}},
+		{version: 4, name: "price change links and all-time lows", statements: []string{
+			`ALTER TABLE price_changes ADD COLUMN IF NOT EXISTS url TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE price_changes ADD COLUMN IF NOT EXISTS all_time_low BOOLEAN NOT NULL DEFAULT FALSE`,
+		}},
+		{version: 5, name: "product lifecycle", statements: []string{
+			`CREATE TABLE IF NOT EXISTS product_lifecycle (
+				product_id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				url TEXT NOT NULL,
+				category JSONB NOT NULL,
+				price NUMERIC(10,2) NOT NULL,
+				first_seen TIMESTAMPTZ NOT NULL,
+				last_seen TIMESTAMPTZ NOT NULL,
+				missed_runs INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT 'active'
+			)`,
+			`CREATE INDEX IF NOT EXISTS product_lifecycle_status_idx ON product_lifecycle (status)`,
+			// Seed from the existing history; statuses are filled in by the next ingest
+			`INSERT INTO product_lifecycle (product_id, name, url, category, price, first_seen, last_seen)
+			SELECT p.product_id, p.name, p.url, p.category, p.price, span.first_seen, span.last_seen
+			FROM products p
+			JOIN (
+				SELECT product_id, MIN(datetime) AS first_seen, MAX(datetime) AS last_seen
+				FROM products GROUP BY product_id
+			) span ON span.product_id = p.product_id AND p.datetime = span.last_seen
+			WHERE true
+			ON CONFLICT (product_id) DO NOTHING`,
+		}},
+		// Filled in by the next ingest of each product, or by recompute-stats
+		{version: 6, name: "extended stats", statements: []string{
+			`ALTER TABLE stats
+				ADD COLUMN IF NOT EXISTS average_price DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS median_price DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS sale_days_percent DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS sale_episodes INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS average_sale_depth DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS longest_sale_days INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS shortest_sale_days INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS last_sale_datetime TIMESTAMPTZ,
+				ADD COLUMN IF NOT EXISTS low_30_day NUMERIC(10,2) NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS low_90_day NUMERIC(10,2) NOT NULL DEFAULT 0`,
+		}},
+		{version: 7, name: "scrape duration", statements: []string{
+			`ALTER TABLE scraper ADD COLUMN IF NOT EXISTS duration_seconds DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		}},
+		// Existing runs are numbered in table order, and their per-category product counts
+		// are rebuilt from the products stored at their time, without failures
+		{version: 8, name: "scraper run categories", statements: []string{
+			`ALTER TABLE scraper ADD COLUMN IF NOT EXISTS id BIGSERIAL PRIMARY KEY`,
+			`CREATE TABLE IF NOT EXISTS scraper_categories (
+				run_id BIGINT NOT NULL,
+				category TEXT NOT NULL,
+				products INTEGER NOT NULL,
+				failed INTEGER NOT NULL,
+				PRIMARY KEY (run_id, category)
+			)`,
+			`INSERT INTO scraper_categories (run_id, category, products, failed)
+			SELECT s.id, c.category, COUNT(*), 0
+			FROM scraper s
+			JOIN products p ON p.datetime = s.datetime
+			CROSS JOIN LATERAL jsonb_array_elements_text(p.category) AS c(category)
+			GROUP BY s.id, c.category
+			ON CONFLICT (run_id, category) DO NOTHING`,
+			// Categories a run scraped without finding any products
+			`INSERT INTO scraper_categories (run_id, category, products, failed)
+			SELECT s.id, c.category, 0, 0
+			FROM scraper s
+			CROSS JOIN LATERAL unnest(string_to_array(s.categories, ',')) AS c(category)
+			WHERE c.category <> ''
+			ON CONFLICT (run_id, category) DO NOTHING`,
+		}},
+		{version: 9, name: "ingest quarantine", statements: []string{
+			`CREATE TABLE IF NOT EXISTS quarantined_scrapes (
+				id BIGSERIAL PRIMARY KEY,
+				datetime TIMESTAMPTZ NOT NULL,
+				received_at TIMESTAMPTZ NOT NULL,
+				scraper_version TEXT NOT NULL,
+				products INTEGER NOT NULL,
+				anomalies TEXT NOT NULL,
+				archive BYTEA,
+				status TEXT NOT NULL DEFAULT 'pending',
+				resolved_at TIMESTAMPTZ,
+				resolved_by TEXT NOT NULL DEFAULT ''
+			)`,
+		}},
+		// The indexes SQLite has had since the initial schema, for the lookups of a run's
+		// products and of a product's history. Building them blocks ingest until done.
+		{version: 10, name: "product indexes", statements: []string{
+			`CREATE INDEX IF NOT EXISTS products_product_id_idx ON products (product_id)`,
+			`CREATE INDEX IF NOT EXISTS products_datetime_idx ON products (datetime)`,
+		}},
+	},
+	// JSONB contains against a one-element array
+	categoryFilter: `p.category @> jsonb_build_array(%s::text)`,
+	statsUpsert: `
+		INSERT INTO stats (product_id, lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price)
+		VALUES ($1, $2, $3, $2, $3, $2)
+		ON CONFLICT (product_id) DO UPDATE SET
+			lowest_price = LEAST(stats.lowest_price, EXCLUDED.lowest_price),
+			lowest_price_datetime = CASE WHEN EXCLUDED.lowest_price < stats.lowest_price
+				OR (EXCLUDED.lowest_price = stats.lowest_price AND EXCLUDED.lowest_price_datetime < stats.lowest_price_datetime)
+				THEN EXCLUDED.lowest_price_datetime ELSE stats.lowest_price_datetime END,
+			highest_price = GREATEST(stats.highest_price, EXCLUDED.highest_price),
+			highest_price_datetime = CASE WHEN EXCLUDED.highest_price > stats.highest_price
+				OR (EXCLUDED.highest_price = stats.highest_price AND EXCLUDED.highest_price_datetime < stats.highest_price_datetime)
+				THEN EXCLUDED.highest_price_datetime ELSE stats.highest_price_datetime END
+	`,
+	imageUpsert: `
+		INSERT INTO images (product_id, image) VALUES ($1, $2)
+		ON CONFLICT (product_id) DO UPDATE SET image = EXCLUDED.image, last_updated = NOW()
+	`,
+}
+
+// newPostgresStore connects to PostgreSQL
+func newPostgresStore(databaseURL string) (*sqlStore, error) {
+	return newSQLStore(postgresDialect, databaseURL)
+}
@@ -0,0 +1,1238 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlDialect captures the queries that differ between SQL backends
+type sqlDialect struct {
+	// driver is the database/sql driver name
+	driver string
+	// migrationsTable creates the table recording applied migrations
+	migrationsTable string
+	// migrations builds the schema, in version order
+	migrations []migration
+	// categoryFilter restricts a query on products p to those tagged with a category,
+	// passed as the placeholder substituted for %s
+	categoryFilter string
+	// statsUpsert folds price $2 observed at $3 into the stats row of product $1
+	statsUpsert string
+	// imageUpsert inserts or replaces image $2 for product $1
+	imageUpsert string
+}
+
+// sqlStore is the Store backed by a database/sql connection. Long streaming reads
+// such as exports use reader, which is db itself except on SQLite.
+type sqlStore struct {
+	db      *sql.DB
+	reader  *sql.DB
+	dialect *sqlDialect
+}
+
+// newSQLStore connects with the given dialect. Tables are created by Migrate.
+func newSQLStore(d *sqlDialect, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &sqlStore{db: db, reader: db, dialect: d}, nil
+}
+
+// timeArg prepares a timestamp for use as a query argument. Timestamps are always
+// written in UTC, so SQLite's text values sort and compare correctly and Postgres
+// never depends on the session time zone.
+func (s *sqlStore) timeArg(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func (s *sqlStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqlStore) Close() error {
+	if s.reader != s.db {
+		s.reader.Close()
+	}
+	return s.db.Close()
+}
+
+func (s *sqlStore) InsertProduct(p ProductRecord) error {
+	categoriesJSON, err := json.Marshal(p.Categories)
+	if err != nil {
+		return fmt.Errorf("failed to marshal categories: %w", err)
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO products (product_id, name, price, url, category, datetime) VALUES ($1, $2, $3, $4, $5, $6)",
+		p.ProductID, p.Name, p.Price, p.URL, string(categoriesJSON), s.timeArg(p.Datetime),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert product: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) ProductHistory(productID string) ([]ProductRecord, error) {
+	rows, err := s.db.Query(
+		"SELECT product_id, name, price, url, category, datetime FROM products WHERE product_id = $1 ORDER BY datetime ASC",
+		productID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product datapoints: %w", err)
+	}
+	defer rows.Close()
+
+	var records []ProductRecord
+	for rows.Next() {
+		var r ProductRecord
+		var categoryJSON string
+		if err := rows.Scan(&r.ProductID, &r.Name, &r.Price, &r.URL, &categoryJSON, &r.Datetime); err != nil {
+			return nil, fmt.Errorf("failed to scan datapoint: %w", err)
+		}
+		r.Categories = decodeCategories(categoryJSON)
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating datapoints: %w", err)
+	}
+	return records, nil
+}
+
+func (s *sqlStore) RunSnapshot(datetime time.Time, category string) ([]ProductResponse, error) {
+	// Query the run's products and join with stats for lowest_price, regular_price and
+	// the extended stats
+	query := `
+		SELECT
+			p.product_id,
+			p.name,
+			p.price,
+			p.url,
+			p.category,
+			p.datetime,
+			COALESCE(s.lowest_price, p.price) as lowest_price,
+			COALESCE(s.regular_price, p.price) as regular_price,
+			s.product_id IS NOT NULL as has_stats,
+			COALESCE(s.average_price, 0), COALESCE(s.median_price, 0), COALESCE(s.sale_days_percent, 0),
+			COALESCE(s.sale_episodes, 0), COALESCE(s.average_sale_depth, 0), COALESCE(s.longest_sale_days, 0),
+			COALESCE(s.shortest_sale_days, 0), s.last_sale_datetime, COALESCE(s.low_30_day, 0), COALESCE(s.low_90_day, 0)
+		FROM products p
+		LEFT JOIN stats s ON p.product_id = s.product_id
+		WHERE p.datetime = $1
+			AND p.product_id NOT IN (SELECT product_id FROM hidden_products)
+	`
+	args := []any{s.timeArg(datetime)}
+	if category != "" {
+		query += " AND " + fmt.Sprintf(s.dialect.categoryFilter, "$2")
+		args = append(args, category)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products: %w", err)
+	}
+	defer rows.Close()
+
+	var products []ProductResponse
+	for rows.Next() {
+		var p ProductResponse
+		var categoryJSON string
+		var recorded time.Time
+		var hasStats bool
+		var ext ExtendedStats
+		extDest, finishExt := extendedStatsDest(&ext)
+		dest := append([]any{&p.ProductID, &p.Name, &p.Price, &p.URL, &categoryJSON, &recorded, &p.LowestPrice, &p.RegularPrice, &hasStats}, extDest...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+		finishExt()
+		p.Datetime = formatTimestamp(recorded)
+		p.Categories = decodeCategories(categoryJSON)
+		p.IsAllTimeLow = isAllTimeLow(p.Price, p.LowestPrice, p.RegularPrice)
+		if hasStats {
+			p.Stats = newExtendedStatsInfo(ext, p.Price < p.RegularPrice, time.Now())
+		}
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating products: %w", err)
+	}
+	return products, nil
+}
+
+func (s *sqlStore) ScrapeTimes() ([]time.Time, error) {
+	rows, err := s.db.Query("SELECT DISTINCT datetime FROM products ORDER BY datetime ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scrape times: %w", err)
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to scan scrape time: %w", err)
+		}
+		times = append(times, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scrape times: %w", err)
+	}
+	return times, nil
+}
+
+func (s *sqlStore) ScrapeProducts(datetime time.Time) ([]ProductRecord, error) {
+	rows, err := s.db.Query(
+		"SELECT product_id, name, price, url, category, datetime FROM products WHERE datetime = $1 ORDER BY product_id",
+		s.timeArg(datetime),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scrape products: %w", err)
+	}
+	defer rows.Close()
+
+	var records []ProductRecord
+	for rows.Next() {
+		var r ProductRecord
+		var categoryJSON string
+		if err := rows.Scan(&r.ProductID, &r.Name, &r.Price, &r.URL, &categoryJSON, &r.Datetime); err != nil {
+			return nil, fmt.Errorf("failed to scan datapoint: %w", err)
+		}
+		r.Categories = decodeCategories(categoryJSON)
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scrape products: %w", err)
+	}
+	return records, nil
+}
+
+func (s *sqlStore) RunHistories(datetime time.Time, fn func(history []ProductRecord) error) error {
+	// Read from reader so fn can write while the rows are open, even on SQLite
+	rows, err := s.reader.Query(`
+		SELECT product_id, name, price, url, category, datetime FROM products
+		WHERE product_id IN (SELECT product_id FROM products WHERE datetime = $1)
+		ORDER BY product_id, datetime ASC`, s.timeArg(datetime))
+	if err != nil {
+		return fmt.Errorf("failed to query run histories: %w", err)
+	}
+	defer rows.Close()
+
+	var history []ProductRecord
+	for rows.Next() {
+		var r ProductRecord
+		var categoryJSON string
+		if err := rows.Scan(&r.ProductID, &r.Name, &r.Price, &r.URL, &categoryJSON, &r.Datetime); err != nil {
+			return fmt.Errorf("failed to scan datapoint: %w", err)
+		}
+		r.Categories = decodeCategories(categoryJSON)
+		if len(history) > 0 && history[0].ProductID != r.ProductID {
+			if err := fn(history); err != nil {
+				return err
+			}
+			history = nil
+		}
+		history = append(history, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating run histories: %w", err)
+	}
+	if len(history) > 0 {
+		return fn(history)
+	}
+	return nil
+}
+
+func (s *sqlStore) PriceRanks(datetime time.Time) (map[string]PriceRank, error) {
+	rows, err := s.db.Query(`
+		SELECT p.product_id, COUNT(*), SUM(CASE WHEN h.price <= p.price THEN 1 ELSE 0 END)
+		FROM products p
+		JOIN products h ON h.product_id = p.product_id
+		WHERE p.datetime = $1
+		GROUP BY p.product_id`, s.timeArg(datetime))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price ranks: %w", err)
+	}
+	defer rows.Close()
+
+	ranks := make(map[string]PriceRank)
+	for rows.Next() {
+		var productID string
+		var rank PriceRank
+		if err := rows.Scan(&productID, &rank.Datapoints, &rank.AtOrBelow); err != nil {
+			return nil, fmt.Errorf("failed to scan price rank: %w", err)
+		}
+		ranks[productID] = rank
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating price ranks: %w", err)
+	}
+	return ranks, nil
+}
+
+func (s *sqlStore) LowestPricesBefore(datetime time.Time) (map[string]float64, error) {
+	rows, err := s.db.Query(`
+		SELECT h.product_id, MIN(h.price)
+		FROM products h
+		WHERE h.datetime < $1
+			AND h.product_id IN (SELECT product_id FROM products WHERE datetime = $2)
+		GROUP BY h.product_id`, s.timeArg(datetime), s.timeArg(datetime))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lowest prices: %w", err)
+	}
+	defer rows.Close()
+
+	lowest := make(map[string]float64)
+	for rows.Next() {
+		var productID string
+		var price float64
+		if err := rows.Scan(&productID, &price); err != nil {
+			return nil, fmt.Errorf("failed to scan lowest price: %w", err)
+		}
+		lowest[productID] = price
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating lowest prices: %w", err)
+	}
+	return lowest, nil
+}
+
+func (s *sqlStore) ExportHistory(filter ExportFilter, fn func(ExportRow) error) error {
+	query := `
+		SELECT
+			p.product_id,
+			p.name,
+			p.price,
+			p.url,
+			p.category,
+			p.datetime,
+			COALESCE(s.lowest_price, p.price),
+			COALESCE(s.highest_price, p.price),
+			COALESCE(s.regular_price, p.price)
+		FROM products p
+		LEFT JOIN stats s ON p.product_id = s.product_id
+		WHERE p.product_id NOT IN (SELECT product_id FROM hidden_products)
+	`
+	var args []any
+	if !filter.From.IsZero() {
+		args = append(args, s.timeArg(filter.From))
+		query += fmt.Sprintf(" AND p.datetime >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, s.timeArg(filter.To))
+		query += fmt.Sprintf(" AND p.datetime < $%d", len(args))
+	}
+	if filter.Category != "" {
+		args = append(args, filter.Category)
+		query += " AND " + fmt.Sprintf(s.dialect.categoryFilter, fmt.Sprintf("$%d", len(args)))
+	}
+	query += " ORDER BY p.datetime ASC, p.product_id ASC"
+
+	rows, err := s.reader.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		var categoryJSON string
+		if err := rows.Scan(&row.ProductID, &row.Name, &row.Price, &row.URL, &categoryJSON, &row.Datetime,
+			&row.LowestPrice, &row.HighestPrice, &row.RegularPrice); err != nil {
+			return fmt.Errorf("failed to scan datapoint: %w", err)
+		}
+		row.Categories = decodeCategories(categoryJSON)
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating history: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) ProductIDs() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT product_id FROM products ORDER BY product_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product ids: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan product id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating product ids: %w", err)
+	}
+	return ids, nil
+}
+
+func (s *sqlStore) UpdateDatapoints(productID string, from, to time.Time, price float64) (int64, error) {
+	res, err := s.db.Exec(
+		"UPDATE products SET price = $1 WHERE product_id = $2 AND datetime >= $3 AND datetime < $4",
+		price, productID, s.timeArg(from), s.timeArg(to),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update datapoints: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) DeleteDatapoints(productID string, from, to time.Time) (int64, error) {
+	res, err := s.db.Exec(
+		"DELETE FROM products WHERE product_id = $1 AND datetime >= $2 AND datetime < $3",
+		productID, s.timeArg(from), s.timeArg(to),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete datapoints: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) DeleteScrape(from, to time.Time) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT DISTINCT product_id FROM products WHERE datetime >= $1 AND datetime < $2",
+		s.timeArg(from), s.timeArg(to),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scrape products: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan product id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scrape products: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM products WHERE datetime >= $1 AND datetime < $2", s.timeArg(from), s.timeArg(to)); err != nil {
+		return nil, fmt.Errorf("failed to delete scrape products: %w", err)
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM scraper_categories WHERE run_id IN (SELECT id FROM scraper WHERE datetime >= $1 AND datetime < $2)",
+		s.timeArg(from), s.timeArg(to),
+	); err != nil {
+		return nil, fmt.Errorf("failed to delete scraper category counts: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM scraper WHERE datetime >= $1 AND datetime < $2", s.timeArg(from), s.timeArg(to)); err != nil {
+		return nil, fmt.Errorf("failed to delete scraper run: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM price_changes WHERE datetime >= $1 AND datetime < $2", s.timeArg(from), s.timeArg(to)); err != nil {
+		return nil, fmt.Errorf("failed to delete price changes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scrape deletion: %w", err)
+	}
+	return ids, nil
+}
+
+func (s *sqlStore) ReplacePriceChanges(datetime time.Time, changes []PriceChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM price_changes WHERE datetime = $1", s.timeArg(datetime)); err != nil {
+		return fmt.Errorf("failed to clear price changes: %w", err)
+	}
+	for _, c := range changes {
+		categoriesJSON, err := json.Marshal(c.Categories)
+		if err != nil {
+			return fmt.Errorf("failed to marshal categories: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO price_changes (product_id, name, url, category, type, old_price, new_price, percent_change, direction, all_time_low, datetime)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, c.ProductID, c.Name, c.URL, string(categoriesJSON), c.Type, c.OldPrice, c.NewPrice, c.PercentChange, c.Direction, c.AllTimeLow, s.timeArg(datetime))
+		if err != nil {
+			return fmt.Errorf("failed to insert price change: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit price changes: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) PriceChanges(filter PriceChangeFilter) ([]PriceChange, error) {
+	query := `
+		SELECT p.id, p.product_id, p.name, p.url, p.category, p.type, p.old_price, p.new_price, p.percent_change, p.direction, p.all_time_low, p.datetime
+		FROM price_changes p
+		WHERE p.product_id NOT IN (SELECT product_id FROM hidden_products)
+	`
+	var args []any
+	if !filter.Since.IsZero() {
+		args = append(args, s.timeArg(filter.Since))
+		query += fmt.Sprintf(" AND p.datetime >= $%d", len(args))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, s.timeArg(filter.Until))
+		query += fmt.Sprintf(" AND p.datetime < $%d", len(args))
+	}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		query += fmt.Sprintf(" AND p.type = $%d", len(args))
+	}
+	if filter.Direction != "" {
+		args = append(args, filter.Direction)
+		query += fmt.Sprintf(" AND p.direction = $%d", len(args))
+	}
+	if filter.AllTimeLow {
+		query += " AND p.all_time_low"
+	}
+	if filter.Category != "" {
+		args = append(args, filter.Category)
+		query += " AND " + fmt.Sprintf(s.dialect.categoryFilter, fmt.Sprintf("$%d", len(args)))
+	}
+	query += " ORDER BY p.datetime DESC, p.id ASC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []PriceChange
+	for rows.Next() {
+		var c PriceChange
+		var categoryJSON string
+		var oldPrice, newPrice, percent sql.NullFloat64
+		if err := rows.Scan(&c.ID, &c.ProductID, &c.Name, &c.URL, &categoryJSON, &c.Type, &oldPrice, &newPrice, &percent, &c.Direction, &c.AllTimeLow, &c.Datetime); err != nil {
+			return nil, fmt.Errorf("failed to scan price change: %w", err)
+		}
+		c.Categories = decodeCategories(categoryJSON)
+		c.OldPrice = nullFloat(oldPrice)
+		c.NewPrice = nullFloat(newPrice)
+		c.PercentChange = nullFloat(percent)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating price changes: %w", err)
+	}
+	return changes, nil
+}
+
+// nullFloat converts a nullable column to a pointer, nil for NULL
+func nullFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+// extendedStatsColumns are the stats columns holding ExtendedStats, in scan order
+const extendedStatsColumns = "average_price, median_price, sale_days_percent, sale_episodes, average_sale_depth, " +
+	"longest_sale_days, shortest_sale_days, last_sale_datetime, low_30_day, low_90_day"
+
+// extendedStatsDest returns scan destinations for extendedStatsColumns. The returned
+// func copies the nullable last sale into ext once the row is scanned.
+func extendedStatsDest(ext *ExtendedStats) ([]any, func()) {
+	var lastSale sql.NullTime
+	dest := []any{&ext.AveragePrice, &ext.MedianPrice, &ext.SaleDaysPercent, &ext.SaleEpisodes, &ext.AverageSaleDepth,
+		&ext.LongestSaleDays, &ext.ShortestSaleDays, &lastSale, &ext.Low30Day, &ext.Low90Day}
+	return dest, func() { ext.LastSaleDatetime = lastSale.Time }
+}
+
+// extendedStatsArgs returns the values of extendedStatsColumns for a write
+func (s *sqlStore) extendedStatsArgs(ext ExtendedStats) []any {
+	var lastSale any
+	if !ext.LastSaleDatetime.IsZero() {
+		lastSale = s.timeArg(ext.LastSaleDatetime)
+	}
+	return []any{ext.AveragePrice, ext.MedianPrice, ext.SaleDaysPercent, ext.SaleEpisodes, ext.AverageSaleDepth,
+		ext.LongestSaleDays, ext.ShortestSaleDays, lastSale, ext.Low30Day, ext.Low90Day}
+}
+
+func (s *sqlStore) GetStats(productID string) (ProductStats, error) {
+	var st ProductStats
+	var lowestDatetime, highestDatetime sql.NullTime
+	extDest, finishExt := extendedStatsDest(&st.Extended)
+	err := s.db.QueryRow(
+		"SELECT lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price, "+extendedStatsColumns+" FROM stats WHERE product_id = $1",
+		productID,
+	).Scan(append([]any{&st.LowestPrice, &lowestDatetime, &st.HighestPrice, &highestDatetime, &st.RegularPrice}, extDest...)...)
+	if err == sql.ErrNoRows {
+		return st, ErrNotFound
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed to query stats: %w", err)
+	}
+	st.LowestPriceDatetime = lowestDatetime.Time
+	st.HighestPriceDatetime = highestDatetime.Time
+	finishExt()
+	return st, nil
+}
+
+// calculateRegularPrice calculates the mode (most frequent price) for a product
+func (s *sqlStore) calculateRegularPrice(productID string) (float64, error) {
+	query := `
+		SELECT price, COUNT(*) as count
+		FROM products
+		WHERE product_id = $1
+		GROUP BY price
+		ORDER BY count DESC, price DESC
+		LIMIT 1
+	`
+	var regularPrice float64
+	var count int
+	err := s.db.QueryRow(query, productID).Scan(&regularPrice, &count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate regular price: %w", err)
+	}
+	return regularPrice, nil
+}
+
+// UpdateStats updates the stats table with lowest, highest, and regular price tracking
+// Case 1: Product doesn't exist -> insert with current price as lowest, highest, and regular
+// Case 2: Product exists -> update lowest if current < lowest, update highest if current > highest, recalculate regular
+// A price equal to the lowest or highest moves its date back when it was seen earlier, so
+// backfilling an older scrape gives the same stats as ingesting in order
+func (s *sqlStore) UpdateStats(productID string, currentPrice float64, datetime time.Time) error {
+	if _, err := s.db.Exec(s.dialect.statsUpsert, productID, currentPrice, s.timeArg(datetime)); err != nil {
+		return fmt.Errorf("failed to upsert stats: %w", err)
+	}
+
+	regularPrice, err := s.calculateRegularPrice(productID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"UPDATE stats SET regular_price = $1 WHERE product_id = $2",
+		regularPrice, productID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update regular price: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) PutStats(productID string, st ProductStats) error {
+	args := append([]any{productID, st.LowestPrice, s.timeArg(st.LowestPriceDatetime), st.HighestPrice, s.timeArg(st.HighestPriceDatetime), st.RegularPrice},
+		s.extendedStatsArgs(st.Extended)...)
+	_, err := s.db.Exec(`
+		INSERT INTO stats (product_id, lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price, `+extendedStatsColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (product_id) DO UPDATE SET
+			lowest_price = EXCLUDED.lowest_price,
+			lowest_price_datetime = EXCLUDED.lowest_price_datetime,
+			highest_price = EXCLUDED.highest_price,
+			highest_price_datetime = EXCLUDED.highest_price_datetime,
+			regular_price = EXCLUDED.regular_price,
+			average_price = EXCLUDED.average_price,
+			median_price = EXCLUDED.median_price,
+			sale_days_percent = EXCLUDED.sale_days_percent,
+			sale_episodes = EXCLUDED.sale_episodes,
+			average_sale_depth = EXCLUDED.average_sale_depth,
+			longest_sale_days = EXCLUDED.longest_sale_days,
+			shortest_sale_days = EXCLUDED.shortest_sale_days,
+			last_sale_datetime = EXCLUDED.last_sale_datetime,
+			low_30_day = EXCLUDED.low_30_day,
+			low_90_day = EXCLUDED.low_90_day
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to replace stats: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) PutExtendedStats(productID string, ext ExtendedStats) error {
+	args := append(s.extendedStatsArgs(ext), productID)
+	_, err := s.db.Exec(`
+		UPDATE stats SET
+			average_price = $1,
+			median_price = $2,
+			sale_days_percent = $3,
+			sale_episodes = $4,
+			average_sale_depth = $5,
+			longest_sale_days = $6,
+			shortest_sale_days = $7,
+			last_sale_datetime = $8,
+			low_30_day = $9,
+			low_90_day = $10
+		WHERE product_id = $11
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update extended stats: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteStats(productID string) error {
+	if _, err := s.db.Exec("DELETE FROM stats WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to delete stats: %w", err)
+	}
+	return nil
+}
+
+// lifecycleUpsert folds a datapoint into product_lifecycle. The product's details
+// follow whichever datapoint is newest.
+const lifecycleUpsert = `
+	INSERT INTO product_lifecycle (product_id, name, url, category, price, first_seen, last_seen)
+	VALUES ($1, $2, $3, $4, $5, $6, $6)
+	ON CONFLICT (product_id) DO UPDATE SET
+		first_seen = CASE WHEN excluded.first_seen < product_lifecycle.first_seen
+			THEN excluded.first_seen ELSE product_lifecycle.first_seen END,
+		name = CASE WHEN excluded.last_seen >= product_lifecycle.last_seen
+			THEN excluded.name ELSE product_lifecycle.name END,
+		url = CASE WHEN excluded.last_seen >= product_lifecycle.last_seen
+			THEN excluded.url ELSE product_lifecycle.url END,
+		category = CASE WHEN excluded.last_seen >= product_lifecycle.last_seen
+			THEN excluded.category ELSE product_lifecycle.category END,
+		price = CASE WHEN excluded.last_seen >= product_lifecycle.last_seen
+			THEN excluded.price ELSE product_lifecycle.price END,
+		last_seen = CASE WHEN excluded.last_seen > product_lifecycle.last_seen
+			THEN excluded.last_seen ELSE product_lifecycle.last_seen END
+`
+
+func (s *sqlStore) UpdateLifecycle(p ProductRecord) error {
+	categoriesJSON, err := json.Marshal(p.Categories)
+	if err != nil {
+		return fmt.Errorf("failed to marshal categories: %w", err)
+	}
+	if _, err := s.db.Exec(lifecycleUpsert, p.ProductID, p.Name, p.URL, string(categoriesJSON), p.Price, s.timeArg(p.Datetime)); err != nil {
+		return fmt.Errorf("failed to upsert lifecycle: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) PutLifecycles(lifecycles []ProductLifecycle) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, l := range lifecycles {
+		categoriesJSON, err := json.Marshal(l.Categories)
+		if err != nil {
+			return fmt.Errorf("failed to marshal categories: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO product_lifecycle (product_id, name, url, category, price, first_seen, last_seen, missed_runs, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (product_id) DO UPDATE SET
+				name = excluded.name,
+				url = excluded.url,
+				category = excluded.category,
+				price = excluded.price,
+				first_seen = excluded.first_seen,
+				last_seen = excluded.last_seen,
+				missed_runs = excluded.missed_runs,
+				status = excluded.status
+		`, l.ProductID, l.Name, l.URL, string(categoriesJSON), l.Price, s.timeArg(l.FirstSeen), s.timeArg(l.LastSeen), l.MissedRuns, l.Status)
+		if err != nil {
+			return fmt.Errorf("failed to put lifecycle: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit lifecycles: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteLifecycle(productID string) error {
+	if _, err := s.db.Exec("DELETE FROM product_lifecycle WHERE product_id = $1", productID); err != nil {
+		return fmt.Errorf("failed to delete lifecycle: %w", err)
+	}
+	return nil
+}
+
+// lifecycleColumns are the product_lifecycle columns read by scanLifecycle
+const lifecycleColumns = "product_id, name, url, category, price, first_seen, last_seen, missed_runs, status"
+
+func scanLifecycle(row interface{ Scan(...any) error }) (ProductLifecycle, error) {
+	var l ProductLifecycle
+	var categoryJSON string
+	if err := row.Scan(&l.ProductID, &l.Name, &l.URL, &categoryJSON, &l.Price, &l.FirstSeen, &l.LastSeen, &l.MissedRuns, &l.Status); err != nil {
+		return l, err
+	}
+	l.Categories = decodeCategories(categoryJSON)
+	return l, nil
+}
+
+func (s *sqlStore) GetLifecycle(productID string) (ProductLifecycle, error) {
+	l, err := scanLifecycle(s.db.QueryRow("SELECT "+lifecycleColumns+" FROM product_lifecycle WHERE product_id = $1", productID))
+	if err == sql.ErrNoRows {
+		return l, ErrNotFound
+	}
+	if err != nil {
+		return l, fmt.Errorf("failed to get lifecycle: %w", err)
+	}
+	return l, nil
+}
+
+func (s *sqlStore) Lifecycles(status string) ([]ProductLifecycle, error) {
+	query := "SELECT " + lifecycleColumns + " FROM product_lifecycle"
+	var args []any
+	if status != "" {
+		query += " WHERE status = $1"
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+" ORDER BY product_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lifecycles: %w", err)
+	}
+	defer rows.Close()
+
+	var lifecycles []ProductLifecycle
+	for rows.Next() {
+		l, err := scanLifecycle(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lifecycle: %w", err)
+		}
+		lifecycles = append(lifecycles, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating lifecycles: %w", err)
+	}
+	return lifecycles, nil
+}
+
+func (s *sqlStore) GetImage(productID string) ([]byte, error) {
+	var imageBytes []byte
+	err := s.db.QueryRow("SELECT image FROM images WHERE product_id = $1", productID).Scan(&imageBytes)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve image: %w", err)
+	}
+	return imageBytes, nil
+}
+
+func (s *sqlStore) SaveImage(productID string, image []byte) error {
+	_, err := s.db.Exec(s.dialect.imageUpsert, productID, image)
+	if err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Categories() ([]string, error) {
+	rows, err := s.db.Query("SELECT category FROM categories")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating categories: %w", err)
+	}
+	return categories, nil
+}
+
+func (s *sqlStore) AddCategory(category string) error {
+	_, err := s.db.Exec("INSERT INTO categories (category) VALUES ($1) ON CONFLICT DO NOTHING", category)
+	if err != nil {
+		return fmt.Errorf("failed to insert category: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) InsertScraperRun(run ScraperRun) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(
+		"INSERT INTO scraper (datetime, scraper_version, total_products, total_failed, categories_scraped, categories, duration_seconds) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		s.timeArg(run.Datetime),
+		run.ScraperVersion,
+		run.TotalProducts,
+		run.TotalFailed,
+		run.CategoriesScraped,
+		strings.Join(run.Categories, ","),
+		run.DurationSeconds,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert scraper stats: %w", err)
+	}
+	for category, count := range run.CategoryCounts {
+		if _, err := tx.Exec(
+			"INSERT INTO scraper_categories (run_id, category, products, failed) VALUES ($1, $2, $3, $4)",
+			id, category, count.Products, count.Failed,
+		); err != nil {
+			return 0, fmt.Errorf("failed to insert scraper category counts: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit scraper run: %w", err)
+	}
+	return id, nil
+}
+
+func (s *sqlStore) HideProduct(p HiddenProduct) error {
+	_, err := s.db.Exec(`
+		INSERT INTO hidden_products (product_id, reason, hidden_at) VALUES ($1, $2, $3)
+		ON CONFLICT (product_id) DO UPDATE SET reason = EXCLUDED.reason, hidden_at = EXCLUDED.hidden_at
+	`, p.ProductID, p.Reason, s.timeArg(p.HiddenAt))
+	if err != nil {
+		return fmt.Errorf("failed to hide product: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) UnhideProduct(productID string) error {
+	res, err := s.db.Exec("DELETE FROM hidden_products WHERE product_id = $1", productID)
+	if err != nil {
+		return fmt.Errorf("failed to unhide product: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) HiddenProducts() ([]HiddenProduct, error) {
+	rows, err := s.db.Query("SELECT product_id, reason, hidden_at FROM hidden_products ORDER BY hidden_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hidden products: %w", err)
+	}
+	defer rows.Close()
+
+	var hidden []HiddenProduct
+	for rows.Next() {
+		var p HiddenProduct
+		if err := rows.Scan(&p.ProductID, &p.Reason, &p.HiddenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan hidden product: %w", err)
+		}
+		hidden = append(hidden, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hidden products: %w", err)
+	}
+	return hidden, nil
+}
+
+func (s *sqlStore) IsHidden(productID string) (bool, error) {
+	var hidden bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM hidden_products WHERE product_id = $1)", productID).Scan(&hidden)
+	if err != nil {
+		return false, fmt.Errorf("failed to query hidden product: %w", err)
+	}
+	return hidden, nil
+}
+
+func (s *sqlStore) InsertAuditEntry(e AuditEntry) error {
+	_, err := s.db.Exec(
+		"INSERT INTO audit_log (at, actor, action, target, details) VALUES ($1, $2, $3, $4, $5)",
+		s.timeArg(e.At), e.Actor, e.Action, e.Target, e.Details,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) AuditLog(limit int) ([]AuditEntry, error) {
+	rows, err := s.db.Query("SELECT id, at, actor, action, target, details FROM audit_log ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.Action, &e.Target, &e.Details); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *sqlStore) ScraperRuns() ([]ScraperRun, error) {
+	counts, err := s.scraperCategoryCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT id, datetime, scraper_version, total_products, total_failed, categories_scraped, categories, duration_seconds FROM scraper ORDER BY datetime ASC, id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scraper runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []ScraperRun
+	for rows.Next() {
+		var run ScraperRun
+		var categories string
+		if err := rows.Scan(&run.ID, &run.Datetime, &run.ScraperVersion, &run.TotalProducts, &run.TotalFailed, &run.CategoriesScraped, &categories, &run.DurationSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan scraper run: %w", err)
+		}
+		if categories != "" {
+			run.Categories = strings.Split(categories, ",")
+		}
+		run.CategoryCounts = counts[run.ID]
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scraper runs: %w", err)
+	}
+	return runs, nil
+}
+
+// scraperCategoryCounts returns the per-category counts of every scraper run, keyed by
+// run ID
+func (s *sqlStore) scraperCategoryCounts() (map[int64]map[string]CategoryCount, error) {
+	rows, err := s.db.Query("SELECT run_id, category, products, failed FROM scraper_categories")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scraper category counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]map[string]CategoryCount)
+	for rows.Next() {
+		var id int64
+		var category string
+		var count CategoryCount
+		if err := rows.Scan(&id, &category, &count.Products, &count.Failed); err != nil {
+			return nil, fmt.Errorf("failed to scan scraper category counts: %w", err)
+		}
+		if counts[id] == nil {
+			counts[id] = make(map[string]CategoryCount)
+		}
+		counts[id][category] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating scraper category counts: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *sqlStore) InsertQuarantine(q QuarantinedScrape) (int64, error) {
+	anomaliesJSON, err := json.Marshal(q.Anomalies)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal anomalies: %w", err)
+	}
+	var id int64
+	err = s.db.QueryRow(
+		"INSERT INTO quarantined_scrapes (datetime, received_at, scraper_version, products, anomalies, archive, status) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		s.timeArg(q.Datetime), s.timeArg(q.ReceivedAt), q.ScraperVersion, q.Products, string(anomaliesJSON), q.Archive, q.Status,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert quarantined scrape: %w", err)
+	}
+	return id, nil
+}
+
+const quarantineColumns = "id, datetime, received_at, scraper_version, products, anomalies, status, resolved_at, resolved_by"
+
+func scanQuarantine(row interface{ Scan(...any) error }, extra ...any) (QuarantinedScrape, error) {
+	var q QuarantinedScrape
+	var anomalies string
+	var resolvedAt sql.NullTime
+	dest := append([]any{&q.ID, &q.Datetime, &q.ReceivedAt, &q.ScraperVersion, &q.Products, &anomalies, &q.Status, &resolvedAt, &q.ResolvedBy}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal([]byte(anomalies), &q.Anomalies); err != nil {
+		return q, fmt.Errorf("failed to parse anomalies: %w", err)
+	}
+	q.ResolvedAt = resolvedAt.Time
+	return q, nil
+}
+
+func (s *sqlStore) QuarantinedScrapes(status string) ([]QuarantinedScrape, error) {
+	query := "SELECT " + quarantineColumns + " FROM quarantined_scrapes"
+	var args []any
+	if status != "" {
+		query += " WHERE status = $1"
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quarantined scrapes: %w", err)
+	}
+	defer rows.Close()
+
+	var scrapes []QuarantinedScrape
+	for rows.Next() {
+		q, err := scanQuarantine(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quarantined scrape: %w", err)
+		}
+		scrapes = append(scrapes, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating quarantined scrapes: %w", err)
+	}
+	return scrapes, nil
+}
+
+func (s *sqlStore) GetQuarantine(id int64) (QuarantinedScrape, error) {
+	var archive []byte
+	q, err := scanQuarantine(s.db.QueryRow("SELECT "+quarantineColumns+", archive FROM quarantined_scrapes WHERE id = $1", id), &archive)
+	if err == sql.ErrNoRows {
+		return q, ErrNotFound
+	}
+	if err != nil {
+		return q, fmt.Errorf("failed to query quarantined scrape: %w", err)
+	}
+	q.Archive = archive
+	return q, nil
+}
+
+func (s *sqlStore) ResolveQuarantine(id int64, status, by string, at time.Time) error {
+	res, err := s.db.Exec(
+		"UPDATE quarantined_scrapes SET status = $1, resolved_by = $2, resolved_at = $3, archive = NULL WHERE id = $4 AND status = $5",
+		status, by, s.timeArg(at), id, QuarantinePending,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve quarantined scrape: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// decodeCategories parses the JSON category column, falling back to the raw value
+func decodeCategories(categoryJSON string) []string {
+	var categories []string
+	if err := json.Unmarshal([]byte(categoryJSON), &categories); err != nil {
+		return []string{categoryJSON}
+	}
+	return categories
+}
+
+// nullTimeArg prepares an optional timestamp, writing NULL for the zero time
+func (s *sqlStore) nullTimeArg(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return s.timeArg(t)
+}
+
+func (s *sqlStore) CreateAPIKey(key APIKey) error {
+	_, err := s.db.Exec(
+		"INSERT INTO api_keys (id, name, key_hash, scopes, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		key.ID, key.Name, key.Hash, strings.Join(key.Scopes, ","), s.timeArg(key.CreatedAt), s.nullTimeArg(key.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return nil
+}
+
+const apiKeyColumns = "id, name, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Hash, &scopes, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return key, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	key.ExpiresAt = expiresAt.Time
+	key.LastUsedAt = lastUsedAt.Time
+	key.RevokedAt = revokedAt.Time
+	return key, nil
+}
+
+func (s *sqlStore) APIKeyByHash(hash string) (APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", hash))
+	if err == sql.ErrNoRows {
+		return key, ErrNotFound
+	}
+	if err != nil {
+		return key, fmt.Errorf("failed to query api key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *sqlStore) ListAPIKeys() ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *sqlStore) RevokeAPIKey(id string, at time.Time) error {
+	res, err := s.db.Exec("UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", s.timeArg(at), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM api_keys WHERE id = $1)", id).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to query api key: %w", err)
+		}
+		if !exists {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) TouchAPIKey(id string, at time.Time) error {
+	if _, err := s.db.Exec("UPDATE api_keys SET last_used_at = $1 WHERE id = $2", s.timeArg(at), id); err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+	return nil
+}
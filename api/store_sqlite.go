@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDialect is the embedded backend used for local development and self-hosting.
+// Categories are stored as JSON text and timestamps as UTC text, which sorts correctly.
+var sqliteDialect = &sqlDialect{
+	driver: "sqlite",
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+	migrations: []migration{
+		{version: 1, name: "initial schema", statements: []string{
+			`CREATE TABLE IF NOT EXISTS products (
+				product_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				price NUMERIC(10,2) NOT NULL,
+				url TEXT NOT NULL,
+				category TEXT NOT NULL,
+				datetime DATE NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS products_product_id_idx ON products (product_id)`,
+			`CREATE INDEX IF NOT EXISTS products_datetime_idx ON products (datetime)`,
+			`CREATE TABLE IF NOT EXISTS scraper (
+				datetime DATE NOT NULL,
+				scraper_version TEXT NOT NULL,
+				total_products INTEGER NOT NULL,
+				total_failed INTEGER NOT NULL,
+				categories_scraped INTEGER NOT NULL,
+				categories TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS stats (
+				product_id TEXT NOT NULL UNIQUE,
+				lowest_price NUMERIC(10,2) NOT NULL,
+				lowest_price_datetime DATE NOT NULL,
+				highest_price NUMERIC(10,2) NOT NULL,
+				highest_price_datetime DATE NOT NULL,
+				regular_price NUMERIC(10,2) NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS images (
+				product_id TEXT NOT NULL UNIQUE,
+				image BLOB NOT NULL,
+				last_updated DATE DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS categories (
+				category TEXT NOT NULL UNIQUE
+			)`,
+			`CREATE TABLE IF NOT EXISTS hidden_products (
+				product_id TEXT PRIMARY KEY,
+				reason TEXT NOT NULL,
+				hidden_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				at DATETIME NOT NULL,
+				actor TEXT NOT NULL,
+				action TEXT NOT NULL,
+				target TEXT NOT NULL,
+				details TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME,
+				last_used_at DATETIME,
+				revoked_at DATETIME
+			)`,
+		}},
+		// SQLite already keeps full timestamps in its DATE columns; the version is recorded
+		// so both backends report the same schema history
+		{version: 2, name: "timestamp columns"},
+		{version: 3, name: "price changes", statements: []string{
+			`CREATE TABLE IF NOT EXISTS price_changes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				product_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				category TEXT NOT NULL,
+				type TEXT NOT NULL,
+				old_price NUMERIC(10,2),
+				new_price NUMERIC(10,2),
+				percent_change NUMERIC(10,2),
+				direction TEXT NOT NULL,
+				datetime DATE NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS price_changes_datetime_idx ON price_changes (datetime)`,
+		}},
+		{version: 4, name: "price change links and all-time lows", statements: []string{
+			`ALTER TABLE price_changes ADD COLUMN url TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE price_changes ADD COLUMN all_time_low BOOLEAN NOT NULL DEFAULT 0`,
+		}},
+		{version: 5, name: "product lifecycle", statements: []string{
+			`CREATE TABLE IF NOT EXISTS product_lifecycle (
+				product_id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				url TEXT NOT NULL,
+				category TEXT NOT NULL,
+				price NUMERIC(10,2) NOT NULL,
+				first_seen DATE NOT NULL,
+				last_seen DATE NOT NULL,
+				missed_runs INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT 'active'
+			)`,
+			`CREATE INDEX IF NOT EXISTS product_lifecycle_status_idx ON product_lifecycle (status)`,
+			// Seed from the existing history; statuses are filled in by the next ingest.
+			// WHERE true stops SQLite reading ON CONFLICT as part of the join.
+			`INSERT INTO product_lifecycle (product_id, name, url, category, price, first_seen, last_seen)
+			SELECT p.product_id, p.name, p.url, p.category, p.price, span.first_seen, span.last_seen
+			FROM products p
+			JOIN (
+				SELECT product_id, MIN(datetime) AS first_seen, MAX(datetime) AS last_seen
+				FROM products GROUP BY product_id
+			) span ON span.product_id = p.product_id AND p.datetime = span.last_seen
+			WHERE true
+			ON CONFLICT (product_id) DO NOTHING`,
+		}},
+		// Filled in by the next ingest of each product, or by recompute-stats
+		{version: 6, name: "extended stats", statements: []string{
+			`ALTER TABLE stats ADD COLUMN average_price REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN median_price REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN sale_days_percent REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN sale_episodes INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN average_sale_depth REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN longest_sale_days INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN shortest_sale_days INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN last_sale_datetime DATE`,
+			`ALTER TABLE stats ADD COLUMN low_30_day NUMERIC(10,2) NOT NULL DEFAULT 0`,
+			`ALTER TABLE stats ADD COLUMN low_90_day NUMERIC(10,2) NOT NULL DEFAULT 0`,
+		}},
+		{version: 7, name: "scrape duration", statements: []string{
+			`ALTER TABLE scraper ADD COLUMN duration_seconds REAL NOT NULL DEFAULT 0`,
+		}},
+		// SQLite can't add a primary key to a table, so the scraper table is rebuilt with
+		// one, numbering existing runs in time order. Their per-category product counts
+		// are rebuilt from the products stored at their time, without failures.
+		{version: 8, name: "scraper run categories", statements: []string{
+			`CREATE TABLE scraper_runs (
+				id INTEGER PRIMARY KEY,
+				datetime DATE NOT NULL,
+				scraper_version TEXT NOT NULL,
+				total_products INTEGER NOT NULL,
+				total_failed INTEGER NOT NULL,
+				categories_scraped INTEGER NOT NULL,
+				categories TEXT NOT NULL,
+				duration_seconds REAL NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO scraper_runs (datetime, scraper_version, total_products, total_failed, categories_scraped, categories, duration_seconds)
+			SELECT datetime, scraper_version, total_products, total_failed, categories_scraped, categories, duration_seconds
+			FROM scraper ORDER BY datetime`,
+			`DROP TABLE scraper`,
+			`ALTER TABLE scraper_runs RENAME TO scraper`,
+			`CREATE TABLE IF NOT EXISTS scraper_categories (
+				run_id INTEGER NOT NULL,
+				category TEXT NOT NULL,
+				products INTEGER NOT NULL,
+				failed INTEGER NOT NULL,
+				PRIMARY KEY (run_id, category)
+			)`,
+			`INSERT INTO scraper_categories (run_id, category, products, failed)
+			SELECT s.id, c.value, COUNT(*), 0
+			FROM scraper s
+			JOIN products p ON p.datetime = s.datetime
+			JOIN json_each(p.category) c
+			GROUP BY s.id, c.value`,
+			// Categories a run scraped without finding any products, split from its
+			// comma-separated list
+			`INSERT INTO scraper_categories (run_id, category, products, failed)
+			WITH RECURSIVE split(run_id, category, rest) AS (
+				SELECT id, '', categories || ',' FROM scraper
+				UNION ALL
+				SELECT run_id, substr(rest, 1, instr(rest, ',') - 1), substr(rest, instr(rest, ',') + 1)
+				FROM split WHERE rest <> ''
+			)
+			SELECT run_id, category, 0, 0 FROM split WHERE category <> ''
+			ON CONFLICT (run_id, category) DO NOTHING`,
+		}},
+		{version: 9, name: "ingest quarantine", statements: []string{
+			`CREATE TABLE IF NOT EXISTS quarantined_scrapes (
+				id INTEGER PRIMARY KEY,
+				datetime DATE NOT NULL,
+				received_at DATE NOT NULL,
+				scraper_version TEXT NOT NULL,
+				products INTEGER NOT NULL,
+				anomalies TEXT NOT NULL,
+				archive BLOB,
+				status TEXT NOT NULL DEFAULT 'pending',
+				resolved_at DATE,
+				resolved_by TEXT NOT NULL DEFAULT ''
+			)`,
+		}},
+		// Postgres catching up on the products indexes created with the initial schema
+		{version: 10, name: "product indexes", statements: []string{
+			`CREATE INDEX IF NOT EXISTS products_product_id_idx ON products (product_id)`,
+			`CREATE INDEX IF NOT EXISTS products_datetime_idx ON products (datetime)`,
+		}},
+	},
+	categoryFilter: `EXISTS (SELECT 1 FROM json_each(p.category) WHERE json_each.value = %s)`,
+	// Scalar MIN/MAX stand in for LEAST/GREATEST
+	statsUpsert: `
+		INSERT INTO stats (product_id, lowest_price, lowest_price_datetime, highest_price, highest_price_datetime, regular_price)
+		VALUES ($1, $2, $3, $2, $3, $2)
+		ON CONFLICT (product_id) DO UPDATE SET
+			lowest_price = MIN(stats.lowest_price, excluded.lowest_price),
+			lowest_price_datetime = CASE WHEN excluded.lowest_price < stats.lowest_price
+				OR (excluded.lowest_price = stats.lowest_price AND excluded.lowest_price_datetime < stats.lowest_price_datetime)
+				THEN excluded.lowest_price_datetime ELSE stats.lowest_price_datetime END,
+			highest_price = MAX(stats.highest_price, excluded.highest_price),
+			highest_price_datetime = CASE WHEN excluded.highest_price > stats.highest_price
+				OR (excluded.highest_price = stats.highest_price AND excluded.highest_price_datetime < stats.highest_price_datetime)
+				THEN excluded.highest_price_datetime ELSE stats.highest_price_datetime END
+	`,
+	imageUpsert: `
+		INSERT INTO images (product_id, image) VALUES ($1, $2)
+		ON CONFLICT (product_id) DO UPDATE SET image = excluded.image, last_updated = CURRENT_TIMESTAMP
+	`,
+}
+
+// sqliteReaders caps the read-only connections used for exports
+const sqliteReaders = 4
+
+// newSQLiteStore opens (creating if needed) the SQLite database file at path
+func newSQLiteStore(path string) (*sqlStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite database path must be set, e.g. sqlite://tracker.db")
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	s, err := newSQLStore(sqliteDialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serializing connections avoids SQLITE_BUSY during ingest
+	s.db.SetMaxOpenConns(1)
+
+	// Exports get read-only connections of their own, which WAL lets run alongside the
+	// writer, so a slow download doesn't hold up every other request
+	reader, err := newSQLStore(sqliteDialect, "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	reader.db.SetMaxOpenConns(sqliteReaders)
+	s.reader = reader.db
+	return s, nil
+}
+
+// sqlitePath extracts the file path from a sqlite:// database URL
+func sqlitePath(databaseURL string) (string, bool) {
+	return strings.CutPrefix(databaseURL, "sqlite://")
+}
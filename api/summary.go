@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummaryResponse is the body returned by the summary endpoint, the site-wide numbers
+// behind the statistics banner. Sales, discounts and all-time lows are counted over the
+// listed products. NewToday and DropsSinceYesterday count back from the newest scrape
+// run rather than the clock, so they stay meaningful when a scrape is late.
+type SummaryResponse struct {
+	TotalProducts         int     `json:"total_products"`
+	ListedProducts        int     `json:"listed_products"`
+	OnSale                int     `json:"on_sale"`
+	AverageDiscount       float64 `json:"average_discount_percent"`
+	AllTimeLows           int     `json:"all_time_lows"`
+	NewToday              int     `json:"new_today"`
+	DropsSinceYesterday   int     `json:"drops_since_yesterday"`
+	LastScrape            *string `json:"last_scrape"`
+	ScraperVersion        string  `json:"scraper_version"`
+	ScrapeDurationSeconds float64 `json:"scrape_duration_seconds"`
+	ComputedAt            string  `json:"computed_at"`
+}
+
+// summaryCache holds the summary computed by the last ingest. Corrections drop it and
+// the next request recomputes it.
+var summaryCache struct {
+	mu   sync.RWMutex
+	data *SummaryResponse
+}
+
+// computeSummary builds the site-wide summary from the latest snapshot, the product
+// lifecycles, the price change log and the newest scraper run
+func computeSummary() (SummaryResponse, error) {
+	summary := SummaryResponse{ComputedAt: formatTimestamp(time.Now())}
+
+	lifecycles, err := visibleLifecycles("", "")
+	if err != nil {
+		return summary, err
+	}
+	summary.TotalProducts = len(lifecycles)
+	visible := make(map[string]bool, len(lifecycles))
+	for _, l := range lifecycles {
+		visible[l.ProductID] = true
+	}
+
+	snapshot, err := latestSnapshot("")
+	if err != nil {
+		return summary, err
+	}
+	if snapshot.Datetime.IsZero() {
+		return summary, nil
+	}
+	prices := make([]float64, 0, len(snapshot.Products))
+	regular := make([]float64, 0, len(snapshot.Products))
+	for _, p := range snapshot.Products {
+		prices = append(prices, p.Price)
+		regular = append(regular, p.RegularPrice)
+		if p.IsAllTimeLow {
+			summary.AllTimeLows++
+		}
+	}
+	discounts := summarizeDiscounts(prices, regular)
+	summary.ListedProducts = len(snapshot.Products)
+	summary.OnSale = discounts.onSale
+	summary.AverageDiscount = discounts.average
+
+	today := bucketStart(snapshot.Datetime, IntervalDay)
+	for _, l := range lifecycles {
+		if bucketStart(l.FirstSeen, IntervalDay).Equal(today) {
+			summary.NewToday++
+		}
+	}
+
+	// Drops over the 24 hours up to the newest run, leaving out a run exactly a day
+	// earlier so a daily scrape doesn't count the previous day's drops again
+	_, until := runWindow(snapshot.Datetime)
+	since := until.Add(-24 * time.Hour)
+	drops, err := store.PriceChanges(PriceChangeFilter{Type: ChangeTypePrice, Direction: "down", Since: since, Until: until})
+	if err != nil {
+		return summary, err
+	}
+	dropped := make(map[string]bool)
+	for _, d := range drops {
+		if visible[d.ProductID] {
+			dropped[d.ProductID] = true
+		}
+	}
+	summary.DropsSinceYesterday = len(dropped)
+
+	// The scraper table has the run's details; runs ingested without metadata only have a time
+	summary.LastScrape = formatDatetime(snapshot.Datetime)
+	runs, err := store.ScraperRuns()
+	if err != nil {
+		return summary, err
+	}
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+		summary.LastScrape = formatDatetime(last.Datetime)
+		summary.ScraperVersion = last.ScraperVersion
+		summary.ScrapeDurationSeconds = last.DurationSeconds
+	}
+	return summary, nil
+}
+
+// refreshSummary recomputes the cached summary and returns it
+func refreshSummary() (SummaryResponse, error) {
+	summary, err := computeSummary()
+	if err != nil {
+		return summary, fmt.Errorf("failed to compute summary: %w", err)
+	}
+	summaryCache.mu.Lock()
+	summaryCache.data = &summary
+	summaryCache.mu.Unlock()
+	return summary, nil
+}
+
+// getSummary returns the site-wide summary computed at the last ingest
+func getSummary(c *gin.Context) {
+	summaryCache.mu.RLock()
+	cached := summaryCache.data
+	summaryCache.mu.RUnlock()
+	if cached != nil {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
+
+	summary, err := refreshSummary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute summary"})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
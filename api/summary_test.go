@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSummary(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, router *gin.Engine) {
+		runs := []struct {
+			datetime string
+			products map[string]string
+		}{
+			{"2025-01-01T06:00:00Z", map[string]string{"A100": "29.90", "B200": "24.90"}},
+			{"2025-01-02T06:00:00Z", map[string]string{"A100": "29.90", "B200": "19.90"}},
+			{"2025-01-02T18:00:00Z", map[string]string{"A100": "29.90", "B200": "17.90"}},
+			{"2025-01-03T06:00:00Z", map[string]string{"A100": "19.90", "B200": "17.90", "C300": "9.90"}},
+		}
+		for _, r := range runs {
+			output := scrapeOutput(r.datetime, map[string]map[string]string{"men/tops": r.products})
+			output["metadata"].(map[string]any)["duration_seconds"] = 123.5
+			if rec := ingest(t, router, buildScrapeZip(t, output, nil)); rec.Code != http.StatusOK {
+				t.Fatalf("ingest failed: %d %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		var summary SummaryResponse
+		if rec := get(t, router, "/api/v1/summary", &summary); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+		if summary.TotalProducts != 3 || summary.ListedProducts != 3 || summary.OnSale != 1 || summary.AverageDiscount != 33.44 {
+			t.Errorf("expected 3 products with 1 on sale at 33.44%% off, got %+v", summary)
+		}
+		// B200's evening drop is within a day of the last run and counts; its drop on the
+		// 2nd was exactly a day before and isn't counted again
+		if summary.AllTimeLows != 1 || summary.NewToday != 1 || summary.DropsSinceYesterday != 2 {
+			t.Errorf("expected 1 all-time low, 1 new product and 2 drops, got %+v", summary)
+		}
+		if summary.LastScrape == nil || *summary.LastScrape != "2025-01-03T06:00:00Z" || summary.ScraperVersion != "test" || summary.ScrapeDurationSeconds != 123.5 {
+			t.Errorf("expected the last scrape's details, got %+v", summary)
+		}
+
+		// Corrections drop the cached summary
+		key := newTestKey(t, ScopeAdmin)
+		if rec := do(t, router, http.MethodPut, "/api/v1/admin/products/C300/hidden", key, HideProductRequest{Reason: "test"}); rec.Code != http.StatusOK {
+			t.Fatalf("hide failed: %d %s", rec.Code, rec.Body.String())
+		}
+		get(t, router, "/api/v1/summary", &summary)
+		if summary.TotalProducts != 2 || summary.NewToday != 0 {
+			t.Errorf("expected the hidden product to drop out, got %+v", summary)
+		}
+	})
+}
@@ -6,7 +6,7 @@ export const getProducts = () => {
   return useQuery({
     queryKey: ['products'],
     queryFn: async () => {
-      const res = await fetch(`${API_URL}/products`)
+      const res = await fetch(`${API_URL}/v1/products`)
       if (!res.ok) {
         const body = await res.json().catch(() => ({}))
         throw new Error(body?.error || `HTTP ${res.status}`)
@@ -28,7 +28,7 @@ export const getCategories = () => {
   return useQuery({
     queryKey: ['categories'],
     queryFn: async () => {
-      const res = await fetch(`${API_URL}/categories`)
+      const res = await fetch(`${API_URL}/v1/categories`)
       if (!res.ok) {
         const body = await res.json().catch(() => ({}))
         throw new Error(body?.error || `HTTP ${res.status}`)
@@ -44,7 +44,7 @@ export const getProductDetail = (product_id: string) => {
   return useQuery({
     queryKey: ['product', product_id],
     queryFn: async () => {
-      const res = await fetch(`${API_URL}/product/${product_id}`)
+      const res = await fetch(`${API_URL}/v1/product/${product_id}`)
       if (!res.ok) {
         const body = await res.json().catch(() => ({}))
         throw new Error(body?.error || `HTTP ${res.status}`)
@@ -60,7 +60,7 @@ export const getProductDetail = (product_id: string) => {
 export const getImage = (product_id: string, { enabled = true }: { enabled?: boolean } = {}) => {
   return useQuery({
     queryKey: ['image', product_id],
-    queryFn: () => fetch(`${API_URL}/product/${product_id}/image`).then(res => res.blob()).then(blob => URL.createObjectURL(blob)),
+    queryFn: () => fetch(`${API_URL}/v1/product/${product_id}/image`).then(res => res.blob()).then(blob => URL.createObjectURL(blob)),
     staleTime: 1000 * 60 * 60, // 1 hour
     enabled,
     retry: 2,
@@ -177,7 +177,7 @@ def scrape_url(url, worker_id):
 
     except Exception as e:
         print(f"[Worker {worker_id}] ERROR scraping {url_key}: {e}")
-        return url_key, 0, 0
+        return url_key, 0, -1
 
     finally:
         if driver:
@@ -199,6 +199,7 @@ def main():
     total_products = 0
     total_failed = 0
     categories_scraped = []
+    category_counts = {}
 
     with ThreadPoolExecutor(max_workers=MAX_WORKERS) as executor:
         futures = {
@@ -210,12 +211,20 @@ def main():
             url = futures[future]
             try:
                 url_key, count, failed = future.result()
-                total_products += count
-                total_failed += failed
-                categories_scraped.append(url_key)
-                print(f"INFO: Finished {url_key} with {count} products")
             except Exception as e:
                 print(f"ERROR: {url} generated an exception: {e}")
+                url_key, count, failed = url.split('https://www.uniqlo.com/ca/en/')[1].rstrip('/'), 0, -1
+
+            # A category that couldn't be scraped at all is reported with failed -1 and
+            # left out of categories, so the API doesn't count its products as missing
+            category_counts[url_key] = {"products": count, "failed": failed}
+            if failed < 0:
+                print(f"WARNING: {url_key} could not be scraped")
+                continue
+            total_products += count
+            total_failed += failed
+            categories_scraped.append(url_key)
+            print(f"INFO: Finished {url_key} with {count} products")
 
     end_time = time.time()
     duration_seconds = round(end_time - start_time, 2)
@@ -232,7 +241,8 @@ def main():
             "total_products": total_products,
             "total_failed": total_failed,
             "categories_scraped": len(categories_scraped),
-            "categories": categories_scraped
+            "categories": categories_scraped,
+            "category_counts": category_counts
         },
         "products": PRICES
     }